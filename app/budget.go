@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/clocklear/texttrove/pkg/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// contextWindow returns the messages of the chat that fit the context window.  With a summarizer, turns that don't
+// fit are summarized instead of dropped; since that prompts the LLM, it's only called from commands.
+func (m Model) contextWindow(ctx context.Context, chat *models.Chat) []llms.MessageContent {
+	w := chat.Window(m.cfg.Budget)
+	if len(w.Dropped) == 0 || m.cfg.Summarizer == nil {
+		return w.Messages
+	}
+	previous, _ := chat.Summary()
+	summary, err := m.summarize(ctx, previous, w.Dropped)
+	if err != nil {
+		if ctx.Err() == nil {
+			m.Log(fmt.Sprintf("err: failed to summarize earlier messages; leaving them out: %v", err))
+		}
+		return w.Messages
+	}
+	m.Log(fmt.Sprintf("Summarized %d earlier turn(s) to fit the context window", w.Usage.DroppedTurns))
+	chat.SetSummary(summary, w.DroppedThrough)
+	return w.WithSummary(summary)
+}
+
+// summarize runs the summarizer, reporting a panic as an error; see recoverCancelled.
+func (m Model) summarize(ctx context.Context, previous string, msgs []llms.MessageContent) (summary string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ctx.Err()
+			if err == nil {
+				err = fmt.Errorf("request to the LLM failed: %v", r)
+			}
+		}
+	}()
+	return m.cfg.Summarizer.Summarize(ctx, previous, msgs)
+}
+
+// budgetView shows how much of the context window the active chat takes up, and what was left out to make it fit.
+func (m Model) budgetView() string {
+	if m.cfg.Budget.Limit == 0 {
+		return ""
+	}
+	u := m.activeTab().windowUsage(m.cfg.Budget)
+	s := fmt.Sprintf("ctx %d/%d", u.Tokens, u.Limit)
+	var notes []string
+	if u.Summarized {
+		notes = append(notes, "summarized")
+	}
+	if u.DroppedTurns > 0 {
+		notes = append(notes, fmt.Sprintf("%d turn(s) dropped", u.DroppedTurns))
+	}
+	if u.DroppedContexts > 0 {
+		notes = append(notes, fmt.Sprintf("%d context(s) dropped", u.DroppedContexts))
+	}
+	for i, n := range notes {
+		if i == 0 {
+			s += " ("
+		} else {
+			s += ", "
+		}
+		s += n
+	}
+	if len(notes) > 0 {
+		s += ")"
+	}
+	return s
+}
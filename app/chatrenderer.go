@@ -4,27 +4,54 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/clocklear/texttrove/pkg/agent"
+	"github.com/clocklear/texttrove/pkg/db/rag"
 	"github.com/clocklear/texttrove/pkg/models"
 
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss/v2"
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
 )
 
 type chatRenderer struct {
 	senderStyle      lipgloss.Style
 	llmStyle         lipgloss.Style
 	errorStyle       lipgloss.Style
+	toolStyle        lipgloss.Style
 	markdownRenderer *glamour.TermRenderer
 	showPrompt       bool
+	expandTools      bool
+	// documentPath and obsidianVault decide where citations link to
+	documentPath  string
+	obsidianVault string
 }
 
 func (r *chatRenderer) Render(c *models.Chat) string {
 	var buf strings.Builder
-	for _, m := range c.Log() {
-		s, err := r.renderMessageContent(&m)
-		c.SetError(err)
+	for i, m := range c.Log() {
+		var cites *citations
+		if m.Role == llms.ChatMessageTypeAI {
+			cites = answerCitations(c, i)
+		}
+		s, err := r.renderMessageContent(&m, cites)
+		if err != nil {
+			c.SetError(err)
+		}
 		buf.WriteString(s)
+		if r.showPrompt {
+			if rc, ok := c.Context(i); ok {
+				buf.WriteString(r.renderRetrieval(rc.Documents))
+				if rc.SupersededBy > 0 {
+					buf.WriteString(r.toolStyle.Render("  (superseded; no longer sent to the model)"))
+					buf.WriteString("\n\n")
+				}
+			}
+		}
+		if c.IsInterrupted(i) {
+			buf.WriteString(r.toolStyle.Render("  (interrupted)"))
+			buf.WriteString("\n\n")
+		}
 	}
 
 	// If there is an error, render that as well
@@ -35,7 +62,8 @@ func (r *chatRenderer) Render(c *models.Chat) string {
 	return buf.String()
 }
 
-func (r *chatRenderer) renderMessageContent(m *llms.MessageContent) (string, error) {
+// renderMessageContent renders a message; citations in answers are numbered and linked to the notes in cites.
+func (r *chatRenderer) renderMessageContent(m *llms.MessageContent, cites *citations) (string, error) {
 	var outputBuf, messageBuf strings.Builder
 
 	// Start by writing the role of the message
@@ -44,6 +72,8 @@ func (r *chatRenderer) renderMessageContent(m *llms.MessageContent) (string, err
 		outputBuf.WriteString(r.senderStyle.Render("You: "))
 	case llms.ChatMessageTypeAI:
 		outputBuf.WriteString(r.llmStyle.Render("AI: "))
+	case llms.ChatMessageTypeTool:
+		return r.renderToolResults(m)
 	case llms.ChatMessageTypeSystem:
 		if r.showPrompt {
 			outputBuf.WriteString(r.llmStyle.Render("System: "))
@@ -59,8 +89,13 @@ func (r *chatRenderer) renderMessageContent(m *llms.MessageContent) (string, err
 	// Each of the parts _might_ implement Stringer.
 	// If it does, call it and append the value to the buffer.
 	// If it doesn't, append the type name.
+	var toolCalls []llms.ToolCall
 	for _, part := range m.Parts {
 		switch p := part.(type) {
+		case llms.ToolCall:
+			// Tool calls are rendered after the message text
+			toolCalls = append(toolCalls, p)
+			continue
 		case fmt.Stringer:
 			_, err := messageBuf.WriteString(p.String())
 			if err != nil {
@@ -77,14 +112,69 @@ func (r *chatRenderer) renderMessageContent(m *llms.MessageContent) (string, err
 	}
 
 	// Pass the message through the markdown renderer
-	message, err := r.markdownRenderer.Render(messageBuf.String())
+	text := messageBuf.String()
+	if cites != nil {
+		text = cites.mark(text)
+	}
+	message, err := r.markdownRenderer.Render(text)
 	if err != nil {
 		return "", err
 	}
 
 	// Append the rendered message to the output buffer
-	outputBuf.WriteString(message)
+	if cites != nil {
+		outputBuf.WriteString(r.linkCitations(message, cites))
+		outputBuf.WriteString(r.renderFootnotes(cites))
+	} else {
+		outputBuf.WriteString(message)
+	}
+
+	for _, tc := range toolCalls {
+		outputBuf.WriteString(r.toolStyle.Render(fmt.Sprintf("  ⚙ %s(%q)", tc.FunctionCall.Name, agent.ToolInput(tc))))
+		outputBuf.WriteString("\n")
+	}
 
 	// Render the output buffer
 	return outputBuf.String(), nil
 }
+
+// renderRetrieval lists the documents retrieved for a message with their scores, and where they ranked before
+// reranking, to help tune retrieval.
+func (r *chatRenderer) renderRetrieval(docs []schema.Document) string {
+	var outputBuf strings.Builder
+	for i, d := range docs {
+		line := fmt.Sprintf("  %d. [%.4f] %v", i+1, d.Score, d.Metadata["Source"])
+		if rank, ok := d.Metadata[rag.MetadataRetrievalRank]; ok {
+			line += fmt.Sprintf(" (retrieved #%v, %.4f)", rank, d.Metadata[rag.MetadataRetrievalScore])
+		}
+		outputBuf.WriteString(r.toolStyle.Render(line))
+		outputBuf.WriteString("\n")
+	}
+	outputBuf.WriteString("\n")
+	return outputBuf.String()
+}
+
+// renderToolResults renders the results of tool calls, collapsed to a single line unless expandTools is set.
+func (r *chatRenderer) renderToolResults(m *llms.MessageContent) (string, error) {
+	var outputBuf strings.Builder
+	for _, part := range m.Parts {
+		p, ok := part.(llms.ToolCallResponse)
+		if !ok {
+			continue
+		}
+		content := strings.TrimSpace(p.Content)
+		lines := strings.Count(content, "\n") + 1
+		if !r.expandTools {
+			outputBuf.WriteString(r.toolStyle.Render(fmt.Sprintf("  ↳ %s returned %d line(s) (collapsed)", p.Name, lines)))
+			outputBuf.WriteString("\n\n")
+			continue
+		}
+		outputBuf.WriteString(r.toolStyle.Render(fmt.Sprintf("  ↳ %s returned:", p.Name)))
+		message, err := r.markdownRenderer.Render(content)
+		if err != nil {
+			return "", err
+		}
+		outputBuf.WriteString(message)
+	}
+	return outputBuf.String(), nil
+}
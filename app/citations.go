@@ -0,0 +1,206 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/clocklear/texttrove/pkg/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+var (
+	// citationPattern matches note paths like notes/project/plan.md; paths with spaces are only found when they were
+	// retrieved, see citations.mark
+	citationPattern = regexp.MustCompile(`/?(?:[\p{L}\p{N}_\-.~]+/)*[\p{L}\p{N}_\-.~]+\.md\b`)
+	// markdownLinkPattern matches markdown links to notes, which are cited by their target
+	markdownLinkPattern = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+\.md)\)`)
+	// wikilinkPattern matches Obsidian-style links, e.g. [[plan]] or [[project/plan#Goals|the plan]]
+	wikilinkPattern = regexp.MustCompile(`\[\[([^\]|#]+)(?:[#|][^\]]*)?\]\]`)
+	// sourceLinePattern matches the Source line of the metadata footer of fragments returned by tools
+	sourceLinePattern = regexp.MustCompile(`(?m)^Source: (.+)$`)
+	// codePattern matches fenced code blocks (up to the end of the text while one is still being streamed) and inline
+	// code, in which paths aren't citations
+	codePattern = regexp.MustCompile("(?ms)^ {0,3}```.*?(?:^ {0,3}```[^\n]*$|\\z)|^ {0,3}~~~.*?(?:^ {0,3}~~~[^\n]*$|\\z)|`[^`\n]+`")
+)
+
+// Markers survive markdown rendering unchanged (unlike brackets, which the parser splits text at), and are swapped
+// for links afterwards.  They're as wide as what replaces them, so lines wrap the same.
+const unverifiedMarker = "‡?‡"
+
+func citationMarker(n int) string {
+	return fmt.Sprintf("‡%d‡", n)
+}
+
+// citations tracks the notes an answer cites.  Citations of notes that were retrieved for the answer are numbered in
+// the order they're first cited; others are flagged as unverified.
+type citations struct {
+	// sources holds the paths of the notes retrieved for the answer
+	sources    []string
+	cited      []string
+	unverified []string
+	// held keeps code, link text and unverified citations out of reach of later passes of mark; see hold
+	held []string
+}
+
+// answerCitations collects the notes retrieved for the answer at index i: the contexts sent along with it, and (for
+// the agent) the results of the tool calls leading up to it.
+func answerCitations(chat *models.Chat, i int) *citations {
+	c := &citations{}
+	add := func(path string) {
+		path = strings.TrimPrefix(strings.TrimSpace(path), "/")
+		if path != "" && !containsFold(c.sources, path) {
+			c.sources = append(c.sources, path)
+		}
+	}
+	for _, d := range chat.Sources(i) {
+		add(fmt.Sprint(d.Metadata["Source"]))
+	}
+	log := chat.Log()
+	for j := i - 1; j >= 0 && log[j].Role != llms.ChatMessageTypeHuman; j-- {
+		for _, part := range log[j].Parts {
+			if p, ok := part.(llms.ToolCallResponse); ok {
+				for _, m := range sourceLinePattern.FindAllStringSubmatch(p.Content, -1) {
+					add(m[1])
+				}
+			}
+		}
+	}
+	return c
+}
+
+// resolve returns the retrieved note the given path refers to.  Models tend to add or drop leading folders, so paths
+// match when one ends with the other.
+func (c *citations) resolve(path string) (string, bool) {
+	p := strings.ToLower(strings.TrimPrefix(path, "/"))
+	for _, s := range c.sources {
+		ls := strings.ToLower(s)
+		if p == ls || strings.HasSuffix(p, "/"+ls) || strings.HasSuffix(ls, "/"+p) {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// cite records a citation of the given path and returns what it's replaced with in the answer.
+func (c *citations) cite(text, path string) string {
+	s, ok := c.resolve(path)
+	if !ok {
+		if !containsFold(c.unverified, path) {
+			c.unverified = append(c.unverified, path)
+		}
+		return c.hold(text + unverifiedMarker)
+	}
+	for n, cited := range c.cited {
+		if cited == s {
+			return citationMarker(n + 1)
+		}
+	}
+	c.cited = append(c.cited, s)
+	return citationMarker(len(c.cited))
+}
+
+// hold returns a placeholder for text, which mark puts back once it's done.
+func (c *citations) hold(text string) string {
+	c.held = append(c.held, text)
+	return fmt.Sprintf("\x00%d\x00", len(c.held)-1)
+}
+
+// mark replaces the citations in the (markdown) text of an answer with markers.  Paths in code aren't citations.
+func (c *citations) mark(text string) string {
+	text = codePattern.ReplaceAllStringFunc(text, c.hold)
+	text = markdownLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		m := markdownLinkPattern.FindStringSubmatch(link)
+		if m[1] == "" || m[1] == m[2] {
+			return c.cite(m[2], m[2])
+		}
+		// The link text may name the note too, which is cited once already
+		return c.hold(m[1]) + " " + c.cite(m[2], m[2])
+	})
+	text = wikilinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		name := strings.TrimSpace(wikilinkPattern.FindStringSubmatch(link)[1])
+		if !strings.HasSuffix(strings.ToLower(name), ".md") {
+			name += ".md"
+		}
+		return c.cite(link, name)
+	})
+	// Retrieved paths with spaces in them aren't matched by the pattern
+	for _, s := range c.sources {
+		if strings.Contains(s, " ") && strings.Contains(text, s) {
+			text = strings.ReplaceAll(text, s, c.cite(s, s))
+		}
+	}
+	var sb strings.Builder
+	last := 0
+	for _, loc := range citationPattern.FindAllStringIndex(text, -1) {
+		if loc[0] > 0 && strings.ContainsRune("/:", rune(text[loc[0]-1])) {
+			// Part of a URL
+			continue
+		}
+		sb.WriteString(text[last:loc[0]])
+		sb.WriteString(c.cite(text[loc[0]:loc[1]], text[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	sb.WriteString(text[last:])
+	text = sb.String()
+	// Later text may hold earlier placeholders, e.g. link text with code in it
+	for n := len(c.held) - 1; n >= 0; n-- {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", n), c.held[n], 1)
+	}
+	return text
+}
+
+// linkCitations swaps the markers in a rendered answer for hyperlinks to the cited notes and unverified flags.
+func (r *chatRenderer) linkCitations(rendered string, c *citations) string {
+	for n := len(c.cited); n > 0; n-- {
+		rendered = strings.ReplaceAll(rendered, citationMarker(n), hyperlink(r.noteURL(c.cited[n-1]), fmt.Sprintf("[%d]", n)))
+	}
+	return strings.ReplaceAll(rendered, unverifiedMarker, r.errorStyle.Render("[?]"))
+}
+
+// renderFootnotes lists the notes cited in an answer.
+func (r *chatRenderer) renderFootnotes(c *citations) string {
+	var sb strings.Builder
+	for n, s := range c.cited {
+		sb.WriteString(r.toolStyle.Render(fmt.Sprintf("  [%d] ", n+1)))
+		sb.WriteString(hyperlink(r.noteURL(s), r.toolStyle.Render(s)))
+		sb.WriteString("\n")
+	}
+	for _, s := range c.unverified {
+		sb.WriteString(r.errorStyle.Render(fmt.Sprintf("  [?] %s (not among the retrieved notes)", s)))
+		sb.WriteString("\n")
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// noteURL returns the URL a cited note links to: in the Obsidian vault if one is configured, or the file itself.
+func (r *chatRenderer) noteURL(path string) string {
+	if r.obsidianVault != "" {
+		return obsidianURI(r.obsidianVault, path, "")
+	}
+	abs, err := filepath.Abs(filepath.Join(r.documentPath, filepath.FromSlash(path)))
+	if err != nil {
+		abs = filepath.Join(r.documentPath, filepath.FromSlash(path))
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// hyperlink wraps text in an OSC 8 escape sequence, which makes it clickable in terminals that support it.
+func hyperlink(target, text string) string {
+	return "\x1b]8;;" + target + "\x1b\\" + text + "\x1b]8;;\x1b\\"
+}
+
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/clocklear/texttrove/pkg/models"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestCitationsMark(t *testing.T) {
+	sources := []string{"projects/kafka.md", "meetings/2024-03-01 standup.md", "journal.md"}
+	tests := []struct {
+		name           string
+		text           string
+		want           string
+		wantCited      []string
+		wantUnverified []string
+	}{
+		{
+			name:      "retrieved notes are numbered in the order they're cited",
+			text:      "See journal.md and projects/kafka.md, and journal.md again.",
+			want:      "See ‡1‡ and ‡2‡, and ‡1‡ again.",
+			wantCited: []string{"journal.md", "projects/kafka.md"},
+		},
+		{
+			name:      "leading folders may be added or dropped",
+			text:      "From /notes/projects/kafka.md and kafka.md",
+			want:      "From ‡1‡ and ‡1‡",
+			wantCited: []string{"projects/kafka.md"},
+		},
+		{
+			name:      "paths with spaces",
+			text:      "As said in meetings/2024-03-01 standup.md.",
+			want:      "As said in ‡1‡.",
+			wantCited: []string{"meetings/2024-03-01 standup.md"},
+		},
+		{
+			name:           "notes that weren't retrieved are flagged",
+			text:           "Per roadmap.md and journal.md",
+			want:           "Per roadmap.md‡?‡ and ‡1‡",
+			wantCited:      []string{"journal.md"},
+			wantUnverified: []string{"roadmap.md"},
+		},
+		{
+			name:           "links and wikilinks are cited by their target",
+			text:           "[the plan](projects/kafka.md), [[journal]], [[projects/kafka#Goals|goals]] and [[missing]]",
+			want:           "the plan ‡1‡, ‡2‡, ‡1‡ and [[missing]]‡?‡",
+			wantCited:      []string{"projects/kafka.md", "journal.md"},
+			wantUnverified: []string{"missing.md"},
+		},
+		{
+			name:      "link text naming the note isn't cited again",
+			text:      "[kafka.md](projects/kafka.md) and [journal.md](journal.md)",
+			want:      "kafka.md ‡1‡ and ‡2‡",
+			wantCited: []string{"projects/kafka.md", "journal.md"},
+		},
+		{
+			name:      "paths in code aren't citations",
+			text:      "Run `cat journal.md` or\n\n```sh\ncat projects/kafka.md roadmap.md\n```\n\n~~~\n[[journal]]\n~~~\nthen journal.md",
+			want:      "Run `cat journal.md` or\n\n```sh\ncat projects/kafka.md roadmap.md\n```\n\n~~~\n[[journal]]\n~~~\nthen ‡1‡",
+			wantCited: []string{"journal.md"},
+		},
+		{
+			name:      "code still being streamed",
+			text:      "See journal.md:\n```\ncat projects/kafka.md",
+			want:      "See ‡1‡:\n```\ncat projects/kafka.md",
+			wantCited: []string{"journal.md"},
+		},
+		{
+			name:      "code in link text",
+			text:      "[`kafka.md`](projects/kafka.md)",
+			want:      "`kafka.md` ‡1‡",
+			wantCited: []string{"projects/kafka.md"},
+		},
+		{
+			name: "URLs aren't citations",
+			text: "https://example.com/docs/readme.md",
+			want: "https://example.com/docs/readme.md",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &citations{sources: sources}
+			if got := c.mark(tt.text); got != tt.want {
+				t.Errorf("mark = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(c.cited, tt.wantCited) {
+				t.Errorf("cited = %q, want %q", c.cited, tt.wantCited)
+			}
+			if !slices.Equal(c.unverified, tt.wantUnverified) {
+				t.Errorf("unverified = %q, want %q", c.unverified, tt.wantUnverified)
+			}
+		})
+	}
+}
+
+func TestAnswerCitations(t *testing.T) {
+	chat, err := models.NewChat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.AppendUserMessage("what about kafka?")
+	err = chat.AddContextsForLastMessage([]schema.Document{
+		{PageContent: "retention", Metadata: map[string]any{"Source": "/projects/kafka.md"}},
+		{PageContent: "standup", Metadata: map[string]any{"Source": "meetings/standup.md"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.AppendMessage(llms.MessageContent{Role: llms.ChatMessageTypeTool, Parts: []llms.ContentPart{llms.ToolCallResponse{
+		Name:    "search",
+		Content: "brokers\nSource: projects/brokers.md\n\nkafka\nSource: /projects/kafka.md",
+	}}})
+	chat.AppendMessage(llms.TextParts(llms.ChatMessageTypeAI, "See projects/brokers.md"))
+	i := len(chat.Log()) - 1
+
+	c := answerCitations(chat, i)
+	want := []string{"projects/kafka.md", "meetings/standup.md", "projects/brokers.md"}
+	if !slices.Equal(c.sources, want) {
+		t.Errorf("sources = %q, want %q", c.sources, want)
+	}
+
+	// Tool results from an earlier turn don't count
+	chat.AppendUserMessage("and the standup?")
+	chat.AppendMessage(llms.TextParts(llms.ChatMessageTypeAI, "See meetings/standup.md"))
+	c = answerCitations(chat, i+2)
+	if _, ok := c.resolve("projects/brokers.md"); ok {
+		t.Errorf("sources = %q, want them without the earlier tool results", c.sources)
+	}
+}
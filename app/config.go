@@ -1,6 +1,9 @@
 package app
 
 import (
+	"github.com/clocklear/texttrove/pkg/agent"
+	"github.com/clocklear/texttrove/pkg/condense"
+
 	"github.com/charmbracelet/glamour"
 	"github.com/clocklear/texttrove/pkg/models"
 	"github.com/tmc/langchaingo/llms"
@@ -14,17 +17,39 @@ type Config struct {
 	SenderColor     uint
 	LLMColor        uint
 	ErrorColor      uint
+	ToolColor       uint
 	SpinnerColor    uint
 
 	Chat *models.Chat
+	// NewChat creates the chat for a newly opened tab; may be nil to disable tabs
+	NewChat func() (*models.Chat, error)
+	// ChatStore persists chats after every turn; may be nil to disable history
+	ChatStore ChatStore
 
 	// These two are used independently when the app is doing it's own RAG
 	ConversationLLM llms.Model
 	RAG             Ragger
 
-	MarkdownRenderer  *glamour.TermRenderer
-	ShowPromptInChat  bool
-	LoggerHistorySize uint
+	// Condenser, when set, rewrites each message into standalone search queries before the app does it's own RAG
+	Condenser *condense.Condenser
+
+	// Agent, when set, answers each message using tools instead of the app doing it's own RAG
+	Agent *agent.Agent
+
+	// Budget limits the messages sent to the model to what fits its context window
+	Budget models.Budget
+	// Summarizer, when set, summarizes older turns that don't fit the context window instead of dropping them
+	Summarizer *condense.Summarizer
+
+	// DocumentPath is the folder the notes are in, for opening them from the sources panel
+	DocumentPath string
+	// ObsidianVault, when set, opens notes in that Obsidian vault instead of $EDITOR
+	ObsidianVault string
+
+	MarkdownRenderer   *glamour.TermRenderer
+	ShowPromptInChat   bool
+	MaxDocumentResults int
+	LoggerHistorySize  uint
 
 	ChatSystemPromptPath  string
 	ChatContextPromptPath string
@@ -36,15 +61,17 @@ func DefaultConfig() (Config, error) {
 		return Config{}, err
 	}
 	return Config{
-		AppName:           "TextTrove",
-		ChatInputHeight:   5,
-		SenderColor:       5,   // ANSI Magenta
-		LLMColor:          4,   // ANSI Blue
-		ErrorColor:        1,   // ANSI Red
-		SpinnerColor:      69,  // ANSI Light Blue
-		LogColor:          184, // ANSI Yellow-ish
-		Keys:              DefaultKeyMap(),
-		MarkdownRenderer:  g,
-		LoggerHistorySize: 100,
+		AppName:            "TextTrove",
+		ChatInputHeight:    5,
+		SenderColor:        5,   // ANSI Magenta
+		LLMColor:           4,   // ANSI Blue
+		ErrorColor:         1,   // ANSI Red
+		ToolColor:          8,   // ANSI Grey
+		SpinnerColor:       69,  // ANSI Light Blue
+		LogColor:           184, // ANSI Yellow-ish
+		Keys:               DefaultKeyMap(),
+		MarkdownRenderer:   g,
+		MaxDocumentResults: 5,
+		LoggerHistorySize:  100,
 	}, nil
 }
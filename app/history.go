@@ -0,0 +1,185 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/clocklear/texttrove/pkg/db/chats"
+	"github.com/clocklear/texttrove/pkg/models"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+	"github.com/charmbracelet/lipgloss/v2"
+)
+
+// ChatStore describes what we expect to be true of a thing that can persist chats
+type ChatStore interface {
+	Save(r models.ChatRecord) error
+	Load(id string) (models.ChatRecord, error)
+	List() ([]chats.Summary, error)
+	Rename(id, title string) error
+	Delete(id string) error
+}
+
+// OpenChatMsg is emitted by the history browser when the user picks a chat to reopen.
+type OpenChatMsg struct {
+	record models.ChatRecord
+}
+
+// CloseHistoryMsg is emitted by the history browser when the user dismisses it.
+type CloseHistoryMsg struct{}
+
+// historyBrowser is a bubbletea component listing past chats, allowing them to be reopened, renamed and deleted.
+type historyBrowser struct {
+	store    ChatStore
+	items    []chats.Summary
+	cursor   int
+	height   int
+	width    int
+	renaming bool
+	deleting bool
+	input    string
+	err      error
+
+	selectedStyle lipgloss.Style
+	dimStyle      lipgloss.Style
+	errorStyle    lipgloss.Style
+}
+
+func newHistoryBrowser(store ChatStore, selectedStyle, dimStyle, errorStyle lipgloss.Style) historyBrowser {
+	return historyBrowser{
+		store:         store,
+		selectedStyle: selectedStyle,
+		dimStyle:      dimStyle,
+		errorStyle:    errorStyle,
+	}
+}
+
+// Refresh reloads the list of chats from the store.
+func (h *historyBrowser) Refresh() {
+	h.renaming = false
+	h.deleting = false
+	h.items, h.err = h.store.List()
+	h.cursor = min(h.cursor, max(0, len(h.items)-1))
+}
+
+func (h *historyBrowser) SetSize(width, height int) {
+	h.width = width
+	h.height = height
+}
+
+func (h historyBrowser) selected() (chats.Summary, bool) {
+	if h.cursor < 0 || h.cursor >= len(h.items) {
+		return chats.Summary{}, false
+	}
+	return h.items[h.cursor], true
+}
+
+func (h historyBrowser) Update(msg tea.Msg) (historyBrowser, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyPressMsg)
+	if !ok {
+		return h, nil
+	}
+	k := keyMsg.Key()
+
+	// Rename mode: edit the title inline
+	if h.renaming {
+		switch keyMsg.String() {
+		case "esc":
+			h.renaming = false
+		case "enter":
+			if sel, ok := h.selected(); ok {
+				h.err = h.store.Rename(sel.ID, strings.TrimSpace(h.input))
+			}
+			h.Refresh()
+		case "backspace":
+			if r := []rune(h.input); len(r) > 0 {
+				h.input = string(r[:len(r)-1])
+			}
+		default:
+			h.input += k.Text
+		}
+		return h, nil
+	}
+
+	// Delete mode: wait for confirmation
+	if h.deleting {
+		if keyMsg.String() == "y" {
+			if sel, ok := h.selected(); ok {
+				h.err = h.store.Delete(sel.ID)
+			}
+			h.Refresh()
+		}
+		h.deleting = false
+		return h, nil
+	}
+
+	switch keyMsg.String() {
+	case "up", "k":
+		h.cursor = max(0, h.cursor-1)
+	case "down", "j":
+		h.cursor = min(len(h.items)-1, h.cursor+1)
+	case "enter":
+		sel, ok := h.selected()
+		if !ok {
+			return h, nil
+		}
+		r, err := h.store.Load(sel.ID)
+		if err != nil {
+			h.err = err
+			return h, nil
+		}
+		return h, func() tea.Msg { return OpenChatMsg{record: r} }
+	case "r":
+		if sel, ok := h.selected(); ok {
+			h.renaming = true
+			h.input = sel.Title
+		}
+	case "d":
+		if _, ok := h.selected(); ok {
+			h.deleting = true
+		}
+	case "esc", "q":
+		return h, func() tea.Msg { return CloseHistoryMsg{} }
+	}
+	return h, nil
+}
+
+func (h historyBrowser) View() string {
+	lines := []string{h.dimStyle.Render("History — enter: open · r: rename · d: delete · esc: close"), ""}
+	if h.err != nil {
+		lines = append(lines, h.errorStyle.Render(h.err.Error()), "")
+	}
+	if len(h.items) == 0 {
+		lines = append(lines, h.dimStyle.Render("No saved chats yet"))
+	}
+
+	// Keep the cursor in view
+	visible := max(1, h.height-len(lines)-1)
+	start := 0
+	if h.cursor >= visible {
+		start = h.cursor - visible + 1
+	}
+	for i := start; i < len(h.items) && i < start+visible; i++ {
+		item := h.items[i]
+		title := item.Title
+		if i == h.cursor && h.renaming {
+			title = h.input + "█"
+		}
+		line := fmt.Sprintf("%s  %s  %s", item.UpdatedAt.Local().Format("2006-01-02 15:04"), title, h.dimStyle.Render(fmt.Sprintf("(%d messages, %s)", item.Messages, item.Model)))
+		if i == h.cursor {
+			line = h.selectedStyle.Render("▸ " + line)
+		} else {
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+	if h.deleting {
+		lines = append(lines, "", h.errorStyle.Render("Delete the selected chat? (y/n)"))
+	}
+
+	// Fill the available height so the layout doesn't jump
+	for len(lines) < h.height {
+		lines = append(lines, "")
+	}
+	return lipgloss.NewStyle().Width(h.width).Render(strings.Join(lines[:max(h.height, 0)], "\n"))
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/db/rag"
+)
+
+// IndexProgressMsg reports how far along the background sync of the document folder is.
+type IndexProgressMsg rag.Progress
+
+// IndexProgress forwards sync progress to the TUI; hand it to the RAG as its progress handler.
+func (m Model) IndexProgress(p rag.Progress) {
+	m.dispatchStream <- IndexProgressMsg(p)
+}
+
+// indexIncomplete reports whether the background sync is still running, meaning queries only see part of the notes.
+func (m Model) indexIncomplete() bool {
+	return m.indexing && !m.index.Done
+}
+
+// indexProgressView renders the progress of the background sync for the footer.
+func (m Model) indexProgressView() string {
+	p := m.index
+	var sb strings.Builder
+	sb.WriteString(progressBar(p.Fraction(), 20))
+	sb.WriteString(fmt.Sprintf(" %d/%d files · %d fragments", p.FilesScanned, p.FilesTotal, p.FragmentsEmbedded))
+	if eta := p.ETA(); eta > 0 {
+		sb.WriteString(fmt.Sprintf(" · ETA %s", eta.Round(time.Second)))
+	}
+	if p.Failures > 0 {
+		sb.WriteString(fmt.Sprintf(" · %d failed", p.Failures))
+	}
+	return sb.String() + " " + m.chatRenderer.errorStyle.Render("⚠ index incomplete")
+}
+
+// progressBar renders a bar width cells wide, filled to the given fraction.
+func progressBar(fraction float64, width int) string {
+	filled := int(fraction * float64(width))
+	filled = min(max(filled, 0), width)
+	return "▕" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "▏"
+}
@@ -9,8 +9,23 @@ type KeyMap struct {
 	ScrollChatDown key.Binding
 	Help           key.Binding
 	Send           key.Binding
+	Cancel         key.Binding
+	Regenerate     key.Binding
+	EditLast       key.Binding
 	NewChat        key.Binding
-	CloseChat      key.Binding // NYI
+	History        key.Binding
+	ToggleTools    key.Binding
+	NewTab         key.Binding
+	CloseChat      key.Binding
+	NextTab        key.Binding
+	PrevTab        key.Binding
+	RenameTab      key.Binding
+	ToggleSources  key.Binding
+	PrevAnswer     key.Binding
+	NextAnswer     key.Binding
+	PrevSource     key.Binding
+	NextSource     key.Binding
+	OpenSource     key.Binding
 	Quit           key.Binding
 }
 
@@ -20,8 +35,11 @@ func (k KeyMap) ShortHelp() []key.Binding {
 
 func (k KeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.ScrollChatUp, k.ScrollChatDown, k.NewChat}, // first column
-		{k.Help, k.Send, k.Quit},                      // second column
+		{k.ScrollChatUp, k.ScrollChatDown, k.NewChat, k.History, k.ToggleTools},                 // first column
+		{k.NewTab, k.CloseChat, k.NextTab, k.PrevTab, k.RenameTab},                              // second column
+		{k.Send, k.Cancel, k.Regenerate, k.EditLast},                                            // third column
+		{k.ToggleSources, k.PrevAnswer, k.NextAnswer, k.PrevSource, k.NextSource, k.OpenSource}, // fourth column
+		{k.Help, k.Quit}, // fifth column
 	}
 }
 
@@ -47,9 +65,73 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("ctrl+enter"),
 			key.WithHelp("ctrl+enter", "send message"),
 		),
+		Cancel: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "stop answer"),
+		),
+		Regenerate: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "regenerate answer"),
+		),
+		EditLast: key.NewBinding(
+			key.WithKeys("ctrl+up"),
+			key.WithHelp("ctrl+↑", "edit last message"),
+		),
 		NewChat: key.NewBinding(
 			key.WithKeys("ctrl+n"),
 			key.WithHelp("ctrl+n", "new chat"),
 		),
+		History: key.NewBinding(
+			key.WithKeys("ctrl+o"),
+			key.WithHelp("ctrl+o", "open chat history"),
+		),
+		ToggleTools: key.NewBinding(
+			key.WithKeys("ctrl+t"),
+			key.WithHelp("ctrl+t", "expand/collapse tool output"),
+		),
+		NewTab: key.NewBinding(
+			key.WithKeys("alt+t"),
+			key.WithHelp("alt+t", "new tab"),
+		),
+		CloseChat: key.NewBinding(
+			key.WithKeys("alt+w"),
+			key.WithHelp("alt+w", "close tab"),
+		),
+		NextTab: key.NewBinding(
+			key.WithKeys("alt+right"),
+			key.WithHelp("alt+→", "next tab"),
+		),
+		PrevTab: key.NewBinding(
+			key.WithKeys("alt+left"),
+			key.WithHelp("alt+←", "previous tab"),
+		),
+		RenameTab: key.NewBinding(
+			key.WithKeys("alt+r"),
+			key.WithHelp("alt+r", "rename tab"),
+		),
+		ToggleSources: key.NewBinding(
+			key.WithKeys("alt+s"),
+			key.WithHelp("alt+s", "show/hide sources"),
+		),
+		PrevAnswer: key.NewBinding(
+			key.WithKeys("alt+,"),
+			key.WithHelp("alt+,", "sources of previous answer"),
+		),
+		NextAnswer: key.NewBinding(
+			key.WithKeys("alt+."),
+			key.WithHelp("alt+.", "sources of next answer"),
+		),
+		PrevSource: key.NewBinding(
+			key.WithKeys("alt+up"),
+			key.WithHelp("alt+↑", "previous source"),
+		),
+		NextSource: key.NewBinding(
+			key.WithKeys("alt+down"),
+			key.WithHelp("alt+↓", "next source"),
+		),
+		OpenSource: key.NewBinding(
+			key.WithKeys("alt+o"),
+			key.WithHelp("alt+o", "open source note"),
+		),
 	}
 }
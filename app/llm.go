@@ -2,28 +2,142 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/agent"
+	"github.com/clocklear/texttrove/pkg/condense"
 
 	tea "github.com/charmbracelet/bubbletea/v2"
 	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
 )
 
+// LLMStreamingResponseMsg carries a piece of an answer for the chat with the given ID.
 type LLMStreamingResponseMsg struct {
+	chatID     string
 	chunk      string
 	isComplete bool
+	discard    bool
 	err        error
 }
 
-func submitChat(ctx context.Context, llm llms.Model, chatContext []llms.MessageContent, sub chan tea.Msg) tea.Cmd {
+// AgentMessageMsg carries a tool call or tool result produced by the agent.
+type AgentMessageMsg struct {
+	chatID  string
+	message llms.MessageContent
+}
+
+// ContextRetrievedMsg carries the documents retrieved for the last message of the chat with the given ID.
+type ContextRetrievedMsg struct {
+	chatID string
+	// ctx is the context of the request, which the answer is generated with
+	ctx  context.Context
+	docs []schema.Document
+	err  error
+}
+
+// retrieveContext searches the knowledge base for the given message in the background, since rewriting the query and
+// reranking can take a while.  Filter terms in the message (e.g. tag:project/x or after:2024-01-01) are stripped out
+// of it and narrow the search down.  With a condenser, the message is then rewritten into standalone queries using
+// the conversation before it; the queries and filters are logged.
+func retrieveContext(ctx context.Context, r Ragger, condenser *condense.Condenser, log func(string), chatID string, history []llms.MessageContent, message string, n int) tea.Cmd {
+	return func() (msg tea.Msg) {
+		defer func() {
+			// Both steps may be prompting the LLM; see recoverCancelled
+			if rec := recover(); rec != nil {
+				err := ctx.Err()
+				if err == nil {
+					err = fmt.Errorf("failed to search your notes: %v", rec)
+				}
+				msg = ContextRetrievedMsg{chatID: chatID, ctx: ctx, err: err}
+			}
+		}()
+		opts := r.ParseQueryOptions(message, time.Now())
+		opts.N = n
+		if opts.Filter != nil {
+			log(fmt.Sprintf("Search filter: %s", opts.Filter))
+		}
+		if opts.Dates != nil {
+			log(fmt.Sprintf("Search dates: %s (%s)", opts.Dates, opts.Dates.Phrase))
+		}
+		// The condenser rewrites what's left of the message
+		message = opts.Text
+		if condenser != nil {
+			q, err := condenser.Condense(ctx, history, message)
+			if ctx.Err() != nil {
+				return ContextRetrievedMsg{chatID: chatID, ctx: ctx, err: ctx.Err()}
+			}
+			if err != nil {
+				log(fmt.Sprintf("err: failed to rewrite the search query; using the message as is: %v", err))
+			} else {
+				opts.Text, opts.Queries = q.Query, q.SubQueries
+				log(fmt.Sprintf("Search query: %s", strings.Join(append([]string{q.Query}, q.SubQueries...), " | ")))
+			}
+		}
+		docs, err := r.QueryWithOptions(ctx, opts)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return ContextRetrievedMsg{chatID: chatID, ctx: ctx, docs: docs, err: err}
+	}
+}
+
+func submitChat(ctx context.Context, llm llms.Model, chatID string, chatContext []llms.MessageContent, sub chan tea.Msg) tea.Cmd {
 	return func() tea.Msg {
+		defer recoverCancelled(ctx, chatID, sub)
 		_, err := llm.GenerateContent(ctx, chatContext, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
-			sub <- LLMStreamingResponseMsg{chunk: string(chunk)}
-			return nil
+			sub <- LLMStreamingResponseMsg{chatID: chatID, chunk: string(chunk)}
+			// Stop reading the stream once the request has been cancelled
+			return ctx.Err()
 		}))
-		if err != nil {
-			sub <- LLMStreamingResponseMsg{err: err}
+		if ctx.Err() != nil {
+			// Backends don't reliably wrap the context's error, so report it directly
+			sub <- LLMStreamingResponseMsg{chatID: chatID, err: ctx.Err()}
+		} else if err != nil {
+			sub <- LLMStreamingResponseMsg{chatID: chatID, err: err}
 		} else {
-			sub <- LLMStreamingResponseMsg{isComplete: true}
+			sub <- LLMStreamingResponseMsg{chatID: chatID, isComplete: true}
 		}
 		return nil
 	}
 }
+
+func runAgent(ctx context.Context, a *agent.Agent, chatID string, chatContext []llms.MessageContent, sub chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		defer recoverCancelled(ctx, chatID, sub)
+		_, err := a.Run(ctx, chatContext, agent.Handler{
+			OnChunk: func(chunk string) {
+				sub <- LLMStreamingResponseMsg{chatID: chatID, chunk: chunk}
+			},
+			OnDiscard: func() {
+				sub <- LLMStreamingResponseMsg{chatID: chatID, discard: true}
+			},
+			OnMessage: func(msg llms.MessageContent) {
+				sub <- AgentMessageMsg{chatID: chatID, message: msg}
+			},
+		})
+		if ctx.Err() != nil {
+			// Backends don't reliably wrap the context's error, so report it directly
+			sub <- LLMStreamingResponseMsg{chatID: chatID, err: ctx.Err()}
+		} else if err != nil {
+			sub <- LLMStreamingResponseMsg{chatID: chatID, err: err}
+		} else {
+			sub <- LLMStreamingResponseMsg{chatID: chatID, isComplete: true}
+		}
+		return nil
+	}
+}
+
+// recoverCancelled reports a panic in a request as an error.  The ollama backend dereferences a nil response when its
+// stream is cut short by a cancelled context; without this, cancelling an answer could take down the program.
+func recoverCancelled(ctx context.Context, chatID string, sub chan tea.Msg) {
+	if r := recover(); r != nil {
+		err := ctx.Err()
+		if err == nil {
+			err = fmt.Errorf("request to the LLM failed: %v", r)
+		}
+		sub <- LLMStreamingResponseMsg{chatID: chatID, err: err}
+	}
+}
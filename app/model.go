@@ -2,9 +2,14 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/clocklear/texttrove/pkg/db/chats"
+	"github.com/clocklear/texttrove/pkg/db/rag"
 	"github.com/clocklear/texttrove/pkg/models"
 
 	"github.com/charmbracelet/bubbles/v2/cursor"
@@ -22,6 +27,10 @@ import (
 type Ragger interface {
 	LoadDocuments(ctx context.Context, basePath, filePattern string) error
 	Query(ctx context.Context, queryText string, nResults int, where, whereDocument map[string]any) ([]schema.Document, error)
+	QueryWithOptions(ctx context.Context, opts rag.QueryOptions) ([]schema.Document, error)
+	// ParseQueryOptions strips filter terms such as tag:x or path:work/, and expressions of time such as last week,
+	// out of a query
+	ParseQueryOptions(query string, now time.Time) rag.QueryOptions
 	Shutdown(ctx context.Context) error
 }
 
@@ -32,6 +41,8 @@ const (
 	StatusReady        status = "Ready"
 	StatusQuerying     status = "Querying"
 	StatusRetrieving   status = "Retrieving"
+	StatusSearching    status = "Searching notes"
+	StatusIndexing     status = "Indexing"
 )
 
 var (
@@ -53,14 +64,21 @@ type Model struct {
 	help           help.Model
 	dispatchStream chan tea.Msg
 	viewport       viewport.Model
-	// chats          []*models.Chat
-	// selectedChat   uint // future use
-	chat         *models.Chat
-	textarea     textarea.Model
-	spinner      spinner.Model
-	chatRenderer chatRenderer
-	status       status
-	logger       Logger
+	tabs           []*chatTab
+	selectedTab    int
+	renaming       bool
+	renameInput    string
+	textarea       textarea.Model
+	spinner        spinner.Model
+	chatRenderer   chatRenderer
+	logger         Logger
+	history        historyBrowser
+	showHistory    bool
+	sources        sourcesPanel
+	showSources    bool
+	width          int
+	index          rag.Progress
+	indexing       bool
 
 	cfg Config
 }
@@ -79,6 +97,17 @@ func New(cfg Config) (Model, error) {
 	// Create a logger pane
 	l := NewLogger(3, cfg.LoggerHistorySize, lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.LogColor)))
 
+	// Create a browser for past chats
+	h := newHistoryBrowser(cfg.ChatStore,
+		lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.SenderColor)),
+		lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.ToolColor)),
+		lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.ErrorColor)))
+
+	// Create a panel for the sources of answers
+	src := newSourcesPanel(
+		lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.SenderColor)),
+		lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.ToolColor)))
+
 	// Create a spinner for showing that the app is loading
 	spn := spinner.New()
 	spn.Style = lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.SpinnerColor))
@@ -90,27 +119,52 @@ func New(cfg Config) (Model, error) {
 		help:           help.New(),
 		spinner:        spn,
 		dispatchStream: make(chan tea.Msg),
-		// chats:          []*models.Chat{chat},
-		chat: cfg.Chat,
+		tabs:           []*chatTab{{chat: cfg.Chat, status: StatusInitializing}},
 		chatRenderer: chatRenderer{
 			senderStyle:      lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.SenderColor)),
 			llmStyle:         lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.LLMColor)),
 			errorStyle:       lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.ErrorColor)),
+			toolStyle:        lipgloss.NewStyle().Foreground(lipgloss.ANSIColor(cfg.ToolColor)),
 			markdownRenderer: cfg.MarkdownRenderer,
 			showPrompt:       cfg.ShowPromptInChat,
+			documentPath:     cfg.DocumentPath,
+			obsidianVault:    cfg.ObsidianVault,
 		},
-		status: StatusInitializing,
-		logger: l,
+		logger:  l,
+		history: h,
+		sources: src,
 	}, nil
 }
 
 func (m Model) activeChat() *models.Chat {
-	// return m.chats[m.selectedChat]
-	return m.chat
+	return m.activeTab().chat
 }
 
-func (m *Model) setStatus(s status) {
-	m.status = s
+// persistChat saves the given chat, if a store is configured and there's something worth saving.
+func (m *Model) persistChat(chat *models.Chat) {
+	if m.cfg.ChatStore == nil {
+		return
+	}
+	r := chat.Record()
+	if !r.HasUserMessages() {
+		return
+	}
+	err := m.cfg.ChatStore.Save(r)
+	if err != nil {
+		m.logger.log(fmt.Sprintf("err: failed to save chat: %v", err))
+	}
+}
+
+// syncWithStore picks up renames and deletions of the given chat made in the history browser.
+func (m *Model) syncWithStore(chat *models.Chat) {
+	r, err := m.cfg.ChatStore.Load(chat.ID())
+	switch {
+	case err == nil:
+		chat.SetTitle(r.Title)
+	case errors.Is(err, chats.ErrNotFound) && chat.Record().HasUserMessages():
+		// The open chat was deleted
+		chat.Reset()
+	}
 }
 
 func waitForActivity(sub chan tea.Msg) tea.Cmd {
@@ -138,93 +192,182 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	)
 
 	// Grab a handle for the selected chat
-	chat := m.activeChat()
+	tab := m.activeTab()
+	chat := tab.chat
 
 	// We want to propagate all non-keyboard events to the viewport.
 	propagateEventToViewport := true
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
+		// The log viewport needs to be made aware of this as well, and has to be sized before we measure it
+		m.logger, cmd = m.logger.Update(msg)
+		cmds = append(cmds, cmd)
+
 		headerHeight := lipgloss.Height(m.headerView())
 		footerHeight := lipgloss.Height(m.footerView())
 		helpHeight := lipgloss.Height(m.helpView())
 		loggerHeight := lipgloss.Height(m.logger.View())
-		verticalMarginHeight := headerHeight + footerHeight + m.cfg.ChatInputHeight + helpHeight + loggerHeight
+		// The extra line accounts for the gap between the chat and the footer
+		verticalMarginHeight := headerHeight + footerHeight + m.cfg.ChatInputHeight + helpHeight + loggerHeight + 1
 
+		m.width = msg.Width
 		if !m.ready {
 			// Since this program is using the full size of the viewport we
 			// need to wait until we've received the window dimensions before
 			// we can initialize the viewport. The initial dimensions come in
 			// quickly, though asynchronously, which is why we wait for them
 			// here.
-			m.viewport = viewport.New(viewport.WithWidth(msg.Width), viewport.WithHeight(msg.Height-verticalMarginHeight))
+			m.viewport = viewport.New(viewport.WithWidth(m.chatWidth()), viewport.WithHeight(msg.Height-verticalMarginHeight))
 			m.viewport.SetYOffset(headerHeight)
 			m.textarea.SetWidth(msg.Width)
 			m.ready = true
-			m.setStatus(StatusReady)
+			tab.status = StatusReady
+			// We may have been started with a resumed chat
+			m.refreshViewport()
 		} else {
-			m.viewport.SetWidth(msg.Width)
+			m.viewport.SetWidth(m.chatWidth())
 			m.textarea.SetWidth(msg.Width)
 			m.viewport.SetHeight(msg.Height - verticalMarginHeight)
 		}
-		// The log viewport needs to be made aware of this as well
-		m.logger, cmd = m.logger.Update(msg)
-		cmds = append(cmds, cmd)
+		m.history.SetSize(msg.Width, m.viewport.Height())
+		m.sources.SetSize(msg.Width-m.chatWidth(), m.viewport.Height())
 	case tea.KeyMsg:
+		if m.showHistory && !key.Matches(msg, m.cfg.Keys.Quit) {
+			// The history browser has the keyboard while it's open
+			m.history, cmd = m.history.Update(msg)
+			return m, cmd
+		}
+		if m.renaming && !key.Matches(msg, m.cfg.Keys.Quit) {
+			// As does the tab title editor
+			return m.updateRename(msg)
+		}
 		switch {
 		case key.Matches(msg, m.cfg.Keys.Quit):
 			// Quit
 			return m, tea.Quit
+		case key.Matches(msg, m.cfg.Keys.History):
+			if m.cfg.ChatStore != nil {
+				m.history.Refresh()
+				m.showHistory = true
+			}
 		case key.Matches(msg, m.cfg.Keys.Help):
 			// Toggle small/large help
 			m.help.ShowAll = !m.help.ShowAll
 			// TODO: figure out how to trigger resize event so things get painted in the correct location
 		case key.Matches(msg, m.cfg.Keys.Send):
+			v := m.textarea.Value()
+			if v == "" || chat.IsStreaming() {
+				// Don't send empty messages, or talk over an answer that's still streaming.
+				return m, nil
+			}
+
 			// Reset (chat) err
 			chat.ClearError()
 			chat.BeginStreaming()
-			m.setStatus(StatusQuerying)
-			v := m.textarea.Value()
+			tab.status = StatusQuerying
+			ctx := tab.newRequestContext()
 
-			if v == "" {
-				// Don't send empty messages.
-				return m, nil
+			// Queries still work while the initial sync is running, but only see what's been indexed so far
+			if m.indexIncomplete() {
+				m.logger.log(fmt.Sprintf("warning: index incomplete (%d/%d files); answers may miss some notes", m.index.FilesScanned, m.index.FilesTotal))
 			}
 
-			// Try to find supporting information for the user's query
-			// and add that to conversation as additional context
-			ctxs, err := m.cfg.RAG.Query(context.Background(), v, 5, nil, nil) // TODO: Use 'where'?
+			if m.cfg.Agent != nil {
+				// The agent decides for itself when to search the knowledge base
+				chat.AppendUserMessage(v)
+				m.textarea.Reset()
+				m.refreshViewport()
+				return m, tea.Batch(m.generate(ctx, chat), m.spinner.Tick)
+			}
+
+			// Show the message right away; the supporting information found for it is added once the search is done
+			history := slices.Clone(chat.Log())
+			chat.AppendUserMessage(v)
+			tab.status = StatusSearching
+			m.textarea.Reset()
+			m.refreshViewport()
+			return m, tea.Batch(retrieveContext(ctx, m.cfg.RAG, m.cfg.Condenser, m.Log, chat.ID(), history, v, m.cfg.MaxDocumentResults), m.spinner.Tick)
+		case key.Matches(msg, m.cfg.Keys.Cancel):
+			// Stop the answer in progress; the partial answer is kept
+			tab.cancelRequest()
+		case key.Matches(msg, m.cfg.Keys.Regenerate):
+			if chat.IsStreaming() {
+				break
+			}
+			chat.ClearError()
+			if err := chat.RewindToLastUserMessage(); err != nil {
+				chat.SetError(err)
+				m.refreshViewport()
+				break
+			}
+			chat.BeginStreaming()
+			tab.status = StatusQuerying
+			m.refreshViewport()
+			return m, tea.Batch(m.generate(tab.newRequestContext(), chat), m.spinner.Tick)
+		case key.Matches(msg, m.cfg.Keys.EditLast):
+			if chat.IsStreaming() {
+				break
+			}
+			chat.ClearError()
+			v, err := chat.RewindLastTurn()
 			if err != nil {
-				// m.Log(err.Error())
-				fmt.Println(err.Error())
 				chat.SetError(err)
 			} else {
-				err = chat.AddContexts(ctxs)
-				if err != nil {
-					// m.Log(err.Error())
-					fmt.Println(err.Error())
-					chat.SetError(err)
-				}
+				m.textarea.SetValue(v)
+				m.persistChat(chat)
 			}
-
-			// Append the user message to the ongoing chat
-			chat.AppendUserMessage(m.textarea.Value())
+			m.refreshViewport()
+		case key.Matches(msg, m.cfg.Keys.ToggleTools):
+			m.chatRenderer.expandTools = !m.chatRenderer.expandTools
 			m.viewport.SetContent(m.chatRenderer.Render(chat))
-			m.textarea.Reset()
-			m.viewport.GotoBottom()
-
-			// Send the message to the LLM
-			return m, tea.Batch(
-				submitChat(context.Background(), m.cfg.ConversationLLM, chat.Log(), m.dispatchStream),
-				m.spinner.Tick,
-			)
 		case key.Matches(msg, m.cfg.Keys.NewChat):
 			// Only allow new chats when the current chat is not streaming
 			if !chat.IsStreaming() {
 				// Reset the chat
 				chat.Reset()
 				m.viewport.SetContent("")
-				m.setStatus(StatusReady)
+				tab.status = StatusReady
+			}
+		case key.Matches(msg, m.cfg.Keys.NewTab):
+			if m.cfg.NewChat == nil {
+				break
+			}
+			c, err := m.cfg.NewChat()
+			if err != nil {
+				chat.SetError(err)
+				m.refreshViewport()
+				break
+			}
+			m.openTab(c)
+		case key.Matches(msg, m.cfg.Keys.CloseChat):
+			if err := m.closeTab(); err != nil {
+				chat.SetError(err)
+				m.refreshViewport()
+			}
+		case key.Matches(msg, m.cfg.Keys.NextTab):
+			m.selectTab(m.selectedTab + 1)
+		case key.Matches(msg, m.cfg.Keys.PrevTab):
+			m.selectTab(m.selectedTab - 1)
+		case key.Matches(msg, m.cfg.Keys.RenameTab):
+			m.renaming = true
+			m.renameInput = chat.Title()
+		case key.Matches(msg, m.cfg.Keys.ToggleSources):
+			m.showSources = !m.showSources
+			m.viewport.SetWidth(m.chatWidth())
+			m.sources.SetSize(m.width-m.chatWidth(), m.viewport.Height())
+			m.refreshViewport()
+		case m.showSources && key.Matches(msg, m.cfg.Keys.PrevAnswer):
+			m.sources.selectAnswer(chat, -1)
+		case m.showSources && key.Matches(msg, m.cfg.Keys.NextAnswer):
+			m.sources.selectAnswer(chat, 1)
+		case m.showSources && key.Matches(msg, m.cfg.Keys.PrevSource):
+			m.sources.moveCursor(chat, -1)
+		case m.showSources && key.Matches(msg, m.cfg.Keys.NextSource):
+			m.sources.moveCursor(chat, 1)
+		case m.showSources && key.Matches(msg, m.cfg.Keys.OpenSource):
+			if s, ok := m.sources.selected(chat); ok {
+				return m, m.openSource(s)
 			}
 
 		default:
@@ -235,8 +378,54 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// We don't want to propagate the event to the viewport
 		propagateEventToViewport = false
 
+	case OpenChatMsg:
+		m.showHistory = false
+		for i, t := range m.tabs {
+			if t.chat.ID() == msg.record.ID {
+				// Already open; just switch to it
+				m.selectTab(i)
+				return m, nil
+			}
+		}
+		switch {
+		case !chat.IsStreaming() && !chat.Record().HasUserMessages():
+			// Reuse the empty tab
+			chat.Restore(msg.record)
+			m.refreshViewport()
+		case m.cfg.NewChat != nil:
+			c, err := m.cfg.NewChat()
+			if err != nil {
+				chat.SetError(err)
+				m.refreshViewport()
+				break
+			}
+			c.Restore(msg.record)
+			m.openTab(c)
+		case chat.IsStreaming():
+			chat.SetError(errors.New("can't open a chat while an answer is streaming"))
+			m.refreshViewport()
+		default:
+			chat.Restore(msg.record)
+			m.refreshViewport()
+		}
+		return m, nil
+
+	case NoteClosedMsg:
+		if msg.err != nil {
+			m.logger.log(fmt.Sprintf("err: %v", msg.err))
+		}
+		return m, nil
+
+	case CloseHistoryMsg:
+		m.showHistory = false
+		for _, t := range m.tabs {
+			m.syncWithStore(t.chat)
+		}
+		m.refreshViewport()
+		return m, nil
+
 	case spinner.TickMsg:
-		if !chat.IsStreaming() {
+		if !m.anyStreaming() {
 			// Only update the spinner if we're streaming
 			return m, nil
 		}
@@ -250,27 +439,85 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmds = append(cmds, cmd)
 
 	case LLMStreamingResponseMsg:
-		// Handle incoming messages
-		if msg.err != nil {
-			chat.SetError(msg.err)
-			// m.Log(msg.err.Error())
-			fmt.Println(msg.err.Error())
-			m.setStatus(StatusReady)
-		} else {
-			// Append the incoming message to the buffer
-			chat.StreamChunk(msg.chunk)
-			m.setStatus(StatusRetrieving)
-			if msg.isComplete {
-				chat.EndStreaming()
-				m.setStatus(StatusReady)
+		// Route the message to the tab it belongs to, which may not be the one on screen
+		if t := m.tabForChat(msg.chatID); t != nil {
+			c := t.chat
+			if errors.Is(msg.err, context.Canceled) {
+				// The user cancelled the answer
+				c.InterruptStreaming()
+				t.cancelRequest()
+				m.persistChat(c)
+				t.status = StatusReady
+			} else if msg.err != nil {
+				c.SetError(msg.err)
+				c.AbortStreaming()
+				t.cancelRequest()
+				m.persistChat(c)
+				t.status = StatusReady
+			} else if msg.discard {
+				// What was streamed so far belonged to a tool call
+				c.DiscardStreaming()
+			} else {
+				// Append the incoming message to the buffer
+				c.StreamChunk(msg.chunk)
+				t.status = StatusRetrieving
+				if msg.isComplete {
+					c.EndStreaming()
+					t.cancelRequest()
+					m.persistChat(c)
+					t.status = StatusReady
+				}
+			}
+			if t == tab {
+				// Refresh the viewport content
+				m.refreshViewport()
+			}
+		}
+		// Await the next message
+		cmds = append(cmds, waitForActivity(m.dispatchStream))
+
+	case ContextRetrievedMsg:
+		if t := m.tabForChat(msg.chatID); t != nil {
+			c := t.chat
+			switch {
+			case errors.Is(msg.err, context.Canceled):
+				// The user cancelled before the answer started
+				c.InterruptStreaming()
+				t.cancelRequest()
+				m.persistChat(c)
+				t.status = StatusReady
+			default:
+				// Without supporting information, the model can still answer from the conversation so far
+				if msg.err != nil {
+					c.SetError(msg.err)
+				} else if err := c.AddContextsForLastMessage(msg.docs); err != nil {
+					c.SetError(err)
+				}
+				t.status = StatusQuerying
+				cmds = append(cmds, m.generate(msg.ctx, c))
+			}
+			if t == tab {
+				m.refreshViewport()
+			}
+		}
+
+	case AgentMessageMsg:
+		// Record the tool call/result in the chat
+		if t := m.tabForChat(msg.chatID); t != nil {
+			t.chat.AppendMessage(msg.message)
+			if t == tab {
+				m.refreshViewport()
 			}
 		}
-		// Refresh the viewport content
-		m.viewport.SetContent(m.chatRenderer.Render(chat))
-		m.viewport.GotoBottom()
 		// Await the next message
 		cmds = append(cmds, waitForActivity(m.dispatchStream))
 
+	case IndexProgressMsg:
+		m.index = rag.Progress(msg)
+		m.indexing = true
+		// Await the next message
+		return m, waitForActivity(m.dispatchStream)
+
 	case LogMsg:
 		// Invoke the logger with this message
 		m.logger, cmd = m.logger.Update(msg)
@@ -297,7 +544,7 @@ func (m Model) View() string {
 	return fmt.Sprintf(
 		"%s\n%s\n\n%s\n%s\n%s\n%s",
 		m.headerView(),
-		m.viewport.View(),
+		m.mainView(),
 		m.footerView(),
 		m.textarea.View(),
 		m.logger.View(),
@@ -305,32 +552,54 @@ func (m Model) View() string {
 	)
 }
 
+func (m Model) mainView() string {
+	if m.showHistory {
+		return m.history.View()
+	}
+	if m.showSources {
+		return lipgloss.JoinHorizontal(lipgloss.Top, m.viewport.View(), m.sources.View(m.activeChat()))
+	}
+	return m.viewport.View()
+}
+
+// chatWidth returns the width of the chat, which makes room for the sources panel while it's shown.
+func (m Model) chatWidth() int {
+	if !m.showSources {
+		return m.width
+	}
+	return m.width - max(m.width/3, 30)
+}
+
 func (m Model) helpView() string {
 	return m.help.View(m.cfg.Keys)
 }
 
 func (m Model) headerView() string {
-	titleText := m.cfg.AppName
-	// chat := m.activeChat()
-	// if chat != nil && chat.IsStreaming() {
-	// 	titleText += " " + m.spinner.View()
-	// }
-	title := titleStyle.Render(titleText)
-	line := strings.Repeat("─", max(0, m.viewport.Width()-lipgloss.Width(title)))
+	title := titleStyle.Render(m.cfg.AppName + " │ " + m.tabBarView())
+	line := strings.Repeat("─", max(0, m.width-lipgloss.Width(title)))
 	return lipgloss.JoinHorizontal(lipgloss.Center, title, line)
 }
 
 func (m Model) footerView() string {
 	// info := infoStyle.Render(fmt.Sprintf("%3.f%%", m.viewport.ScrollPercent()*100))
-	info := string(m.status)
-	// TODO: this needs to correctly contemplate multiple chats
-	chat := m.activeChat()
-	if chat != nil && chat.IsStreaming() {
+	tab := m.activeTab()
+	info := string(tab.status)
+	if tab.status == StatusReady && m.indexIncomplete() {
+		info = string(StatusIndexing)
+	}
+	if tab.chat.IsStreaming() {
 		info += " " + m.spinner.View()
 	}
 	info = infoStyle.Render(info)
-	line := strings.Repeat("─", max(0, m.viewport.Width()-lipgloss.Width(info)))
-	return lipgloss.JoinHorizontal(lipgloss.Center, line, info)
+	progress := ""
+	if m.indexIncomplete() {
+		progress = m.indexProgressView() + " "
+	}
+	if budget := m.budgetView(); budget != "" {
+		progress += m.chatRenderer.toolStyle.Render(budget) + " "
+	}
+	line := strings.Repeat("─", max(0, m.width-lipgloss.Width(info)-lipgloss.Width(progress)))
+	return lipgloss.JoinHorizontal(lipgloss.Center, progress, line, info)
 }
 
 func max(a, b int) int {
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+)
+
+// NoteClosedMsg is emitted when the editor a note was opened in exits.
+type NoteClosedMsg struct {
+	err error
+}
+
+// openSource opens the note of the given source at the heading the fragment is under: in the configured Obsidian
+// vault, or otherwise in $VISUAL or $EDITOR, suspending the TUI while the editor runs.
+func (m Model) openSource(s source) tea.Cmd {
+	var heading string
+	if len(s.headings) > 0 {
+		heading = s.headings[len(s.headings)-1]
+	}
+	if m.cfg.ObsidianVault != "" {
+		uri := obsidianURI(m.cfg.ObsidianVault, s.path, heading)
+		return func() tea.Msg {
+			cmd := openURICommand(uri)
+			if err := cmd.Start(); err != nil {
+				return NoteClosedMsg{err: fmt.Errorf("failed to open %s: %w", uri, err)}
+			}
+			go cmd.Wait()
+			return nil
+		}
+	}
+	path := filepath.Join(m.cfg.DocumentPath, filepath.FromSlash(s.path))
+	cmd := editorCommand(path, headingLine(path, s.headings))
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		if err != nil {
+			err = fmt.Errorf("failed to open %s: %w", s.path, err)
+		}
+		return NoteClosedMsg{err: err}
+	})
+}
+
+// editorCommand returns the command to open the file at the given line in $VISUAL or $EDITOR (vi if neither is set).
+func editorCommand(path string, line int) *exec.Cmd {
+	args := strings.Fields(cmp.Or(os.Getenv("VISUAL"), os.Getenv("EDITOR"), "vi"))
+	switch filepath.Base(args[0]) {
+	case "code", "code-insiders", "codium", "cursor":
+		args = append(args, "-g", fmt.Sprintf("%s:%d", path, line))
+	case "subl", "zed", "hx", "helix":
+		args = append(args, fmt.Sprintf("%s:%d", path, line))
+	default:
+		// vi, vim, nvim, nano, emacs, micro, kak, ...
+		args = append(args, fmt.Sprintf("+%d", line), path)
+	}
+	return exec.Command(args[0], args[1:]...)
+}
+
+// headingLine returns the (1-based) line of the file the given heading hierarchy ends at, matching the headings in
+// order.  It falls back to the first line.
+func headingLine(path string, headings []string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	line, found := 0, 1
+	next := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() && next < len(headings) {
+		line++
+		m := headingPattern.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m != nil && m[1] == headings[next] {
+			found = line
+			next++
+		}
+	}
+	return found
+}
+
+// obsidianURI returns the URI opening the note at the given path in an Obsidian vault, at the given heading if any.
+func obsidianURI(vault, path, heading string) string {
+	file := strings.TrimSuffix(path, ".md")
+	if heading != "" {
+		file += "#" + heading
+	}
+	return "obsidian://open?vault=" + uriEscape(vault) + "&file=" + uriEscape(file)
+}
+
+// uriEscape escapes s for a URI query, with spaces as %20 rather than + since that's what Obsidian expects.
+func uriEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// openURICommand returns the command that opens a URI with the application registered for it.
+func openURICommand(uri string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", uri)
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", uri)
+	default:
+		return exec.Command("xdg-open", uri)
+	}
+}
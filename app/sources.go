@@ -0,0 +1,214 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/clocklear/texttrove/pkg/db/rag"
+	"github.com/clocklear/texttrove/pkg/models"
+
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Fragments start with the headings they're under, optionally after an embedding prefix like "search_document: "
+var (
+	headingPattern         = regexp.MustCompile(`^#{1,6}\s+(.+?)\s*#*$`)
+	prefixedHeadingPattern = regexp.MustCompile(`^\S+:\s+(#{1,6}\s+.+)$`)
+)
+
+// sourcesPanel lists the notes the selected answer was based on, next to the chat.
+type sourcesPanel struct {
+	// answer is the index of the selected answer in the chat, or -1 to follow the latest one
+	answer int
+	cursor int
+	width  int
+	height int
+
+	selectedStyle lipgloss.Style
+	dimStyle      lipgloss.Style
+}
+
+func newSourcesPanel(selectedStyle, dimStyle lipgloss.Style) sourcesPanel {
+	return sourcesPanel{
+		answer:        -1,
+		selectedStyle: selectedStyle,
+		dimStyle:      dimStyle,
+	}
+}
+
+func (p *sourcesPanel) SetSize(width, height int) {
+	p.width = width
+	p.height = height
+}
+
+// source is a retrieved fragment as listed in the panel.
+type source struct {
+	path     string
+	headings []string
+	snippet  string
+	score    float32
+}
+
+func newSource(d schema.Document) source {
+	headings, body := parseFragment(rag.FragmentText(d.PageContent))
+	return source{
+		path:     strings.TrimPrefix(fmt.Sprint(d.Metadata["Source"]), "/"),
+		headings: headings,
+		snippet:  strings.Join(strings.Fields(body), " "),
+		score:    d.Score,
+	}
+}
+
+// parseFragment splits the text of a fragment into the headings it's under and the rest.
+func parseFragment(text string) ([]string, string) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	var headings []string
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if i == 0 {
+			if m := prefixedHeadingPattern.FindStringSubmatch(line); m != nil {
+				line = m[1]
+			}
+		}
+		m := headingPattern.FindStringSubmatch(line)
+		if m == nil {
+			break
+		}
+		headings = append(headings, m[1])
+	}
+	return headings, strings.Join(lines[i:], "\n")
+}
+
+// answers returns the indexes of the answers in the chat, oldest first.
+func answers(chat *models.Chat) []int {
+	var idx []int
+	for i, m := range chat.Log() {
+		if m.Role == llms.ChatMessageTypeAI {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// selectedAnswer returns the index of the answer the panel is showing, and its position among the answers, or -1 if
+// there is no answer yet.
+func (p sourcesPanel) selectedAnswer(chat *models.Chat) (int, int) {
+	idx := answers(chat)
+	if len(idx) == 0 {
+		return -1, -1
+	}
+	for n, i := range idx {
+		if i == p.answer {
+			return i, n
+		}
+	}
+	return idx[len(idx)-1], len(idx) - 1
+}
+
+// selectAnswer moves the selection by delta answers; moving past the latest answer follows new answers again.
+func (p *sourcesPanel) selectAnswer(chat *models.Chat, delta int) {
+	idx := answers(chat)
+	_, n := p.selectedAnswer(chat)
+	if n < 0 {
+		return
+	}
+	n = max(n+delta, 0)
+	p.answer = -1
+	if n < len(idx)-1 {
+		p.answer = idx[n]
+	}
+	p.cursor = 0
+}
+
+// sources returns the sources of the selected answer.
+func (p sourcesPanel) sources(chat *models.Chat) []source {
+	i, _ := p.selectedAnswer(chat)
+	if i < 0 {
+		return nil
+	}
+	docs := chat.Sources(i)
+	srcs := make([]source, 0, len(docs))
+	for _, d := range docs {
+		srcs = append(srcs, newSource(d))
+	}
+	return srcs
+}
+
+// selected returns the source under the cursor.
+func (p sourcesPanel) selected(chat *models.Chat) (source, bool) {
+	srcs := p.sources(chat)
+	if p.cursor < 0 || p.cursor >= len(srcs) {
+		return source{}, false
+	}
+	return srcs[p.cursor], true
+}
+
+// moveCursor moves the cursor by delta sources.
+func (p *sourcesPanel) moveCursor(chat *models.Chat, delta int) {
+	p.cursor = max(min(p.cursor+delta, len(p.sources(chat))-1), 0)
+}
+
+func (p sourcesPanel) View(chat *models.Chat) string {
+	width := max(p.width-2, 10)
+	lines := []string{p.dimStyle.Render("Sources")}
+	i, n := p.selectedAnswer(chat)
+	srcs := p.sources(chat)
+	switch {
+	case i < 0:
+		lines = append(lines, p.dimStyle.Render("No answer yet"))
+	case len(srcs) == 0:
+		lines = append(lines, p.dimStyle.Render(fmt.Sprintf("Answer %d/%d", n+1, len(answers(chat)))), "", p.dimStyle.Render("No notes were retrieved for this answer"))
+	default:
+		lines = append(lines, p.dimStyle.Render(fmt.Sprintf("Answer %d/%d · %d notes", n+1, len(answers(chat)), len(srcs))), "")
+	}
+
+	// Render each source as a block, and scroll so the one under the cursor is in view
+	blocks := make([][]string, len(srcs))
+	for j, s := range srcs {
+		title := fmt.Sprintf("%d. %s", j+1, s.path)
+		score := fmt.Sprintf("%.4f", s.score)
+		title = summarizeTitle(title, width-len(score)-1)
+		title += strings.Repeat(" ", max(width-lipgloss.Width(title)-len(score), 1)) + score
+		if j == p.cursor {
+			title = p.selectedStyle.Render(title)
+		}
+		block := []string{title}
+		if len(s.headings) > 0 {
+			block = append(block, p.dimStyle.Render(summarizeTitle("  "+strings.Join(s.headings, " › "), width)))
+		}
+		snippet := lipgloss.NewStyle().Width(width - 2).Render(s.snippet)
+		for k, l := range strings.Split(snippet, "\n") {
+			if k == 2 {
+				break
+			}
+			block = append(block, "  "+l)
+		}
+		blocks[j] = append(block, "")
+	}
+	available := p.height - len(lines)
+	start := 0
+	for start < p.cursor {
+		used := 0
+		for _, b := range blocks[start : p.cursor+1] {
+			used += len(b)
+		}
+		if used <= available {
+			break
+		}
+		start++
+	}
+	for _, b := range blocks[start:] {
+		lines = append(lines, b...)
+	}
+
+	// Fill the available height so the layout doesn't jump
+	for len(lines) < p.height {
+		lines = append(lines, "")
+	}
+	border := lipgloss.NewStyle().Border(lipgloss.NormalBorder(), false, false, false, true).BorderForeground(p.dimStyle.GetForeground()).PaddingLeft(1)
+	return border.Render(strings.Join(lines[:max(p.height, 0)], "\n"))
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/clocklear/texttrove/pkg/models"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+)
+
+// chatTab is a single conversation in the tabbed interface.  Each tab streams independently.
+type chatTab struct {
+	chat   *models.Chat
+	status status
+	// draft holds the unsent contents of the textarea while the tab is in the background
+	draft string
+	// cancel stops the request in flight, if any
+	cancel context.CancelFunc
+	// usage is how much of the context window the chat took up at usageRevision; see windowUsage
+	usage         models.Usage
+	usageRevision int
+	usageKnown    bool
+}
+
+// windowUsage returns how much of the context window the chat takes up, working it out again only when the chat has
+// changed since the last time.
+func (t *chatTab) windowUsage(b models.Budget) models.Usage {
+	if rev := t.chat.Revision(); !t.usageKnown || rev != t.usageRevision {
+		t.usage, t.usageRevision, t.usageKnown = t.chat.Window(b).Usage, rev, true
+	}
+	return t.usage
+}
+
+// newRequestContext returns a context for a new request to the LLM, which cancelRequest can stop.
+func (t *chatTab) newRequestContext() context.Context {
+	var ctx context.Context
+	ctx, t.cancel = context.WithCancel(context.Background())
+	return ctx
+}
+
+// cancelRequest stops the request in flight, if any.  It's also used to release the context once a request is done.
+func (t *chatTab) cancelRequest() {
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
+
+// generate asks the LLM (or the agent, if enabled) to answer the chat as it stands.
+func (m Model) generate(ctx context.Context, chat *models.Chat) tea.Cmd {
+	return func() tea.Msg {
+		// Only what fits the context window is sent
+		msgs := m.contextWindow(ctx, chat)
+		if m.cfg.Agent != nil {
+			return runAgent(ctx, m.cfg.Agent, chat.ID(), msgs, m.dispatchStream)()
+		}
+		return submitChat(ctx, m.cfg.ConversationLLM, chat.ID(), msgs, m.dispatchStream)()
+	}
+}
+
+func (m Model) activeTab() *chatTab {
+	return m.tabs[m.selectedTab]
+}
+
+// tabForChat returns the tab holding the chat with the given ID, or nil if it has since been closed.
+func (m Model) tabForChat(id string) *chatTab {
+	for _, t := range m.tabs {
+		if t.chat.ID() == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// anyStreaming reports whether any tab is waiting on the LLM.
+func (m Model) anyStreaming() bool {
+	for _, t := range m.tabs {
+		if t.chat.IsStreaming() {
+			return true
+		}
+	}
+	return false
+}
+
+// openTab adds a tab for the given chat and selects it.
+func (m *Model) openTab(chat *models.Chat) {
+	m.tabs = append(m.tabs, &chatTab{chat: chat, status: StatusReady})
+	m.selectTab(len(m.tabs) - 1)
+}
+
+// selectTab switches to the tab at index i, swapping textarea drafts.
+func (m *Model) selectTab(i int) {
+	if len(m.tabs) == 0 {
+		return
+	}
+	i = (i + len(m.tabs)) % len(m.tabs)
+	if i < len(m.tabs) && m.selectedTab < len(m.tabs) {
+		m.activeTab().draft = m.textarea.Value()
+	}
+	m.selectedTab = i
+	m.renaming = false
+	m.sources.answer, m.sources.cursor = -1, 0
+	m.textarea.SetValue(m.activeTab().draft)
+	m.refreshViewport()
+}
+
+// closeTab closes the selected tab, refusing while it's streaming.  Closing the last tab leaves a fresh chat behind.
+func (m *Model) closeTab() error {
+	t := m.activeTab()
+	if t.chat.IsStreaming() {
+		return fmt.Errorf("can't close a chat while an answer is streaming")
+	}
+	if len(m.tabs) == 1 {
+		t.chat.Reset()
+		t.status = StatusReady
+		m.textarea.Reset()
+		m.refreshViewport()
+		return nil
+	}
+	m.tabs = append(m.tabs[:m.selectedTab], m.tabs[m.selectedTab+1:]...)
+	// Don't stash the closed tab's draft anywhere
+	m.textarea.Reset()
+	m.selectedTab = min(m.selectedTab, len(m.tabs)-1)
+	m.textarea.SetValue(m.activeTab().draft)
+	m.refreshViewport()
+	return nil
+}
+
+// refreshViewport re-renders the selected chat.
+func (m *Model) refreshViewport() {
+	m.viewport.SetContent(m.chatRenderer.Render(m.activeTab().chat))
+	m.viewport.GotoBottom()
+}
+
+// updateRename handles key presses while the selected tab is being renamed.
+func (m Model) updateRename(msg tea.KeyMsg) (Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		m.renaming = false
+	case "enter":
+		m.renaming = false
+		t := m.activeTab()
+		t.chat.SetTitle(strings.TrimSpace(m.renameInput))
+		m.persistChat(t.chat)
+	case "backspace":
+		if r := []rune(m.renameInput); len(r) > 0 {
+			m.renameInput = string(r[:len(r)-1])
+		}
+	default:
+		m.renameInput += msg.Key().Text
+	}
+	return m, nil
+}
+
+// tabBarView renders the tab titles, highlighting the selected tab and marking those that are streaming.
+func (m Model) tabBarView() string {
+	parts := make([]string, 0, len(m.tabs))
+	for i, t := range m.tabs {
+		title := summarizeTitle(t.chat.Title(), 20)
+		if i == m.selectedTab && m.renaming {
+			title = m.renameInput + "█"
+		}
+		label := fmt.Sprintf("%d:%s", i+1, title)
+		if t.chat.IsStreaming() {
+			label += " " + m.spinner.View()
+		}
+		if i == m.selectedTab {
+			label = m.chatRenderer.senderStyle.Render(label)
+		}
+		parts = append(parts, label)
+	}
+	return strings.Join(parts, " │ ")
+}
+
+func summarizeTitle(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -58,7 +58,7 @@ func main() {
 	// I'm not aware of the current date, as I'm a large language model, I don't have real-time access to the current date and time. However, I can suggest ways for you to find out the current date.
 	// You can check your device's clock or calendar app, or search online for "current date" to get the latest information.
 
-	fmt.Println("\n\n----\n")
+	fmt.Print("\n\n----\n\n")
 
 	// Let's do the same thing, but with a tool introduced that can help with today's date
 	agentTools := []tools.Tool{
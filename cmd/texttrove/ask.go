@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/condense"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// runAsk answers a single question using the document DB and streams the answer to stdout.
+func runAsk(cliCfg config, args []string) error {
+	fs := flag.NewFlagSet("ask", flag.ExitOnError)
+	fs.Usage = usageFor(fs, "ask [flags] <question>", "Answer a single question using your notes; reads stdin when no question is given")
+	n := fs.Int("n", cliCfg.Behavior.MaxDocumentResults, "number of document fragments to use as context")
+	_ = fs.Parse(args)
+
+	q, err := readInput(fs.Args(), os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	conversationLlm, err := newConversationLLM(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create conversation LLM: %w", err)
+	}
+	r, err := newRag(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create rag: %w", err)
+	}
+	chat, err := newChat(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create chat: %w", err)
+	}
+
+	// Same flow as the TUI: find supporting information, add it as context, then ask
+	ctx := context.Background()
+	opts := r.ParseQueryOptions(q, time.Now())
+	opts.N = *n
+	if opts.Filter != nil {
+		fmt.Fprintf(os.Stderr, "Filter: %s\n", opts.Filter)
+	}
+	if opts.Dates != nil {
+		fmt.Fprintf(os.Stderr, "Dates: %s (%s)\n", opts.Dates, opts.Dates.Phrase)
+	}
+	if cliCfg.Condense.Enabled {
+		// There's no conversation to resolve references against, but sub-queries still help
+		cq, err := condense.New(conversationLlm, condense.WithSubQueries(cliCfg.Condense.SubQueries)).Condense(ctx, nil, opts.Text)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "err: failed to rewrite the search query; using the question as is: %v\n", err)
+		} else {
+			opts.Text, opts.Queries = cq.Query, cq.SubQueries
+		}
+	}
+	ctxs, err := r.QueryWithOptions(ctx, opts)
+	if err != nil {
+		return fmt.Errorf("failed to query: %w", err)
+	}
+	err = chat.AddContexts(ctxs)
+	if err != nil {
+		return fmt.Errorf("failed to add contexts: %w", err)
+	}
+	chat.AppendUserMessage(q)
+
+	budget, err := newBudget(cliCfg)
+	if err != nil {
+		return err
+	}
+	w := chat.Window(budget)
+	if u := w.Usage; u.Limit > 0 && u.Tokens > u.Limit-budget.Reserve {
+		fmt.Fprintf(os.Stderr, "warning: the question and its context take up ~%d of %d tokens; ask for fewer fragments with -n\n", u.Tokens, u.Limit)
+	}
+
+	_, err = conversationLlm.GenerateContent(ctx, w.Messages, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		_, err := os.Stdout.Write(chunk)
+		return err
+	}))
+	fmt.Println()
+	return err
+}
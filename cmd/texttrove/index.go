@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/db/rag"
+)
+
+// runIndex syncs the document folder into the DB and exits.
+func runIndex(cliCfg config, args []string) error {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	fs.Usage = usageFor(fs, "index [flags]", "Sync the document folder into the DB and exit")
+	prune := fs.Bool("prune", false, "only report fragments of deleted or renamed files that a sync would purge (dry run)")
+	_ = fs.Parse(args)
+
+	r, err := newRag(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create rag: %w", err)
+	}
+
+	if *prune {
+		return reportOrphans(r, cliCfg)
+	}
+
+	var progress rag.Progress
+	r.SetProgressHandler(func(p rag.Progress) { progress = p })
+
+	log.Printf("Indexing %s, this may take a bit on the first run...", cliCfg.Document.Path)
+	err = r.SyncDocuments(context.Background(), cliCfg.Document.Path, cliCfg.Document.FilePattern)
+	if err != nil {
+		return fmt.Errorf("failed to load documents: %w", err)
+	}
+	log.Printf("Indexing complete: %d files scanned, %d fragments embedded in %s",
+		progress.FilesScanned, progress.FragmentsEmbedded, time.Since(progress.Started).Round(time.Millisecond))
+	if progress.Failures > 0 {
+		return fmt.Errorf("failed to index %d file(s)", progress.Failures)
+	}
+	return nil
+}
+
+// reportOrphans lists the files whose fragments are still in the DB even though they're gone from disk.
+func reportOrphans(r *rag.ChromemRag, cliCfg config) error {
+	report, err := r.Reconcile(context.Background(), cliCfg.Document.Path, cliCfg.Document.FilePattern, true)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile index: %w", err)
+	}
+	for _, p := range report.Paths() {
+		fmt.Printf("%s\t%d fragment(s)\n", p, report.Orphans[p])
+	}
+	fmt.Printf("%d fragment(s) of %d deleted or renamed file(s) would be purged\n", report.Fragments, len(report.Orphans))
+	return nil
+}
@@ -6,23 +6,14 @@ package main
 // This will be cleaned up and refactored into a more maintainable state.
 
 import (
-	"context"
+	"flag"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/clocklear/chromem-go"
-	"github.com/clocklear/texttrove/app"
-	"github.com/clocklear/texttrove/pkg/db/rag"
-	"github.com/clocklear/texttrove/pkg/models"
-
-	tea "github.com/charmbracelet/bubbletea/v2"
 	"github.com/kelseyhightower/envconfig"
-	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/ollama"
-	"github.com/tmc/langchaingo/llms/openai"
 )
 
 type config struct {
@@ -34,8 +25,18 @@ type config struct {
 			Type    string `default:"ollama"`
 		}
 		Embedding struct {
-			Name         string `default:"mxbai-embed-large:latest"`
-			URL          string `default:"http://localhost:11434"`
+			Name    string `default:"mxbai-embed-large:latest"`
+			URL     string `default:"http://localhost:11434"`
+			Headers StringMap
+			// Type is one of ollama or openai (any OpenAI-compatible /v1/embeddings server)
+			Type string `default:"ollama"`
+			// BatchSize is the number of fragments embedded per request while indexing
+			BatchSize   int `default:"32" split_words:"true"`
+			Concurrency int `default:"2"`
+			// RateLimit caps embedding requests per second; zero means no limit
+			RateLimit    float64       `default:"0" split_words:"true"`
+			MaxRetries   int           `default:"3" split_words:"true"`
+			RetryBackoff time.Duration `default:"500ms" split_words:"true"`
 			PromptPrefix struct {
 				Query     string `default:"Represent this sentence for searching relevant passages: "`
 				Embedding string
@@ -47,147 +48,163 @@ type config struct {
 	Document          struct {
 		Path        string `required:"true"`
 		FilePattern string `default:"*.md"`
+		// ObsidianVault, when set, opens notes from the sources panel in that Obsidian vault instead of $EDITOR
+		ObsidianVault string `split_words:"true"`
+		// EmbedDepth is how deep ![[embeds]] of other notes are resolved into the notes embedding them; 0 turns it off
+		EmbedDepth int `default:"3" split_words:"true"`
+		// ChunkStrategy is one of markdown, heading (a fragment per heading section), sentence (overlapping windows of
+		// sentences) or blocks (whole paragraphs, lists, code blocks and tables); changing it re-splits every note
+		ChunkStrategy string `default:"markdown" split_words:"true"`
+		// ChunkSize and ChunkOverlap are measured in ChunkUnits, chars or tokens (as estimated for the embedding model)
+		ChunkSize    int    `default:"300" split_words:"true"`
+		ChunkOverlap int    `default:"32" split_words:"true"`
+		ChunkUnits   string `default:"chars" split_words:"true"`
 	}
 	Database struct {
 		Path string `default:"texttrove.db"`
+		// OnModelChange is one of rebuild or refuse, for when the DB was built with different embedding settings
+		OnModelChange string `default:"rebuild" split_words:"true"`
+	}
+	Retrieval struct {
+		// Mode is one of hybrid (vector and keyword search combined), vector or lexical
+		Mode string `default:"hybrid"`
+		// VectorWeight and LexicalWeight weigh each ranking when they're fused in hybrid mode
+		VectorWeight  float64 `default:"1" split_words:"true"`
+		LexicalWeight float64 `default:"1" split_words:"true"`
+		// LinkHops and LinkBudget add up to LinkBudget fragments from notes within LinkHops wikilinks of the retrieved
+		// ones; zero turns this off
+		LinkHops   int `default:"0" split_words:"true"`
+		LinkBudget int `default:"3" split_words:"true"`
+		// Dates is one of filter (only notes from the dates a question mentions, e.g. last week, are searched), boost
+		// (notes from those dates gain DateBoost times their score) or off
+		Dates     string  `default:"filter"`
+		DateBoost float64 `default:"1" split_words:"true"`
+		// RecencyHalfLife favors recent notes for questions that don't mention dates: a note's edge over older ones
+		// halves with every half-life, and the oldest lose up to RecencyWeight of their score; zero turns this off
+		RecencyHalfLife time.Duration `default:"0" split_words:"true"`
+		RecencyWeight   float64       `default:"0.5" split_words:"true"`
+	}
+	Rerank struct {
+		// Type is one of none, llm (the conversation model judges the candidates) or endpoint (a dedicated rerank API)
+		Type string `default:"none"`
+		// Candidates is the number of fragments retrieved for the reranker to pick the best from
+		Candidates int `default:"30"`
+		// Method is one of listwise or pointwise, for the llm type
+		Method      string `default:"listwise"`
+		Concurrency int    `default:"4"`
+		// URL, Model and Headers describe the endpoint type's API, e.g. http://localhost:8080/v1/rerank
+		URL     string
+		Model   string
+		Headers StringMap
+	}
+	Context struct {
+		// NumCtx is the size of the conversation model's context window in tokens; it's also passed on to ollama.
+		// Zero sends the whole conversation and leaves ollama's default alone.
+		NumCtx int `default:"4096" split_words:"true"`
+		// ResponseReserve is the number of tokens kept free for the answer
+		ResponseReserve int `default:"512" split_words:"true"`
+		// Compaction is one of drop or summarize, for older turns that don't fit the context window
+		Compaction string `default:"drop"`
+		// Policy is one of latest, last, merge or all, for the contexts retrieved for earlier turns
+		Policy string `default:"latest"`
+		// Keep is the number of turns whose contexts are kept (last) or merged (merge)
+		Keep int `default:"3"`
+	}
+	Condense struct {
+		// Enabled rewrites follow-up questions into standalone search queries using the conversation so far
+		Enabled bool `default:"true"`
+		// SubQueries is the number of extra search queries to ask for; their results are fused with the main query's
+		SubQueries int `default:"0" split_words:"true"`
+		// MaxMessages is the number of recent messages the rewrite considers
+		MaxMessages int `default:"6" split_words:"true"`
+	}
+	History struct {
+		// Path is the directory chats are saved to, one file per chat
+		Path string `default:"texttrove.chats"`
 	}
 	Behavior struct {
 		ShowPrompt         bool `default:"false" split_words:"true"`
 		MaxDocumentResults int  `default:"5"`
 	}
+	Agent struct {
+		// Enabled lets the model call tools (searching notes, resolving dates) instead of always searching up front
+		Enabled bool `default:"false"`
+		// ToolCalling is one of auto, native or react
+		ToolCalling   string        `default:"auto" split_words:"true"`
+		MaxIterations int           `default:"5" split_words:"true"`
+		ToolTimeout   time.Duration `default:"30s" split_words:"true"`
+	}
 	Logger struct {
 		HistorySize uint `default:"100"`
 	}
+	Server struct {
+		Address string `default:"127.0.0.1:8080"`
+		// APIKey, when set, must be sent by clients as a bearer token
+		APIKey string `split_words:"true"`
+	}
 }
 
-func main() {
-	// Load config from environment (using envconfig)
-	var cliCfg config
-	envconfig.MustProcess("", &cliCfg)
-	log.Printf("Starting TextTrove, using conversation model server: %v", cliCfg.Model.Conversation.URL)
+// command is a texttrove subcommand.
+type command struct {
+	name        string
+	description string
+	run         func(cliCfg config, args []string) error
+}
 
-	// Testing portkey gateway -- create a custom http agent and add some portkey headers
-	var c *http.Client
-	c = http.DefaultClient
-	if len(cliCfg.Model.Conversation.Headers) > 0 {
-		t := &StaticHeadersTransport{
-			Transport: http.DefaultTransport,
-			Headers:   cliCfg.Model.Conversation.Headers,
-		}
-		c = &http.Client{
-			Transport: t,
-		}
-	}
+var commands = []command{
+	{"tui", "Launch the interactive chat interface (default)", runTUI},
+	{"index", "Sync the document folder into the DB and exit", runIndex},
+	{"query", "Print the document fragments most relevant to the given text", runQuery},
+	{"ask", "Answer a single question using your notes", runAsk},
+	{"serve", "Serve an OpenAI-compatible API over your notes", runServe},
+	{"mcp", "Serve your notes as Model Context Protocol tools and resources", runMCP},
+}
 
-	// Create a (conversation) LLM
-	var conversationLlm llms.Model
-	var err error
-	switch cliCfg.Model.Conversation.Type {
-	case "ollama":
-		conversationLlm, err = ollama.New(
-			ollama.WithModel(cliCfg.Model.Conversation.Name),
-			ollama.WithServerURL(cliCfg.Model.Conversation.URL),
-			ollama.WithHTTPClient(c))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create conversation LLM: %v\n", err)
-			os.Exit(1)
+func main() {
+	// Figure out which subcommand to run; the TUI is the default
+	run := runTUI
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		run = nil
+		for _, c := range commands {
+			if c.name == args[0] {
+				run = c.run
+				break
+			}
 		}
-	case "openai":
-		conversationLlm, err = openai.New(
-			openai.WithModel(cliCfg.Model.Conversation.Name),
-			openai.WithBaseURL(cliCfg.Model.Conversation.URL),
-			openai.WithHTTPClient(c))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create conversation LLM: %v\n", err)
-			os.Exit(1)
+		if run == nil {
+			usage()
+			if args[0] == "help" {
+				return
+			}
+			os.Exit(2)
 		}
-	}
-	if conversationLlm == nil {
-		fmt.Fprintf(os.Stderr, "Failed to create conversation LLM: unknown type %s\n", cliCfg.Model.Conversation.Type)
-		os.Exit(1)
-	}
-
-	// Build doc DB
-	// TODO: this only supports ollama right now
-	r, err := rag.NewChromemRag(cliCfg.Database.Path, rag.ModelPrompts{
-		QueryPrefix:     cliCfg.Model.Embedding.PromptPrefix.Query,
-		EmbeddingPrefix: cliCfg.Model.Embedding.PromptPrefix.Embedding,
-	}, chromem.NewEmbeddingFuncOllama(cliCfg.Model.Embedding.Name, ""))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create rag: %v\n", err)
-		os.Exit(1)
+		args = args[1:]
 	}
 
-	// Load the DB
-	log.Println("Loading DB, this may take a bit on the first run...")
-	err = r.LoadDocuments(context.TODO(), cliCfg.Document.Path, cliCfg.Document.FilePattern)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load documents: %v\n", err)
-		os.Exit(1)
-	}
+	// Load config from environment (using envconfig)
+	var cliCfg config
+	envconfig.MustProcess("", &cliCfg)
 
-	// Create chat
-	// TODO: this needs to evolve if we support multiple chats in the future
-	chat, err := models.NewChat(
-		models.WithSystemPromptTemplateFile(cliCfg.SystemPromptPath),
-		models.WithContextTemplateFile(cliCfg.ContextPromptPath))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create chat: %v\n", err)
+	if err := run(cliCfg, args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+}
 
-	// if cliCfg.Behavior.AgentMode {
-	// 	// Set up conversational chain
-	// 	// TODO: refactor to not duplicate logic in chat.go
-	// 	// TODO: currently works only with explicit prompt files, not embedded default
-	// 	// Read the file
-	// 	b, err := os.ReadFile(cliCfg.ContextPromptPath)
-	// 	if err != nil {
-	// 		fmt.Fprintf(os.Stderr, "Failed to read context prompt file: %v\n", err)
-	// 		os.Exit(1)
-	// 	}
-	// 	tmpl := prompts.NewPromptTemplate(string(b), nil)
-	// 	if err != nil {
-	// 		fmt.Fprintf(os.Stderr, "Failed to read context prompt file: %v\n", err)
-	// 		os.Exit(1)
-	// 	}
-	// 	agentTools := []tools.Tool{
-	// 		trag.New(r, cliCfg.Behavior.MaxDocumentResults, tmpl),
-	// 	}
-	// 	conversationBuffer := memory.NewConversationBuffer(memory.WithChatHistory(chat))
-	// 	// llmChain := chains.NewConversation(conversationLlm, conversationBuffer)
-	// 	conversationAgent = agents.NewConversationalAgent(
-	// 		conversationLlm,
-	// 		agentTools,
-	// 		agents.WithMaxIterations(cliCfg.Agent.MaxIterations),
-	// 		agents.WithMemory(conversationBuffer))
-	// }
-
-	// Create a new app model
-	appCfg, err := app.DefaultConfig()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create default config: %v\n", err)
-		os.Exit(1)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: texttrove [command] [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.description)
 	}
-	appCfg.ConversationLLM = conversationLlm
-	appCfg.RAG = r
-	appCfg.ShowPromptInChat = cliCfg.Behavior.ShowPrompt
-	appCfg.LoggerHistorySize = cliCfg.Logger.HistorySize
-	appCfg.Chat = chat
-	appCfg.ChatSystemPromptPath = cliCfg.SystemPromptPath
-	appCfg.ChatContextPromptPath = cliCfg.ContextPromptPath
-	appModel, err := app.New(appCfg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create app model: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Swap the RAG logger with one that can hook into the TUI
-	r.SetLogger(appModel.Log)
+	fmt.Fprintf(os.Stderr, "\nConfiguration is read from the environment; see the config struct in main.go.\n")
+}
 
-	p := tea.NewProgram(appModel, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithKeyboardEnhancements())
-	if _, err := p.Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Oof: %v\n", err)
+// usageFor returns a usage func for the given subcommand flag set.
+func usageFor(fs *flag.FlagSet, synopsis, description string) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage: texttrove %s\n\n%s\n\nFlags:\n", synopsis, description)
+		fs.PrintDefaults()
 	}
 }
 
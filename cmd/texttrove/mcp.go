@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/mcp"
+)
+
+// runMCP exposes the document DB as a Model Context Protocol server.
+func runMCP(cliCfg config, args []string) error {
+	fs := flag.NewFlagSet("mcp", flag.ExitOnError)
+	fs.Usage = usageFor(fs, "mcp [flags]", "Serve your notes as Model Context Protocol tools and resources (stdio by default)")
+	httpAddr := fs.String("http", "", "serve over HTTP on this address instead of stdio")
+	_ = fs.Parse(args)
+
+	r, err := newRag(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create rag: %w", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Sync and watch in the background so clients aren't kept waiting on initialization;
+	// searches run against whatever has been indexed so far.
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		err := r.LoadDocuments(ctx, cliCfg.Document.Path, cliCfg.Document.FilePattern)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("err: failed to load documents: %v", err)
+		}
+	}()
+	defer func() {
+		// Stop loading and wait for it to return, so the watcher it may have started gets shut down
+		stop()
+		<-loaded
+		r.Shutdown(context.Background())
+	}()
+
+	s := mcp.New(mcp.Config{
+		Name:               "texttrove",
+		Version:            "dev",
+		RAG:                r,
+		BasePath:           cliCfg.Document.Path,
+		FilePattern:        cliCfg.Document.FilePattern,
+		MaxDocumentResults: cliCfg.Behavior.MaxDocumentResults,
+		Logger: func(msg string) {
+			log.Println(msg)
+		},
+	})
+
+	if *httpAddr == "" {
+		// stdout belongs to the protocol; logs go to stderr
+		return s.ServeStdio(ctx, os.Stdin, os.Stdout)
+	}
+
+	srv := &http.Server{
+		Addr:              *httpAddr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on http://%s/mcp", *httpAddr)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
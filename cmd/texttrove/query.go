@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/db/rag"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// queryResult is the JSON representation of a single query hit.
+type queryResult struct {
+	Rank     int            `json:"rank"`
+	Score    float32        `json:"score"`
+	Source   string         `json:"source"`
+	Content  string         `json:"content"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+// runQuery prints the documents most relevant to the given query.
+func runQuery(cliCfg config, args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs.Usage = usageFor(fs, "query [flags] <text>", "Print the document fragments most relevant to the given text")
+	n := fs.Int("n", cliCfg.Behavior.MaxDocumentResults, "number of results to return")
+	format := fs.String("format", "text", "output format (text or json)")
+	mode := fs.String("mode", cliCfg.Retrieval.Mode, "retrieval mode (hybrid, vector or lexical)")
+	vectorWeight := fs.Float64("vector-weight", cliCfg.Retrieval.VectorWeight, "weight of the vector ranking in hybrid mode")
+	lexicalWeight := fs.Float64("lexical-weight", cliCfg.Retrieval.LexicalWeight, "weight of the lexical ranking in hybrid mode")
+	linkHops := fs.Int("link-hops", cliCfg.Retrieval.LinkHops, "add fragments from notes within this many links of the results")
+	linkBudget := fs.Int("link-budget", cliCfg.Retrieval.LinkBudget, "maximum number of fragments added from linked notes")
+	dates := fs.String("dates", cliCfg.Retrieval.Dates, "what dates mentioned in the text do (filter, boost or off)")
+	dateBoost := fs.Float64("date-boost", cliCfg.Retrieval.DateBoost, "share of their score that notes from the mentioned dates gain in boost mode")
+	recencyHalfLife := fs.Duration("recency-half-life", cliCfg.Retrieval.RecencyHalfLife, "favor recent notes, halving their edge every half-life (0 turns this off)")
+	recencyWeight := fs.Float64("recency-weight", cliCfg.Retrieval.RecencyWeight, "share of their score the oldest notes lose to recency")
+	rerank := fs.Bool("rerank", true, "rerank the results, if a reranker is configured")
+	_ = fs.Parse(args)
+
+	q, err := readInput(fs.Args(), os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	r, err := newRag(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create rag: %w", err)
+	}
+
+	opts := r.ParseQueryOptions(q, time.Now())
+	if opts.Dates != nil {
+		fmt.Fprintf(os.Stderr, "Dates: %s (%s)\n", opts.Dates, opts.Dates.Phrase)
+	}
+	opts.N = *n
+	opts.Retrieval = &rag.Retrieval{
+		Mode:            rag.RetrievalMode(*mode),
+		VectorWeight:    *vectorWeight,
+		LexicalWeight:   *lexicalWeight,
+		LinkHops:        *linkHops,
+		LinkBudget:      *linkBudget,
+		Dates:           rag.DateMode(*dates),
+		DateBoost:       *dateBoost,
+		RecencyHalfLife: *recencyHalfLife,
+		RecencyWeight:   *recencyWeight,
+	}
+	opts.SkipRerank = !*rerank
+	docs, err := r.QueryWithOptions(context.Background(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to query: %w", err)
+	}
+
+	switch *format {
+	case "json":
+		return writeQueryJSON(os.Stdout, docs)
+	case "text":
+		return writeQueryText(os.Stdout, docs)
+	}
+	return fmt.Errorf("unknown format %s", *format)
+}
+
+func writeQueryJSON(w io.Writer, docs []schema.Document) error {
+	results := make([]queryResult, 0, len(docs))
+	for i, d := range docs {
+		results = append(results, queryResult{
+			Rank:     i + 1,
+			Score:    d.Score,
+			Source:   fmt.Sprintf("%v", d.Metadata["Source"]),
+			Content:  d.PageContent,
+			Metadata: d.Metadata,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+func writeQueryText(w io.Writer, docs []schema.Document) error {
+	for i, d := range docs {
+		_, err := fmt.Fprintf(w, "%d. [%.4f] %v%s\n", i+1, d.Score, d.Metadata["Source"], retrievalNote(d))
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(d.PageContent), "\n") {
+			_, err = fmt.Fprintf(w, "   %s\n", line)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// retrievalNote describes where a reranked document was before reranking, or which result a linked one was found
+// through.
+func retrievalNote(d schema.Document) string {
+	if from, ok := d.Metadata[rag.MetadataLinkedFrom]; ok {
+		return fmt.Sprintf(" (linked from %v, %v hop(s))", from, d.Metadata[rag.MetadataLinkHops])
+	}
+	rank, ok := d.Metadata[rag.MetadataRetrievalRank]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" (retrieved #%v, %.4f)", rank, d.Metadata[rag.MetadataRetrievalScore])
+}
+
+// readInput joins the given args into a single string, falling back to
+// reading r when no args (or a single "-") are given.
+func readInput(args []string, r io.Reader) (string, error) {
+	if len(args) > 0 && !(len(args) == 1 && args[0] == "-") {
+		return strings.Join(args, " "), nil
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "" {
+		return "", fmt.Errorf("no input given")
+	}
+	return s, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/models"
+	"github.com/clocklear/texttrove/pkg/server"
+)
+
+// runServe exposes an OpenAI-compatible HTTP API over the document DB.
+func runServe(cliCfg config, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = usageFor(fs, "serve [flags]", "Serve an OpenAI-compatible API with RAG-augmented chat completions")
+	addr := fs.String("addr", cliCfg.Server.Address, "address to listen on")
+	_ = fs.Parse(args)
+
+	conversationLlm, err := newConversationLLM(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create conversation LLM: %w", err)
+	}
+	r, err := newRag(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create rag: %w", err)
+	}
+
+	// Keep the DB up to date while serving
+	log.Println("Loading DB, this may take a bit on the first run...")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = r.LoadDocuments(ctx, cliCfg.Document.Path, cliCfg.Document.FilePattern)
+	if err != nil {
+		return fmt.Errorf("failed to load documents: %w", err)
+	}
+	defer r.Shutdown(context.Background())
+
+	s := server.New(server.Config{
+		LLM:                conversationLlm,
+		ModelName:          cliCfg.Model.Conversation.Name,
+		EmbeddingModelName: cliCfg.Model.Embedding.Name,
+		APIKey:             cliCfg.Server.APIKey,
+		RAG:                r,
+		NewChat: func() (*models.Chat, error) {
+			return newChat(cliCfg)
+		},
+		MaxDocumentResults: cliCfg.Behavior.MaxDocumentResults,
+		Logger: func(msg string) {
+			log.Println(msg)
+		},
+	})
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on http://%s/v1", *addr)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errs:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		log.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/clocklear/texttrove/pkg/agent"
+	"github.com/clocklear/texttrove/pkg/db/rag"
+	"github.com/clocklear/texttrove/pkg/document/markdown"
+	"github.com/clocklear/texttrove/pkg/embedding"
+	"github.com/clocklear/texttrove/pkg/models"
+	"github.com/clocklear/texttrove/pkg/rerank"
+	"github.com/clocklear/texttrove/pkg/tokens"
+	"github.com/clocklear/texttrove/pkg/tools/date"
+	trag "github.com/clocklear/texttrove/pkg/tools/rag"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+	"github.com/tmc/langchaingo/tools"
+)
+
+// newConversationLLM creates the conversation LLM described by the given config.
+func newConversationLLM(cliCfg config) (llms.Model, error) {
+	// Testing portkey gateway -- create a custom http agent and add some portkey headers
+	c := newHTTPClient(cliCfg.Model.Conversation.Headers)
+
+	switch cliCfg.Model.Conversation.Type {
+	case "ollama":
+		opts := []ollama.Option{
+			ollama.WithModel(cliCfg.Model.Conversation.Name),
+			ollama.WithServerURL(cliCfg.Model.Conversation.URL),
+			ollama.WithHTTPClient(c),
+		}
+		if cliCfg.Context.NumCtx > 0 {
+			// Make sure ollama's window is as big as the one we budget for
+			opts = append(opts, ollama.WithRunnerNumCtx(cliCfg.Context.NumCtx))
+		}
+		return ollama.New(opts...)
+	case "openai":
+		return openai.New(
+			openai.WithModel(cliCfg.Model.Conversation.Name),
+			openai.WithBaseURL(cliCfg.Model.Conversation.URL),
+			openai.WithHTTPClient(c))
+	}
+	return nil, fmt.Errorf("unknown type %s", cliCfg.Model.Conversation.Type)
+}
+
+// newHTTPClient returns a client that adds the given headers to every request.
+func newHTTPClient(headers StringMap) *http.Client {
+	if len(headers) == 0 {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: &StaticHeadersTransport{
+			Transport: http.DefaultTransport,
+			Headers:   headers,
+		},
+	}
+}
+
+// newRag creates the document DB described by the given config.  Documents are not loaded.
+func newRag(cliCfg config) (*rag.ChromemRag, error) {
+	provider, err := embedding.New(embedding.Config{
+		Type:   cliCfg.Model.Embedding.Type,
+		Model:  cliCfg.Model.Embedding.Name,
+		URL:    cliCfg.Model.Embedding.URL,
+		Client: newHTTPClient(cliCfg.Model.Embedding.Headers),
+	})
+	if err != nil {
+		return nil, err
+	}
+	pipeline := embedding.NewPipeline(provider,
+		embedding.WithBatchSize(cliCfg.Model.Embedding.BatchSize),
+		embedding.WithConcurrency(cliCfg.Model.Embedding.Concurrency),
+		embedding.WithRateLimit(cliCfg.Model.Embedding.RateLimit),
+		embedding.WithRetries(cliCfg.Model.Embedding.MaxRetries, cliCfg.Model.Embedding.RetryBackoff))
+	policy := rag.ModelChangePolicy(cliCfg.Database.OnModelChange)
+	if policy != rag.RebuildOnModelChange && policy != rag.RefuseOnModelChange {
+		return nil, fmt.Errorf("unknown DATABASE_ON_MODEL_CHANGE %q; use rebuild or refuse", policy)
+	}
+	retrieval := rag.Retrieval{
+		Mode:            rag.RetrievalMode(cliCfg.Retrieval.Mode),
+		VectorWeight:    cliCfg.Retrieval.VectorWeight,
+		LexicalWeight:   cliCfg.Retrieval.LexicalWeight,
+		LinkHops:        cliCfg.Retrieval.LinkHops,
+		LinkBudget:      cliCfg.Retrieval.LinkBudget,
+		Dates:           rag.DateMode(cliCfg.Retrieval.Dates),
+		DateBoost:       cliCfg.Retrieval.DateBoost,
+		RecencyHalfLife: cliCfg.Retrieval.RecencyHalfLife,
+		RecencyWeight:   cliCfg.Retrieval.RecencyWeight,
+	}
+	if !retrieval.Mode.Valid() {
+		return nil, fmt.Errorf("unknown RETRIEVAL_MODE %q; use hybrid, vector or lexical", retrieval.Mode)
+	}
+	if !retrieval.Dates.Valid() {
+		return nil, fmt.Errorf("unknown RETRIEVAL_DATES %q; use filter, boost or off", retrieval.Dates)
+	}
+	chunking := markdown.Chunking{
+		Strategy: markdown.ChunkStrategy(cliCfg.Document.ChunkStrategy),
+		Size:     cliCfg.Document.ChunkSize,
+		Overlap:  cliCfg.Document.ChunkOverlap,
+		Units:    markdown.ChunkUnits(cliCfg.Document.ChunkUnits),
+		Tokens:   tokens.ForModel(cliCfg.Model.Embedding.Name),
+	}
+	if !chunking.Strategy.Valid() {
+		return nil, fmt.Errorf("unknown DOCUMENT_CHUNK_STRATEGY %q; use markdown, heading, sentence or blocks", chunking.Strategy)
+	}
+	if !chunking.Units.Valid() {
+		return nil, fmt.Errorf("unknown DOCUMENT_CHUNK_UNITS %q; use chars or tokens", chunking.Units)
+	}
+	if chunking.Size <= 0 || chunking.Overlap < 0 || chunking.Overlap >= chunking.Size {
+		return nil, errors.New("DOCUMENT_CHUNK_SIZE must be positive, and DOCUMENT_CHUNK_OVERLAP at least 0 and less than the size")
+	}
+	opts := []rag.Option{
+		rag.WithBatchEmbedder(pipeline),
+		rag.WithEmbeddingModel(cliCfg.Model.Embedding.Name),
+		rag.WithModelChangePolicy(policy),
+		rag.WithRetrieval(retrieval),
+		rag.WithEmbedDepth(cliCfg.Document.EmbedDepth),
+		rag.WithChunking(chunking),
+	}
+	if cliCfg.Rerank.Type != "none" {
+		reranker, err := newReranker(cliCfg)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, rag.WithReranker(reranker, cliCfg.Rerank.Candidates))
+	}
+	r, err := rag.NewChromemRag(cliCfg.Database.Path, rag.ModelPrompts{
+		QueryPrefix:     cliCfg.Model.Embedding.PromptPrefix.Query,
+		EmbeddingPrefix: cliCfg.Model.Embedding.PromptPrefix.Embedding,
+	}, embedding.Func(pipeline), opts...)
+	if errors.Is(err, rag.ErrModelChanged) {
+		return nil, fmt.Errorf("%w; set DATABASE_ON_MODEL_CHANGE=rebuild to re-embed your notes, or point DATABASE_PATH elsewhere", err)
+	}
+	return r, err
+}
+
+// newReranker creates the reranker described by the given config.
+func newReranker(cliCfg config) (rerank.Reranker, error) {
+	cfg := rerank.Config{
+		Type:        cliCfg.Rerank.Type,
+		Method:      rerank.Method(cliCfg.Rerank.Method),
+		Concurrency: cliCfg.Rerank.Concurrency,
+		URL:         cliCfg.Rerank.URL,
+		Model:       cliCfg.Rerank.Model,
+		Client:      newHTTPClient(cliCfg.Rerank.Headers),
+	}
+	if cfg.Type == rerank.TypeLLM {
+		llm, err := newConversationLLM(cliCfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.LLM = llm
+	}
+	return rerank.New(cfg)
+}
+
+// newBudget describes the conversation model's context window.
+func newBudget(cliCfg config) (models.Budget, error) {
+	if cliCfg.Context.Compaction != "drop" && cliCfg.Context.Compaction != "summarize" {
+		return models.Budget{}, fmt.Errorf("unknown CONTEXT_COMPACTION %q; use drop or summarize", cliCfg.Context.Compaction)
+	}
+	return models.Budget{
+		Limit:    cliCfg.Context.NumCtx,
+		Reserve:  cliCfg.Context.ResponseReserve,
+		Estimate: tokens.ForModel(cliCfg.Model.Conversation.Name),
+	}, nil
+}
+
+// newChat creates a chat using the prompt templates described by the given config.
+func newChat(cliCfg config) (*models.Chat, error) {
+	return models.NewChat(
+		models.WithModel(cliCfg.Model.Conversation.Name),
+		models.WithSystemPromptTemplateFile(cliCfg.SystemPromptPath),
+		models.WithContextTemplateFile(cliCfg.ContextPromptPath),
+		models.WithContextPolicy(models.ContextPolicy{
+			Mode: models.ContextPolicyMode(cliCfg.Context.Policy),
+			Keep: cliCfg.Context.Keep,
+		}))
+}
+
+// newAgent creates a tool-calling agent with access to the document DB.
+func newAgent(cliCfg config, llm llms.Model, r *rag.ChromemRag, chat *models.Chat) (*agent.Agent, error) {
+	mode := agent.Mode(cliCfg.Agent.ToolCalling)
+	var opts []agent.Option
+	if mode == "auto" {
+		// langchaingo only supports native tool calling for openai-compatible servers, and not every model they
+		// serve supports tools
+		mode = agent.ModeReAct
+		if cliCfg.Model.Conversation.Type == "openai" {
+			mode = agent.ModeNative
+			opts = append(opts, agent.WithReActFallback())
+		}
+	}
+	if mode != agent.ModeNative && mode != agent.ModeReAct {
+		return nil, fmt.Errorf("unknown tool calling mode %s", cliCfg.Agent.ToolCalling)
+	}
+	agentTools := []tools.Tool{
+		trag.New(r, cliCfg.Behavior.MaxDocumentResults, chat.ContextTemplate()),
+		date.New(),
+	}
+	opts = append(opts,
+		agent.WithMode(mode),
+		agent.WithMaxIterations(cliCfg.Agent.MaxIterations),
+		agent.WithToolTimeout(cliCfg.Agent.ToolTimeout))
+	return agent.New(llm, agentTools, opts...), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/clocklear/texttrove/app"
+	"github.com/clocklear/texttrove/pkg/condense"
+	"github.com/clocklear/texttrove/pkg/db/chats"
+	"github.com/clocklear/texttrove/pkg/models"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+)
+
+// runTUI launches the interactive chat interface.
+func runTUI(cliCfg config, args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	fs.Usage = usageFor(fs, "tui [flags]", "Launch the interactive chat interface (default)")
+	resume := fs.Bool("resume", false, "reopen the most recent chat")
+	_ = fs.Parse(args)
+
+	log.Printf("Starting TextTrove, using conversation model server: %v", cliCfg.Model.Conversation.URL)
+
+	// Create a (conversation) LLM
+	conversationLlm, err := newConversationLLM(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create conversation LLM: %w", err)
+	}
+
+	// Build doc DB
+	r, err := newRag(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create rag: %w", err)
+	}
+
+	// Create the chat for the first tab
+	chat, err := newChat(cliCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create chat: %w", err)
+	}
+
+	// Open the chat history
+	store, err := chats.NewStore(cliCfg.History.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open chat history: %w", err)
+	}
+	if *resume {
+		r, err := store.Latest()
+		switch {
+		case errors.Is(err, chats.ErrNotFound):
+			log.Println("No previous chat to resume")
+		case err != nil:
+			return fmt.Errorf("failed to resume chat: %w", err)
+		default:
+			chat.Restore(r)
+		}
+	}
+
+	// Create a new app model
+	appCfg, err := app.DefaultConfig()
+	if err != nil {
+		return fmt.Errorf("failed to create default config: %w", err)
+	}
+	appCfg.ConversationLLM = conversationLlm
+	appCfg.RAG = r
+	if cliCfg.Agent.Enabled {
+		appCfg.Agent, err = newAgent(cliCfg, conversationLlm, r, chat)
+		if err != nil {
+			return fmt.Errorf("failed to create agent: %w", err)
+		}
+	}
+	if cliCfg.Condense.Enabled {
+		appCfg.Condenser = condense.New(conversationLlm,
+			condense.WithSubQueries(cliCfg.Condense.SubQueries),
+			condense.WithMaxMessages(cliCfg.Condense.MaxMessages))
+	}
+	appCfg.Budget, err = newBudget(cliCfg)
+	if err != nil {
+		return err
+	}
+	if cliCfg.Context.Compaction == "summarize" {
+		appCfg.Summarizer = condense.NewSummarizer(conversationLlm)
+	}
+	appCfg.DocumentPath = cliCfg.Document.Path
+	appCfg.ObsidianVault = cliCfg.Document.ObsidianVault
+	appCfg.ShowPromptInChat = cliCfg.Behavior.ShowPrompt
+	appCfg.MaxDocumentResults = cliCfg.Behavior.MaxDocumentResults
+	appCfg.LoggerHistorySize = cliCfg.Logger.HistorySize
+	appCfg.Chat = chat
+	appCfg.NewChat = func() (*models.Chat, error) { return newChat(cliCfg) }
+	appCfg.ChatStore = store
+	appCfg.ChatSystemPromptPath = cliCfg.SystemPromptPath
+	appCfg.ChatContextPromptPath = cliCfg.ContextPromptPath
+	appModel, err := app.New(appCfg)
+	if err != nil {
+		return fmt.Errorf("failed to create app model: %w", err)
+	}
+
+	// Swap the RAG and chat history loggers with ones that can hook into the TUI
+	r.SetLogger(appModel.Log)
+	store.SetLogger(appModel.Log)
+	r.SetProgressHandler(appModel.IndexProgress)
+
+	// Load the DB in the background; the TUI reports progress and queries see whatever has been indexed so far
+	ctx, cancel := context.WithCancel(context.Background())
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		err := r.LoadDocuments(ctx, cliCfg.Document.Path, cliCfg.Document.FilePattern)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			appModel.Log(fmt.Sprintf("err: failed to load documents: %v", err))
+		}
+	}()
+	defer func() {
+		// Stop loading and wait for it to return, so the watcher it may have started gets shut down
+		cancel()
+		<-loaded
+		r.Shutdown(context.Background())
+	}()
+
+	p := tea.NewProgram(appModel, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithKeyboardEnhancements())
+	if _, err := p.Run(); err != nil {
+		return fmt.Errorf("oof: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/tools"
+)
+
+// Mode describes how tools are offered to the model.
+type Mode string
+
+const (
+	// ModeNative uses the model's native function calling (llms.WithTools).
+	ModeNative Mode = "native"
+	// ModeReAct describes the tools in the prompt and parses tool calls out of the model's text.
+	// This works with models (or langchaingo backends) that don't support native function calling.
+	ModeReAct Mode = "react"
+)
+
+// ErrMaxIterations is returned when the model is still calling tools after the maximum number of iterations.
+var ErrMaxIterations = errors.New("agent stopped after reaching the maximum number of iterations")
+
+const reactInstructionsTpl = `You have access to the following tools:
+
+%s
+To use a tool, respond with exactly the following and nothing else:
+
+Thought: why you need the tool
+Action: the tool name, one of [%s]
+Action Input: the input to the tool
+
+The result of the tool will be provided to you as an Observation.  You may use tools as many times as you need.
+When you have enough information, respond to the user directly without using the Action format.`
+
+var reactActionRe = regexp.MustCompile(`(?s)Action:\s*(.+?)\s*\nAction Input:\s*(.*)`)
+
+// toolsUnsupportedRe matches the errors servers return for requests with tools when the model can't use them, e.g.
+// Ollama's "does not support tools" or llama.cpp's "tools param requires --jinja flag".
+var toolsUnsupportedRe = regexp.MustCompile(`(?i)(does not|doesn't|not) support (tools|tool calling|function calling)|(tools|tool calling|function calling) (is |are )?(not supported|unsupported)|tools param requires`)
+
+// Handler receives events as the agent runs.  All funcs are optional.
+type Handler struct {
+	// OnChunk receives streamed text of the model's current response
+	OnChunk func(chunk string)
+	// OnDiscard is called when the streamed text turns out to belong to a tool call
+	// rather than the final answer, and should be thrown away
+	OnDiscard func()
+	// OnMessage receives each tool call and tool result message, in order, for the chat history
+	OnMessage func(msg llms.MessageContent)
+}
+
+// Agent runs a tool-calling loop against a model.
+type Agent struct {
+	llm           llms.Model
+	tools         []tools.Tool
+	mode          Mode
+	maxIterations int
+	toolTimeout   time.Duration
+	// fallback switches from native tool calling to ReAct once the model turns out not to support tools
+	fallback bool
+	fellBack atomic.Bool
+}
+
+type Option func(*Agent)
+
+// WithMode sets how tools are offered to the model; defaults to ModeNative.
+func WithMode(mode Mode) Option {
+	return func(a *Agent) {
+		a.mode = mode
+	}
+}
+
+// WithReActFallback switches to ModeReAct when the model rejects native tool calls, for the rest of the run and
+// those after it.
+func WithReActFallback() Option {
+	return func(a *Agent) {
+		a.fallback = true
+	}
+}
+
+// WithMaxIterations sets the maximum number of model calls per run; defaults to 5.
+func WithMaxIterations(n int) Option {
+	return func(a *Agent) {
+		a.maxIterations = n
+	}
+}
+
+// WithToolTimeout sets the maximum duration of a single tool call; defaults to 30s.
+func WithToolTimeout(d time.Duration) Option {
+	return func(a *Agent) {
+		a.toolTimeout = d
+	}
+}
+
+func New(llm llms.Model, agentTools []tools.Tool, opts ...Option) *Agent {
+	a := &Agent{
+		llm:           llm,
+		tools:         agentTools,
+		mode:          ModeNative,
+		maxIterations: 5,
+		toolTimeout:   30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Mode returns how tools are offered to the model.
+func (a *Agent) Mode() Mode {
+	if a.fellBack.Load() {
+		return ModeReAct
+	}
+	return a.mode
+}
+
+// Run answers the conversation in history, calling tools as requested by the model, and returns the final answer.
+// Tool calls and results are reported to h.OnMessage as they happen, always in the native (llms.ToolCall /
+// llms.ToolCallResponse) representation so the history renders the same regardless of mode.
+func (a *Agent) Run(ctx context.Context, history []llms.MessageContent, h Handler) (string, error) {
+	msgs := append([]llms.MessageContent{}, history...)
+	for i := 0; i < a.maxIterations; i++ {
+		var (
+			content string
+			calls   []llms.ToolCall
+			err     error
+		)
+		switch a.Mode() {
+		case ModeReAct:
+			content, calls, err = a.stepReAct(ctx, msgs, h, i)
+		default:
+			content, calls, err = a.stepNative(ctx, msgs, h)
+			if err != nil && a.fallback && toolsUnsupportedRe.MatchString(err.Error()) {
+				a.fellBack.Store(true)
+				h.discard()
+				content, calls, err = a.stepReAct(ctx, msgs, h, i)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(calls) == 0 {
+			return content, nil
+		}
+
+		// The model wants to use tools; record the request, then the results
+		h.discard()
+		parts := []llms.ContentPart{}
+		if content != "" {
+			parts = append(parts, llms.TextContent{Text: content})
+		}
+		for _, c := range calls {
+			parts = append(parts, c)
+		}
+		msg := llms.MessageContent{Role: llms.ChatMessageTypeAI, Parts: parts}
+		msgs = append(msgs, msg)
+		h.message(msg)
+		for _, c := range calls {
+			msg := llms.MessageContent{
+				Role: llms.ChatMessageTypeTool,
+				Parts: []llms.ContentPart{llms.ToolCallResponse{
+					ToolCallID: c.ID,
+					Name:       c.FunctionCall.Name,
+					Content:    a.callTool(ctx, c),
+				}},
+			}
+			msgs = append(msgs, msg)
+			h.message(msg)
+		}
+	}
+	return "", ErrMaxIterations
+}
+
+func (a *Agent) stepNative(ctx context.Context, msgs []llms.MessageContent, h Handler) (string, []llms.ToolCall, error) {
+	res, err := a.llm.GenerateContent(ctx, msgs,
+		llms.WithTools(a.toolDefinitions()),
+		llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			// Streamed tool call deltas arrive as JSON; only pass along actual content
+			if !isToolCallChunk(chunk) {
+				h.chunk(string(chunk))
+			}
+			// Stop reading the stream once the run has been cancelled
+			return ctx.Err()
+		}))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(res.Choices) == 0 {
+		return "", nil, errors.New("model returned no choices")
+	}
+	return res.Choices[0].Content, res.Choices[0].ToolCalls, nil
+}
+
+func (a *Agent) stepReAct(ctx context.Context, msgs []llms.MessageContent, h Handler, iteration int) (string, []llms.ToolCall, error) {
+	res, err := a.llm.GenerateContent(ctx, a.reactMessages(msgs),
+		llms.WithStopWords([]string{"\nObservation:"}),
+		llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+			h.chunk(string(chunk))
+			return ctx.Err()
+		}))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(res.Choices) == 0 {
+		return "", nil, errors.New("model returned no choices")
+	}
+	content := res.Choices[0].Content
+	m := reactActionRe.FindStringSubmatch(content)
+	if m == nil {
+		// Final answer; tidy up any ReAct scaffolding the model left behind
+		answer := content
+		if idx := strings.Index(answer, "Final Answer:"); idx >= 0 {
+			answer = strings.TrimSpace(answer[idx+len("Final Answer:"):])
+		}
+		if answer != content {
+			h.discard()
+			h.chunk(answer)
+		}
+		return answer, nil, nil
+	}
+	thought := strings.TrimSpace(strings.TrimPrefix(content[:strings.Index(content, m[0])], "Thought:"))
+	input := strings.TrimSpace(strings.SplitN(m[2], "\nObservation:", 2)[0])
+	args, _ := json.Marshal(map[string]string{"input": input})
+	return thought, []llms.ToolCall{{
+		ID:   fmt.Sprintf("react-%d", iteration),
+		Type: "function",
+		FunctionCall: &llms.FunctionCall{
+			Name:      strings.Trim(m[1], "[]` "),
+			Arguments: string(args),
+		},
+	}}, nil
+}
+
+// reactMessages converts the history into text-only messages, describing the tools in a system
+// message and rendering tool calls and results in the ReAct format.
+func (a *Agent) reactMessages(msgs []llms.MessageContent) []llms.MessageContent {
+	out := make([]llms.MessageContent, 0, len(msgs)+1)
+	instructions := llms.TextParts(llms.ChatMessageTypeSystem, a.reactInstructions())
+	for i, m := range msgs {
+		// The instructions go right after the leading system prompt
+		if i == 0 && m.Role != llms.ChatMessageTypeSystem {
+			out = append(out, instructions)
+		}
+		switch m.Role {
+		case llms.ChatMessageTypeAI:
+			sb := strings.Builder{}
+			for _, p := range m.Parts {
+				switch p := p.(type) {
+				case llms.TextContent:
+					if len(m.Parts) > 1 {
+						sb.WriteString("Thought: ")
+					}
+					sb.WriteString(p.Text)
+					sb.WriteString("\n")
+				case llms.ToolCall:
+					fmt.Fprintf(&sb, "Action: %s\nAction Input: %s\n", p.FunctionCall.Name, ToolInput(p))
+				}
+			}
+			out = append(out, llms.TextParts(llms.ChatMessageTypeAI, strings.TrimSpace(sb.String())))
+		case llms.ChatMessageTypeTool:
+			for _, p := range m.Parts {
+				if r, ok := p.(llms.ToolCallResponse); ok {
+					out = append(out, llms.TextParts(llms.ChatMessageTypeHuman, "Observation: "+r.Content))
+				}
+			}
+		default:
+			out = append(out, m)
+		}
+		if i == 0 && m.Role == llms.ChatMessageTypeSystem {
+			out = append(out, instructions)
+		}
+	}
+	return out
+}
+
+func (a *Agent) reactInstructions() string {
+	descriptions := strings.Builder{}
+	names := make([]string, 0, len(a.tools))
+	for _, t := range a.tools {
+		fmt.Fprintf(&descriptions, "- %s: %s\n", t.Name(), t.Description())
+		names = append(names, t.Name())
+	}
+	return fmt.Sprintf(reactInstructionsTpl, descriptions.String(), strings.Join(names, ", "))
+}
+
+func (a *Agent) toolDefinitions() []llms.Tool {
+	defs := make([]llms.Tool, 0, len(a.tools))
+	for _, t := range a.tools {
+		defs = append(defs, llms.Tool{
+			Type: "function",
+			Function: &llms.FunctionDefinition{
+				Name:        t.Name(),
+				Description: t.Description(),
+				Parameters: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"input": map[string]any{
+							"type":        "string",
+							"description": "The input to the tool",
+						},
+					},
+					"required": []string{"input"},
+				},
+			},
+		})
+	}
+	return defs
+}
+
+// callTool runs the requested tool, returning its output or a description of the failure for the model.
+func (a *Agent) callTool(ctx context.Context, c llms.ToolCall) string {
+	if c.FunctionCall == nil {
+		return "Error: tool call is missing a function"
+	}
+	for _, t := range a.tools {
+		if t.Name() != c.FunctionCall.Name {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, a.toolTimeout)
+		defer cancel()
+		out, err := t.Call(ctx, ToolInput(c))
+		if err != nil {
+			return "Error: " + err.Error()
+		}
+		return out
+	}
+	return fmt.Sprintf("Error: unknown tool %q", c.FunctionCall.Name)
+}
+
+// ToolInput extracts the string input of a tool call.
+func ToolInput(c llms.ToolCall) string {
+	if c.FunctionCall == nil {
+		return ""
+	}
+	var args struct {
+		Input *string `json:"input"`
+	}
+	if err := json.Unmarshal([]byte(c.FunctionCall.Arguments), &args); err != nil || args.Input == nil {
+		// Not the shape we asked for; hand the tool the raw arguments
+		return c.FunctionCall.Arguments
+	}
+	return *args.Input
+}
+
+// isToolCallChunk reports whether a streamed chunk is a JSON encoded tool call delta rather than content.
+func isToolCallChunk(chunk []byte) bool {
+	if len(chunk) < 2 || chunk[0] != '[' || chunk[1] != '{' {
+		return false
+	}
+	var deltas []struct {
+		Function json.RawMessage `json:"function"`
+	}
+	if err := json.Unmarshal(chunk, &deltas); err != nil {
+		return false
+	}
+	return len(deltas) > 0 && deltas[0].Function != nil
+}
+
+func (h Handler) chunk(s string) {
+	if h.OnChunk != nil && s != "" {
+		h.OnChunk(s)
+	}
+}
+
+func (h Handler) discard() {
+	if h.OnDiscard != nil {
+		h.OnDiscard()
+	}
+}
+
+func (h Handler) message(m llms.MessageContent) {
+	if h.OnMessage != nil {
+		h.OnMessage(m)
+	}
+}
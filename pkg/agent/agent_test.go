@@ -0,0 +1,405 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/tools"
+)
+
+// stubResponse is one scripted model response: streamed as chunks, then returned with content and tool calls.
+type stubResponse struct {
+	chunks  []string
+	content string
+	calls   []llms.ToolCall
+}
+
+// stubModel answers each call with the next scripted response, recording what it was sent.  With toolsErr set, calls
+// offering tools fail with it, like a model without tool support.
+type stubModel struct {
+	responses []stubResponse
+	toolsErr  error
+	calls     int
+	got       [][]llms.MessageContent
+	opts      []llms.CallOptions
+}
+
+func (m *stubModel) GenerateContent(ctx context.Context, msgs []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	var o llms.CallOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m.got = append(m.got, msgs)
+	m.opts = append(m.opts, o)
+	if m.toolsErr != nil && len(o.Tools) > 0 {
+		return nil, m.toolsErr
+	}
+	if m.calls >= len(m.responses) {
+		return nil, errors.New("no more scripted responses")
+	}
+	r := m.responses[m.calls]
+	m.calls++
+	for _, c := range r.chunks {
+		if o.StreamingFunc != nil {
+			if err := o.StreamingFunc(ctx, []byte(c)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: r.content, ToolCalls: r.calls}}}, nil
+}
+
+func (m *stubModel) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, opts...)
+}
+
+// stubTool echoes its input, or runs fn if set.
+type stubTool struct {
+	name   string
+	fn     func(ctx context.Context, input string) (string, error)
+	inputs []string
+}
+
+func (t *stubTool) Name() string        { return t.name }
+func (t *stubTool) Description() string { return "finds " + t.name }
+func (t *stubTool) Call(ctx context.Context, input string) (string, error) {
+	t.inputs = append(t.inputs, input)
+	if t.fn != nil {
+		return t.fn(ctx, input)
+	}
+	return "result for " + input, nil
+}
+
+func toolCall(id, name, args string) llms.ToolCall {
+	return llms.ToolCall{ID: id, Type: "function", FunctionCall: &llms.FunctionCall{Name: name, Arguments: args}}
+}
+
+// recorder collects the events a Handler receives.
+type recorder struct {
+	text     strings.Builder
+	discards int
+	messages []llms.MessageContent
+}
+
+func (r *recorder) handler() Handler {
+	return Handler{
+		OnChunk:   func(s string) { r.text.WriteString(s) },
+		OnDiscard: func() { r.discards++; r.text.Reset() },
+		OnMessage: func(m llms.MessageContent) { r.messages = append(r.messages, m) },
+	}
+}
+
+// toolResults returns the content of the tool results among the messages.
+func toolResults(msgs []llms.MessageContent) []string {
+	var res []string
+	for _, m := range msgs {
+		for _, p := range m.Parts {
+			if r, ok := p.(llms.ToolCallResponse); ok {
+				res = append(res, r.Content)
+			}
+		}
+	}
+	return res
+}
+
+func TestRunNative(t *testing.T) {
+	tests := []struct {
+		name        string
+		responses   []stubResponse
+		want        string
+		wantInputs  []string
+		wantResults []string
+		wantText    string
+	}{
+		{
+			name:      "answers without tools",
+			responses: []stubResponse{{chunks: []string{"Hi", " there"}, content: "Hi there"}},
+			want:      "Hi there",
+			wantText:  "Hi there",
+		},
+		{
+			name: "calls a tool, then answers",
+			responses: []stubResponse{
+				{chunks: []string{`[{"function":{"name":"notes","arguments":"{}"}}]`}, calls: []llms.ToolCall{toolCall("1", "notes", `{"input":"kafka"}`)}},
+				{chunks: []string{"Kafka is a log"}, content: "Kafka is a log"},
+			},
+			want:        "Kafka is a log",
+			wantInputs:  []string{"kafka"},
+			wantResults: []string{"result for kafka"},
+			wantText:    "Kafka is a log",
+		},
+		{
+			name: "passes arguments of another shape through",
+			responses: []stubResponse{
+				{calls: []llms.ToolCall{toolCall("1", "notes", `{"query":"kafka"}`)}},
+				{content: "done"},
+			},
+			want:        "done",
+			wantInputs:  []string{`{"query":"kafka"}`},
+			wantResults: []string{`result for {"query":"kafka"}`},
+		},
+		{
+			name: "reports unknown tools to the model",
+			responses: []stubResponse{
+				{calls: []llms.ToolCall{toolCall("1", "web", `{"input":"x"}`)}},
+				{content: "done"},
+			},
+			want:        "done",
+			wantResults: []string{`Error: unknown tool "web"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &stubModel{responses: tt.responses}
+			tool := &stubTool{name: "notes"}
+			rec := &recorder{}
+			got, err := New(model, []tools.Tool{tool}).Run(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "q")}, rec.handler())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("answer = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(tool.inputs, tt.wantInputs) {
+				t.Errorf("tool inputs = %q, want %q", tool.inputs, tt.wantInputs)
+			}
+			if res := toolResults(rec.messages); !slices.Equal(res, tt.wantResults) {
+				t.Errorf("tool results = %q, want %q", res, tt.wantResults)
+			}
+			if rec.text.String() != tt.wantText {
+				t.Errorf("streamed text = %q, want %q", rec.text.String(), tt.wantText)
+			}
+			if len(model.opts[0].Tools) != 1 || model.opts[0].Tools[0].Function.Name != "notes" {
+				t.Errorf("tools offered = %+v, want notes", model.opts[0].Tools)
+			}
+		})
+	}
+}
+
+func TestRunReAct(t *testing.T) {
+	tests := []struct {
+		name        string
+		responses   []string
+		want        string
+		wantTool    string
+		wantInputs  []string
+		wantThought string
+	}{
+		{
+			name:      "answers without tools",
+			responses: []string{"Kafka is a log"},
+			want:      "Kafka is a log",
+		},
+		{
+			name:      "strips the final answer scaffolding",
+			responses: []string{"Thought: I know this\nFinal Answer: Kafka is a log"},
+			want:      "Kafka is a log",
+		},
+		{
+			name:        "calls a tool, then answers",
+			responses:   []string{"Thought: I should look\nAction: notes\nAction Input: kafka retention", "It's 7 days"},
+			want:        "It's 7 days",
+			wantTool:    "notes",
+			wantInputs:  []string{"kafka retention"},
+			wantThought: "I should look",
+		},
+		{
+			name:       "tidies the tool name and cuts off a made up observation",
+			responses:  []string{"Action: [notes]\nAction Input: kafka\nObservation: it's great", "done"},
+			want:       "done",
+			wantTool:   "notes",
+			wantInputs: []string{"kafka"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var responses []stubResponse
+			for _, r := range tt.responses {
+				responses = append(responses, stubResponse{chunks: []string{r}, content: r})
+			}
+			model := &stubModel{responses: responses}
+			tool := &stubTool{name: "notes"}
+			rec := &recorder{}
+			history := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeSystem, "be nice"), llms.TextParts(llms.ChatMessageTypeHuman, "q")}
+			got, err := New(model, []tools.Tool{tool}, WithMode(ModeReAct)).Run(context.Background(), history, rec.handler())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || rec.text.String() != tt.want {
+				t.Errorf("answer = %q, streamed %q; want %q", got, rec.text.String(), tt.want)
+			}
+			if !slices.Equal(tool.inputs, tt.wantInputs) {
+				t.Errorf("tool inputs = %q, want %q", tool.inputs, tt.wantInputs)
+			}
+			if !slices.Equal(model.opts[0].StopWords, []string{"\nObservation:"}) {
+				t.Errorf("stop words = %q, want the observation marker", model.opts[0].StopWords)
+			}
+			// The tools are described right after the system prompt
+			if sent := model.got[0]; len(sent) != 3 || !strings.Contains(sent[1].Parts[0].(llms.TextContent).Text, "- notes: finds notes") {
+				t.Errorf("first request = %+v, want the tool instructions after the system prompt", sent)
+			}
+			if tt.wantTool == "" {
+				return
+			}
+			// The call is recorded natively, and replayed to the model as an action and an observation
+			call := rec.messages[0]
+			if tc, ok := call.Parts[len(call.Parts)-1].(llms.ToolCall); !ok || tc.FunctionCall.Name != tt.wantTool || ToolInput(tc) != tt.wantInputs[0] {
+				t.Errorf("recorded call = %+v, want %s(%s)", call, tt.wantTool, tt.wantInputs[0])
+			}
+			if tt.wantThought != "" && call.Parts[0].(llms.TextContent).Text != tt.wantThought {
+				t.Errorf("recorded thought = %+v, want %q", call.Parts[0], tt.wantThought)
+			}
+			sent := model.got[1]
+			action := sent[len(sent)-2].Parts[0].(llms.TextContent).Text
+			observation := sent[len(sent)-1].Parts[0].(llms.TextContent).Text
+			if !strings.Contains(action, "Action: "+tt.wantTool+"\nAction Input: "+tt.wantInputs[0]) || observation != "Observation: result for "+tt.wantInputs[0] {
+				t.Errorf("replayed %q then %q, want the action and its observation", action, observation)
+			}
+		})
+	}
+}
+
+func TestRunReActFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		toolsErr  string
+		fallback  bool
+		wantErr   bool
+		wantCalls int
+	}{
+		{"falls back when tools are unsupported", `registry.ollama.ai/library/gemma:2b does not support tools`, true, false, 2},
+		{"falls back on llama.cpp's error", `tools param requires --jinja flag`, true, false, 2},
+		{"falls back on LM Studio's error", `Tool calling is not supported by this model`, true, false, 2},
+		{"other errors are returned", `connection refused`, true, true, 0},
+		{"only when asked to", `does not support tools`, false, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &stubModel{
+				toolsErr: errors.New(tt.toolsErr),
+				responses: []stubResponse{
+					{content: "Action: notes\nAction Input: kafka"},
+					{content: "Kafka is a log"},
+					{content: "Still a log"},
+				},
+			}
+			tool := &stubTool{name: "notes"}
+			opts := []Option{}
+			if tt.fallback {
+				opts = append(opts, WithReActFallback())
+			}
+			a := New(model, []tools.Tool{tool}, opts...)
+			got, err := a.Run(context.Background(), []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "q")}, Handler{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			if model.calls != tt.wantCalls {
+				t.Errorf("model answered %d times, want %d", model.calls, tt.wantCalls)
+			}
+			if tt.wantErr {
+				if a.Mode() != ModeNative {
+					t.Errorf("mode = %s, want it left native", a.Mode())
+				}
+				return
+			}
+			if got != "Kafka is a log" || !slices.Equal(tool.inputs, []string{"kafka"}) {
+				t.Errorf("answer = %q after calling the tool with %q, want the ReAct answer", got, tool.inputs)
+			}
+			// Later runs don't try tools again
+			offered := len(model.opts)
+			if _, err := a.Run(context.Background(), nil, Handler{}); err != nil || a.Mode() != ModeReAct {
+				t.Errorf("second run: mode %s, err %v; want ReAct", a.Mode(), err)
+			}
+			if len(model.opts) != offered+1 || len(model.opts[offered].Tools) != 0 {
+				t.Errorf("second run offered tools again")
+			}
+		})
+	}
+}
+
+func TestIsToolCallChunk(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  bool
+	}{
+		{`[{"function":{"name":"notes","arguments":"{}"}}]`, true},
+		{`[{"id":"1","type":"function","function":{"name":"notes"}}]`, true},
+		{`[{"name":"notes"}]`, false},
+		{`[]`, false},
+		{`[{not json`, false},
+		{`[1, 2]`, false},
+		{`{"function":{}}`, false},
+		{`Hello`, false},
+		{`[`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := isToolCallChunk([]byte(tt.chunk)); got != tt.want {
+			t.Errorf("isToolCallChunk(%q) = %v, want %v", tt.chunk, got, tt.want)
+		}
+	}
+}
+
+func TestRunMaxIterations(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		responses int
+		wantErr   error
+	}{
+		{"answers on the last step", 3, 3, nil},
+		{"still calling tools at the limit", 2, 3, ErrMaxIterations},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &stubModel{}
+			for i := 0; i < tt.responses-1; i++ {
+				model.responses = append(model.responses, stubResponse{calls: []llms.ToolCall{toolCall("1", "notes", `{"input":"x"}`)}})
+			}
+			model.responses = append(model.responses, stubResponse{content: "done"})
+			_, err := New(model, []tools.Tool{&stubTool{name: "notes"}}, WithMaxIterations(tt.limit)).Run(context.Background(), nil, Handler{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if model.calls != min(tt.limit, tt.responses) {
+				t.Errorf("model called %d times, want %d", model.calls, min(tt.limit, tt.responses))
+			}
+		})
+	}
+}
+
+func TestToolTimeout(t *testing.T) {
+	slow := &stubTool{name: "notes", fn: func(ctx context.Context, input string) (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(5 * time.Second):
+			return "too late", nil
+		}
+	}}
+	model := &stubModel{responses: []stubResponse{
+		{calls: []llms.ToolCall{toolCall("1", "notes", `{"input":"x"}`)}},
+		{content: "sorry"},
+	}}
+	rec := &recorder{}
+	start := time.Now()
+	got, err := New(model, []tools.Tool{slow}, WithToolTimeout(20*time.Millisecond)).Run(context.Background(), nil, rec.handler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("run took %s, want the tool cut off", elapsed)
+	}
+	// The timeout is reported to the model, which still gets to answer
+	if res := toolResults(rec.messages); len(res) != 1 || res[0] != "Error: "+context.DeadlineExceeded.Error() {
+		t.Errorf("tool results = %q, want the deadline error", res)
+	}
+	if got != "sorry" {
+		t.Errorf("answer = %q, want sorry", got)
+	}
+}
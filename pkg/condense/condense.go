@@ -0,0 +1,172 @@
+// Package condense shortens conversations for the model.  It rewrites a message into a standalone search query using
+// the conversation it belongs to, so that follow-ups like "what about the second one?" find the notes they're about,
+// and summarizes older turns that no longer fit the context window.
+package condense
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Messages of the conversation are cut to this length (in runes) in the prompt.
+const maxMessageLength = 1000
+
+const condensePrompt = `You turn the user's latest message into search queries for their personal notes.
+
+Conversation so far:
+%s
+Latest message: %s
+
+Rewrite the latest message as a single standalone search query, resolving references like "it", "that" or "the
+second one" using the conversation. Keep names, identifiers, numbers and dates exactly as written. If the message is
+already standalone, repeat it.%s
+
+Reply in exactly this format and nothing else:
+QUERY: <standalone query>%s`
+
+const subQueriesPrompt = `
+
+Then write up to %d more search queries, each looking for a different piece of information needed to answer it.`
+
+// Result holds the queries to search the notes with.
+type Result struct {
+	// Query is the standalone version of the message
+	Query string
+	// SubQueries look for different parts of the answer; their results are meant to be fused with Query's
+	SubQueries []string
+}
+
+// Condenser rewrites messages using a conversation model.
+type Condenser struct {
+	llm         llms.Model
+	subQueries  int
+	maxMessages int
+}
+
+type Option func(*Condenser)
+
+// WithSubQueries asks for up to n extra queries per message; defaults to 0.
+func WithSubQueries(n int) Option {
+	return func(c *Condenser) {
+		c.subQueries = max(n, 0)
+	}
+}
+
+// WithMaxMessages sets how many of the most recent messages of the conversation are considered; defaults to 6.
+func WithMaxMessages(n int) Option {
+	return func(c *Condenser) {
+		c.maxMessages = max(n, 1)
+	}
+}
+
+// New returns a condenser that prompts the given model.
+func New(llm llms.Model, opts ...Option) *Condenser {
+	c := &Condenser{
+		llm:         llm,
+		maxMessages: 6,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Condense rewrites the message given the conversation before it.  Only the user's messages and the answers count;
+// system prompts, retrieved contexts and tool calls are left out.  The first message of a conversation is already
+// standalone, so it's returned as is unless sub-queries are wanted.
+func (c *Condenser) Condense(ctx context.Context, history []llms.MessageContent, message string) (Result, error) {
+	var turns []string
+	for _, m := range history {
+		var role string
+		switch m.Role {
+		case llms.ChatMessageTypeHuman:
+			role = "User"
+		case llms.ChatMessageTypeAI:
+			role = "Assistant"
+		default:
+			continue
+		}
+		text := strings.TrimSpace(messageText(m))
+		if text == "" {
+			// e.g. a tool call
+			continue
+		}
+		turns = append(turns, fmt.Sprintf("%s: %s", role, truncate(text, maxMessageLength)))
+	}
+	if len(turns) == 0 && c.subQueries == 0 {
+		return Result{Query: message}, nil
+	}
+	turns = turns[max(len(turns)-c.maxMessages, 0):]
+
+	var conversation strings.Builder
+	for _, t := range turns {
+		conversation.WriteString(t)
+		conversation.WriteString("\n")
+	}
+	if len(turns) == 0 {
+		conversation.WriteString("(none)\n")
+	}
+	var subQueries, subQueriesFormat string
+	if c.subQueries > 0 {
+		subQueries = fmt.Sprintf(subQueriesPrompt, c.subQueries)
+		subQueriesFormat = "\nSUBQUERY: <query>"
+	}
+	prompt := fmt.Sprintf(condensePrompt, conversation.String(), message, subQueries, subQueriesFormat)
+	reply, err := llms.GenerateFromSinglePrompt(ctx, c.llm, prompt, llms.WithTemperature(0))
+	if err != nil {
+		return Result{}, err
+	}
+	return c.parse(reply, message), nil
+}
+
+// parse reads the queries out of the model's reply.  A reply that doesn't follow the format is taken as the query
+// itself; an empty one leaves the message as it was.
+func (c *Condenser) parse(reply, message string) Result {
+	var res Result
+	var other []string
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(line)
+		key, value, ok := strings.Cut(line, ":")
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch {
+		case ok && strings.EqualFold(key, "QUERY") && res.Query == "":
+			res.Query = value
+		case ok && strings.EqualFold(key, "SUBQUERY"):
+			if value != "" && len(res.SubQueries) < c.subQueries {
+				res.SubQueries = append(res.SubQueries, value)
+			}
+		case line != "":
+			other = append(other, line)
+		}
+	}
+	if res.Query == "" && len(other) > 0 {
+		res.Query = other[0]
+	}
+	if res.Query == "" {
+		res.Query = message
+	}
+	return res
+}
+
+// messageText joins the text parts of a message.
+func messageText(m llms.MessageContent) string {
+	var sb strings.Builder
+	for _, part := range m.Parts {
+		if p, ok := part.(llms.TextContent); ok {
+			sb.WriteString(p.Text)
+		}
+	}
+	return sb.String()
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
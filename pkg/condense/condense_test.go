@@ -0,0 +1,167 @@
+package condense
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// stubModel replies to every prompt with reply, recording the prompts it was sent.
+type stubModel struct {
+	reply   string
+	err     error
+	prompts []string
+}
+
+func (m *stubModel) GenerateContent(ctx context.Context, msgs []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	m.prompts = append(m.prompts, messageText(msgs[len(msgs)-1]))
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: m.reply}}}, nil
+}
+
+func (m *stubModel) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, opts...)
+}
+
+// conversation returns alternating user and assistant messages with the given texts, after a system prompt.
+func conversation(texts ...string) []llms.MessageContent {
+	msgs := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeSystem, "You are helpful.")}
+	for i, t := range texts {
+		role := llms.ChatMessageTypeHuman
+		if i%2 == 1 {
+			role = llms.ChatMessageTypeAI
+		}
+		msgs = append(msgs, llms.TextParts(role, t))
+	}
+	return msgs
+}
+
+func TestCondenseParsesReply(t *testing.T) {
+	tests := []struct {
+		name       string
+		subQueries int
+		reply      string
+		want       Result
+	}{
+		{
+			name:  "query",
+			reply: "QUERY: release date of project apollo",
+			want:  Result{Query: "release date of project apollo"},
+		},
+		{
+			name:  "keys are case insensitive and quotes are dropped",
+			reply: `query: "release date of project apollo"`,
+			want:  Result{Query: "release date of project apollo"},
+		},
+		{
+			name:  "only the first query counts",
+			reply: "QUERY: first\nQUERY: second",
+			want:  Result{Query: "first"},
+		},
+		{
+			name:       "sub-queries",
+			subQueries: 2,
+			reply:      "QUERY: apollo release\nSUBQUERY: apollo release date\n\nSUBQUERY: apollo release notes",
+			want:       Result{Query: "apollo release", SubQueries: []string{"apollo release date", "apollo release notes"}},
+		},
+		{
+			name:       "sub-queries beyond the limit and empty ones are dropped",
+			subQueries: 1,
+			reply:      "QUERY: apollo release\nSUBQUERY:\nSUBQUERY: one\nSUBQUERY: two",
+			want:       Result{Query: "apollo release", SubQueries: []string{"one"}},
+		},
+		{
+			name:  "sub-queries aren't kept unless asked for",
+			reply: "QUERY: apollo release\nSUBQUERY: apollo release date",
+			want:  Result{Query: "apollo release"},
+		},
+		{
+			name:  "a reply out of format is the query",
+			reply: "\nSure! apollo release date\n",
+			want:  Result{Query: "Sure! apollo release date"},
+		},
+		{
+			name:  "an empty reply keeps the message",
+			reply: "  \n",
+			want:  Result{Query: "when is it out?"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &stubModel{reply: tt.reply}
+			c := New(m, WithSubQueries(tt.subQueries))
+			got, err := c.Condense(context.Background(), conversation("tell me about apollo", "It's a project."), "when is it out?")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Query != tt.want.Query || !slices.Equal(got.SubQueries, tt.want.SubQueries) {
+				t.Errorf("Condense = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCondenseFirstMessage(t *testing.T) {
+	// Nothing the user or assistant said yet; the system prompt and tool calls don't count
+	history := conversation()
+	history = append(history, llms.MessageContent{
+		Role:  llms.ChatMessageTypeAI,
+		Parts: []llms.ContentPart{llms.ToolCall{ID: "1", FunctionCall: &llms.FunctionCall{Name: "search_notes"}}},
+	})
+
+	m := &stubModel{reply: "QUERY: rewritten"}
+	got, err := New(m).Condense(context.Background(), history, "what is apollo?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Query != "what is apollo?" || len(m.prompts) != 0 {
+		t.Errorf("Condense = %+v after %d prompts, want the message as is without asking the model", got, len(m.prompts))
+	}
+
+	// Sub-queries still need the model
+	got, err = New(m, WithSubQueries(1)).Condense(context.Background(), history, "what is apollo?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Query != "rewritten" || len(m.prompts) != 1 {
+		t.Errorf("Condense = %+v after %d prompts, want the model's query", got, len(m.prompts))
+	}
+	if !strings.Contains(m.prompts[0], "Conversation so far:\n(none)\n") {
+		t.Errorf("prompt = %q, want an empty conversation", m.prompts[0])
+	}
+}
+
+func TestCondenseMaxMessages(t *testing.T) {
+	m := &stubModel{reply: "QUERY: q"}
+	history := conversation("one", "two", "three", "four", "five")
+	_, err := New(m, WithMaxMessages(2)).Condense(context.Background(), history, "six")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.prompts) != 1 {
+		t.Fatalf("prompted %d times, want once", len(m.prompts))
+	}
+	prompt := m.prompts[0]
+	if !strings.Contains(prompt, "Conversation so far:\nAssistant: four\nUser: five\n\nLatest message: six") {
+		t.Errorf("prompt = %q, want only the last two messages", prompt)
+	}
+	for _, old := range []string{"User: one", "Assistant: two", "User: three", "You are helpful."} {
+		if strings.Contains(prompt, old) {
+			t.Errorf("prompt contains %q, want it left out", old)
+		}
+	}
+}
+
+func TestCondenseError(t *testing.T) {
+	m := &stubModel{err: errors.New("model went away")}
+	_, err := New(m).Condense(context.Background(), conversation("hi", "hello"), "and?")
+	if err == nil || err.Error() != "model went away" {
+		t.Errorf("err = %v, want the model's error", err)
+	}
+}
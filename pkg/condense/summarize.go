@@ -0,0 +1,62 @@
+package condense
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const summarizePrompt = `Summarize the conversation below between a user and an assistant that answers questions about the
+user's notes. The summary replaces the conversation for the rest of the chat, so keep every fact, decision, name, number
+and date that later questions could refer to. Write it as short bullet points, without any introduction.
+%s
+Conversation:
+%s`
+
+const previousSummaryPrompt = `
+Summary of what came before it, to be merged into yours:
+%s
+`
+
+// Summarizer condenses the older turns of a conversation that no longer fit the context window.
+type Summarizer struct {
+	llm llms.Model
+}
+
+// NewSummarizer returns a summarizer that prompts the given model.
+func NewSummarizer(llm llms.Model) *Summarizer {
+	return &Summarizer{llm: llm}
+}
+
+// Summarize summarizes the given messages, merging in the summary of the messages before them (if any).
+func (s *Summarizer) Summarize(ctx context.Context, previous string, messages []llms.MessageContent) (string, error) {
+	var conversation strings.Builder
+	for _, m := range messages {
+		var role string
+		switch m.Role {
+		case llms.ChatMessageTypeHuman:
+			role = "User"
+		case llms.ChatMessageTypeAI:
+			role = "Assistant"
+		default:
+			// Tool calls and their results only matter through the answers they led to
+			continue
+		}
+		text := strings.TrimSpace(messageText(m))
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(&conversation, "%s: %s\n", role, text)
+	}
+	var prev string
+	if previous != "" {
+		prev = fmt.Sprintf(previousSummaryPrompt, previous)
+	}
+	summary, err := llms.GenerateFromSinglePrompt(ctx, s.llm, fmt.Sprintf(summarizePrompt, prev, conversation.String()), llms.WithTemperature(0))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
+}
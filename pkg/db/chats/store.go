@@ -0,0 +1,170 @@
+package chats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/models"
+)
+
+// ErrNotFound is returned when a chat doesn't exist in the store.
+var ErrNotFound = errors.New("chat not found")
+
+// Summary describes a stored chat without its messages.
+type Summary struct {
+	ID        string
+	Title     string
+	Model     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Messages  int
+}
+
+// Store persists chats as one JSON file per chat in a directory.
+type Store struct {
+	dir        string
+	loggerFunc func(string)
+}
+
+// NewStore creates a store in dir, creating the directory if needed.
+func NewStore(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir: dir,
+		loggerFunc: func(msg string) {
+			log.Println(msg)
+		},
+	}, nil
+}
+
+// SetLogger sets where problems that don't stop the store from working, like unreadable chats, are reported.
+func (s *Store) SetLogger(loggerFunc func(string)) {
+	s.loggerFunc = loggerFunc
+}
+
+// Save writes the chat to disk, replacing any previous version.
+func (s *Store) Save(r models.ChatRecord) error {
+	if r.ID == "" {
+		return errors.New("chat ID is empty")
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temp file and rename so a crash never leaves a truncated chat behind
+	tmp, err := os.CreateTemp(s.dir, r.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(r.ID))
+}
+
+// Load reads a chat from disk.
+func (s *Store) Load(id string) (models.ChatRecord, error) {
+	var r models.ChatRecord
+	b, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return r, ErrNotFound
+	}
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return r, fmt.Errorf("failed to parse chat %s: %w", id, err)
+	}
+	return r, nil
+}
+
+// List returns summaries of all stored chats, most recently updated first.
+// Chats that can't be read are logged and left out.
+func (s *Store) List() ([]Summary, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]Summary, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		r, err := s.Load(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			s.log(fmt.Sprintf("err: skipping chat %s: %v", e.Name(), err))
+			continue
+		}
+		summaries = append(summaries, Summary{
+			ID:        r.ID,
+			Title:     r.Title,
+			Model:     r.Model,
+			CreatedAt: r.CreatedAt,
+			UpdatedAt: r.UpdatedAt,
+			Messages:  len(r.Messages),
+		})
+	}
+	slices.SortFunc(summaries, func(a, b Summary) int {
+		return b.UpdatedAt.Compare(a.UpdatedAt)
+	})
+	return summaries, nil
+}
+
+// Latest returns the most recently updated chat.
+func (s *Store) Latest() (models.ChatRecord, error) {
+	summaries, err := s.List()
+	if err != nil {
+		return models.ChatRecord{}, err
+	}
+	if len(summaries) == 0 {
+		return models.ChatRecord{}, ErrNotFound
+	}
+	return s.Load(summaries[0].ID)
+}
+
+// Rename changes the title of a stored chat.
+func (s *Store) Rename(id, title string) error {
+	r, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+	r.Title = title
+	return s.Save(r)
+}
+
+// Delete removes a chat from disk.
+func (s *Store) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *Store) log(msg string) {
+	if s.loggerFunc == nil {
+		return
+	}
+	s.loggerFunc(msg)
+}
+
+func (s *Store) path(id string) string {
+	// IDs are generated by us, but be defensive about what ends up in a path
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
@@ -0,0 +1,211 @@
+package chats
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/models"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := NewStore(filepath.Join(t.TempDir(), "chats"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLogger(func(string) {})
+	return s
+}
+
+func record(id string, updatedAt time.Time) models.ChatRecord {
+	return models.ChatRecord{
+		ID:        id,
+		Title:     "chat " + id,
+		Model:     "llama3",
+		CreatedAt: updatedAt.Add(-time.Hour),
+		UpdatedAt: updatedAt,
+		Messages: []llms.MessageContent{
+			llms.TextParts(llms.ChatMessageTypeHuman, "hello"),
+		},
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	want := record("a", now)
+	want.Messages = append(want.Messages,
+		llms.MessageContent{
+			Role: llms.ChatMessageTypeAI,
+			Parts: []llms.ContentPart{llms.ToolCall{
+				ID:           "call_1",
+				Type:         "function",
+				FunctionCall: &llms.FunctionCall{Name: "search_notes", Arguments: `{"query":"hello"}`},
+			}},
+		},
+		llms.MessageContent{
+			Role: llms.ChatMessageTypeTool,
+			Parts: []llms.ContentPart{llms.ToolCallResponse{
+				ToolCallID: "call_1",
+				Name:       "search_notes",
+				Content:    "no notes found",
+			}},
+		},
+		llms.TextParts(llms.ChatMessageTypeAI, "I couldn't find anything."),
+	)
+
+	if err := s.Save(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load = %+v, want %+v", got, want)
+	}
+}
+
+func TestSaveReplaces(t *testing.T) {
+	s := newTestStore(t)
+	r := record("a", time.Now())
+	if err := s.Save(r); err != nil {
+		t.Fatal(err)
+	}
+	r.Title = "second"
+	if err := s.Save(r); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "second" {
+		t.Errorf("Title = %q, want %q", got.Title, "second")
+	}
+	// The temp file written on the way must have been renamed into place
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"a.json"}) {
+		t.Errorf("files = %v, want [a.json]", names)
+	}
+}
+
+func TestSaveWithoutID(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Save(models.ChatRecord{}); err == nil {
+		t.Error("err = nil, want an error")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	s := newTestStore(t)
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"load", func() error { _, err := s.Load("missing"); return err }},
+		{"rename", func() error { return s.Rename("missing", "title") }},
+		{"delete", func() error { return s.Delete("missing") }},
+		{"latest", func() error { _, err := s.Latest(); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, ErrNotFound) {
+				t.Errorf("err = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newTestStore(t)
+	var logged []string
+	s.SetLogger(func(msg string) { logged = append(logged, msg) })
+	now := time.Now()
+	// Saved out of order so the sort is what puts them in place
+	for _, r := range []models.ChatRecord{
+		record("old", now.Add(-2*time.Hour)),
+		record("new", now),
+		record("middle", now.Add(-time.Hour)),
+	} {
+		if err := s.Save(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Neither of these should keep the rest of the history from being listed
+	if err := os.WriteFile(filepath.Join(s.dir, "corrupt.json"), []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "notes.txt"), []byte("hi"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, sum := range summaries {
+		ids = append(ids, sum.ID)
+		if sum.Messages != 1 {
+			t.Errorf("%s: Messages = %d, want 1", sum.ID, sum.Messages)
+		}
+	}
+	if want := []string{"new", "middle", "old"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("List = %v, want %v", ids, want)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], "corrupt.json") {
+		t.Errorf("logged = %v, want the corrupt chat reported", logged)
+	}
+
+	latest, err := s.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.ID != "new" {
+		t.Errorf("Latest = %s, want new", latest.ID)
+	}
+}
+
+func TestRenameDelete(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Save(record("a", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Rename("a", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Load("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Title != "renamed" {
+		t.Errorf("Title = %q, want %q", r.Title, "renamed")
+	}
+	if len(r.Messages) != 1 {
+		t.Errorf("Messages = %d, want 1", len(r.Messages))
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load after Delete: err = %v, want %v", err, ErrNotFound)
+	}
+}
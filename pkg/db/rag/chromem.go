@@ -3,54 +3,241 @@ package rag
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/clocklear/texttrove/pkg/document/markdown"
 	"github.com/clocklear/texttrove/pkg/fs"
 
 	"github.com/clocklear/chromem-go"
 	"github.com/fsnotify/fsnotify"
-	"github.com/tmc/langchaingo/schema"
 )
 
 type ChromemRag struct {
-	db         *chromem.DB
-	col        *chromem.Collection
-	prompts    ModelPrompts
-	w          *fs.Watcher
-	loggerFunc func(string)
+	db     *chromem.DB
+	dbPath string
+	// mu guards col, which is swapped out when the index is rebuilt
+	mu        sync.RWMutex
+	col       *chromem.Collection
+	info      indexInfo
+	policy    ModelChangePolicy
+	prompts   ModelPrompts
+	embed     chromem.EmbeddingFunc
+	embedder  BatchEmbedder
+	model     string
+	manifest  *manifest
+	lexical   *lexicalIndex
+	graph     *linkGraph
+	matter    *frontmatterIndex
+	retrieval Retrieval
+	// embedDepth is how deep embeds are resolved into the notes embedding them; zero leaves them alone
+	embedDepth int
+	chunking   markdown.Chunking
+	// reranker (optional) scores rerankCandidates retrieved fragments to pick the best ones
+	reranker         Reranker
+	rerankCandidates int
+	w                *fs.Watcher
+	loggerFunc       func(string)
+	progressFunc     func(Progress)
 }
 
-func NewChromemRag(dbPath string, prompts ModelPrompts, embedding chromem.EmbeddingFunc) (*ChromemRag, error) {
+type Option func(*ChromemRag)
+
+// WithEmbeddingModel records the name of the embedding model in the manifest, so files are re-indexed when it changes.
+func WithEmbeddingModel(name string) Option {
+	return func(r *ChromemRag) {
+		r.model = name
+	}
+}
+
+// WithBatchEmbedder sets how fragments are embedded during a sync.  By default, the embedding function is called once
+// per fragment.
+func WithBatchEmbedder(e BatchEmbedder) Option {
+	return func(r *ChromemRag) {
+		r.embedder = e
+	}
+}
+
+// WithModelChangePolicy sets what happens when the index was built with different embedding settings.  The default is
+// to rebuild it.
+func WithModelChangePolicy(policy ModelChangePolicy) Option {
+	return func(r *ChromemRag) {
+		r.policy = policy
+	}
+}
+
+// WithEmbedDepth sets how deep ![[embeds]] of other notes are resolved into the notes embedding them while indexing;
+// zero indexes embeds as they're written.  The default is markdown.DefaultEmbedDepth.
+func WithEmbedDepth(depth int) Option {
+	return func(r *ChromemRag) {
+		r.embedDepth = depth
+	}
+}
+
+// WithChunking sets how notes are split into fragments.  The default is markdown.DefaultChunking.  Notes split with
+// other settings are split again on the next sync; only the fragments that differ are embedded.
+func WithChunking(c markdown.Chunking) Option {
+	return func(r *ChromemRag) {
+		r.chunking = c
+	}
+}
+
+// BatchEmbedder embeds many texts at once, such as embedding.Pipeline.  Fragments are embedded in batches of
+// BatchSize during a sync, with up to Concurrency batches in flight, and become queryable batch by batch.
+type BatchEmbedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	BatchSize() int
+	Concurrency() int
+}
+
+// funcEmbedder is the BatchEmbedder used when none is given, calling the collection's embedding function once per text.
+type funcEmbedder chromem.EmbeddingFunc
+
+func (f funcEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, t := range texts {
+		v, err := f(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func (f funcEmbedder) BatchSize() int {
+	return 64
+}
+
+func (f funcEmbedder) Concurrency() int {
+	return runtime.NumCPU()
+}
+
+func NewChromemRag(dbPath string, prompts ModelPrompts, embedding chromem.EmbeddingFunc, opts ...Option) (*ChromemRag, error) {
 	db, err := chromem.NewPersistentDB(dbPath, true)
 	if err != nil {
 		return nil, err
 	}
-	col, err := db.GetOrCreateCollection("texttrove", nil, embedding)
+	m, err := loadManifest(dbPath)
 	if err != nil {
 		return nil, err
 	}
-	return &ChromemRag{
-		db:      db,
-		col:     col,
-		prompts: prompts,
+	lexical, _, err := loadLexicalIndex(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := loadLinkGraph(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	matter, err := loadFrontmatterIndex(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	r := &ChromemRag{
+		db:         db,
+		dbPath:     dbPath,
+		policy:     RebuildOnModelChange,
+		prompts:    prompts,
+		embed:      embedding,
+		embedder:   funcEmbedder(embedding),
+		manifest:   m,
+		lexical:    lexical,
+		graph:      graph,
+		matter:     matter,
+		retrieval:  DefaultRetrieval,
+		embedDepth: markdown.DefaultEmbedDepth,
+		chunking:   markdown.DefaultChunking,
 		loggerFunc: func(msg string) {
 			log.Println(msg)
 		},
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.info = indexInfo{Model: r.model, Prompts: prompts}
+	r.col, err = db.GetOrCreateCollection(collectionName, r.info.metadata(), embedding)
+	if err != nil {
+		return nil, err
+	}
+
+	// Vectors embedded with different settings can't be compared with new ones
+	err = r.checkModel()
+	if err != nil {
+		return nil, err
+	}
+
+	// The lexical index can lag behind the collection, e.g. in a DB that predates it
+	err = r.syncLexical(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// collection returns the collection holding the document fragments.
+func (r *ChromemRag) collection() *chromem.Collection {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.col
 }
 
 func (r *ChromemRag) SetLogger(loggerFunc func(string)) {
 	r.loggerFunc = loggerFunc
 }
 
+// SetProgressHandler registers a function that is called as SyncDocuments makes progress.
+func (r *ChromemRag) SetProgressHandler(progressFunc func(Progress)) {
+	r.progressFunc = progressFunc
+}
+
+// LoadDocuments performs a one-time sync of all documents under basePath matching filePattern
+// and then starts watching basePath so the DB is kept up to date as files change.
 func (r *ChromemRag) LoadDocuments(ctx context.Context, basePath, filePattern string) error {
+	err := r.SyncDocuments(ctx, basePath, filePattern)
+	if err != nil {
+		return err
+	}
+	return r.Watch(basePath, filePattern)
+}
+
+// SyncDocuments performs a one-time sync of all documents under basePath matching filePattern.
+// Unlike LoadDocuments, no watcher is started.
+func (r *ChromemRag) SyncDocuments(ctx context.Context, basePath, filePattern string) error {
+	// A model can change its vectors without changing its name
+	err := r.checkDimension(ctx)
+	if err != nil {
+		return err
+	}
+
+	matches, err := findDocuments(basePath, filePattern)
+	if err != nil {
+		return err
+	}
+
+	// Purge anything deleted or renamed since the last sync
+	report, err := r.reconcile(ctx, basePath, matches, false)
+	if err != nil {
+		return err
+	}
+	if report.Fragments > 0 {
+		r.Log(fmt.Sprintf("Reconciled index: purged %d fragment(s) of %d deleted or renamed file(s)", report.Fragments, len(report.Orphans)))
+	}
+
+	return r.reloadDocuments(ctx, basePath, matches, r.progressFunc)
+}
+
+// findDocuments returns the paths of all files under basePath matching filePattern.
+func findDocuments(basePath, filePattern string) ([]string, error) {
 	// Use the given basePath and filePattern to find matching files
 	var matches []string
 	err := filepath.WalkDir(basePath, func(path string, d os.DirEntry, err error) error {
@@ -68,16 +255,11 @@ func (r *ChromemRag) LoadDocuments(ctx context.Context, basePath, filePattern st
 		}
 		return nil
 	})
-	if err != nil {
-		return err
-	}
-
-	// Do a one-time sync load
-	err = r.reloadDocuments(ctx, basePath, matches)
-	if err != nil {
-		return err
-	}
+	return matches, err
+}
 
+// Watch starts a watcher on basePath that keeps the DB in sync with changes to files matching filePattern.
+func (r *ChromemRag) Watch(basePath, filePattern string) error {
 	// Start a watcher instance to keep items up to date
 	w, err := fs.NewWatcher(func(event fsnotify.Event) {
 		matched, err := filepath.Match(filePattern, filepath.Base(event.Name))
@@ -94,7 +276,7 @@ func (r *ChromemRag) LoadDocuments(ctx context.Context, basePath, filePattern st
 			// Same treatment as we'd give a write
 			fallthrough
 		case event.Op&fsnotify.Write == fsnotify.Write:
-			err = r.reloadDocuments(context.Background(), basePath, []string{event.Name})
+			err = r.reloadDocuments(context.Background(), basePath, []string{event.Name}, nil)
 			if err != nil {
 				r.Log(fmt.Sprintf("err: failed to reload docs: %v", err.Error()))
 			}
@@ -125,48 +307,185 @@ func (r *ChromemRag) Shutdown(ctx context.Context) error {
 }
 
 func (r *ChromemRag) removeDocs(ctx context.Context, basePath string, paths []string) error {
-	keys := r.col.ListIDs(ctx)
+	var byPath map[string][]string
 	for _, p := range paths {
 		relPath := p[len(basePath):]
-		docIds := findRelevantDocs(relPath, keys)
-		if len(docIds) > 0 {
-			err := r.col.Delete(ctx, nil, nil, docIds...)
-			if err != nil {
-				return err
+		e, ok := r.manifest.get(relPath)
+		docIds := e.Fragments
+		if !ok {
+			// Not in the manifest; the DB may predate it
+			if byPath == nil {
+				byPath = r.fragmentsByPath(ctx)
 			}
+			docIds = byPath[relPath]
+		}
+		err := r.deleteFragments(ctx, docIds)
+		if err != nil {
+			return err
 		}
+		r.manifest.remove(relPath)
+		r.graph.remove(relPath)
+		r.matter.remove(relPath)
 	}
-	return nil
+	err := r.save()
+	if err != nil {
+		return err
+	}
+	return r.reloadTranscluders(ctx, basePath, relPaths(basePath, paths))
 }
 
-func (r *ChromemRag) reloadDocuments(ctx context.Context, basePath string, paths []string) error {
-	// Pull a list of all keys in the DB
-	keys := r.col.ListIDs(ctx)
+// pendingFile is a file whose new fragments are waiting to be added to the DB.
+type pendingFile struct {
+	// entry is recorded in the manifest once the fragments are in the DB
+	entry manifestEntry
+	// validIds are the file's fragments that were already in the DB
+	validIds []string
+}
 
-	// For each file, parse and build collection
+// reloadDocuments (re)indexes the given files, reporting progress to the (optional) progress function as it goes.
+// Files whose size and modification time match the manifest are skipped without being parsed.  Files that can't be
+// loaded or embedded are logged, counted as failures and skipped; they're retried on the next sync.
+func (r *ChromemRag) reloadDocuments(ctx context.Context, basePath string, paths []string, progress func(Progress)) error {
+	// Batches are embedded in the background, so progress is guarded by mu
+	var mu sync.Mutex
+	p := Progress{FilesTotal: len(paths), Started: time.Now()}
+	update := func(f func(p *Progress)) {
+		mu.Lock()
+		defer mu.Unlock()
+		f(&p)
+		if progress != nil {
+			progress(p)
+		}
+	}
+	update(func(*Progress) {})
+	// Links to notes further down the list should resolve, too
+	r.graph.addFiles(relPaths(basePath, paths))
+	loadOpts := []markdown.LoadOption{markdown.WithChunking(r.chunking)}
+	if r.embedDepth > 0 {
+		loadOpts = append(loadOpts, markdown.WithEmbeds(r.graph, r.embedDepth))
+	}
+	// reindexed lists the files that changed, whose transcluders are checked once they're done
+	var reindexed []string
+
+	// byPath lists the fragments of files that are missing from the manifest, e.g. because the DB predates it.
+	// It takes a scan of every ID in the DB, so it's only built when needed.
+	var byPath map[string][]string
+
+	// Fragments are added to the DB in batches, with a bounded number in flight; pending tracks the files the
+	// current batch came from
 	var docs []chromem.Document
+	pending := make(map[string]pendingFile)
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, r.embedder.Concurrency())
+	flush := func() {
+		if len(docs) == 0 {
+			return
+		}
+		batch, files := docs, pending
+		docs, pending = nil, make(map[string]pendingFile)
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			err := r.addDocuments(ctx, batch)
+			if ctx.Err() != nil {
+				return
+			}
+			update(func(p *Progress) {
+				if err != nil {
+					r.Log(fmt.Sprintf("err: failed to embed %v: %v", strings.Join(slices.Sorted(maps.Keys(files)), ", "), err))
+					p.Failures += len(files)
+					for relPath, pf := range files {
+						// Only the fragments that were already there made it; leave the rest for the next sync
+						r.manifest.set(relPath, manifestEntry{Fragments: pf.validIds})
+					}
+					return
+				}
+				p.FragmentsEmbedded += len(batch)
+				for relPath, pf := range files {
+					r.manifest.set(relPath, pf.entry)
+				}
+			})
+			if err := r.manifest.save(); err != nil {
+				r.Log(fmt.Sprintf("err: failed to save manifest: %v", err))
+			}
+		}()
+	}
+
+	// For each file, parse and build collection
 	for _, match := range paths {
 		// Strip the basepath off the beginning of the match
 		relPath := match[len(basePath):]
 
+		entry, unchanged, err := r.checkManifest(match, relPath)
+		if err != nil {
+			r.Log(fmt.Sprintf("Failed to load document %s: %v", match, err))
+			update(func(p *Progress) {
+				p.FilesScanned++
+				p.Failures++
+			})
+			continue
+		}
+		if unchanged {
+			// Nothing to do, unless the DB predates the link graph or the frontmatter index
+			if !r.graph.has(relPath) || !r.matter.has(relPath) {
+				if note, err := markdown.LoadNote(ctx, basePath, relPath, loadOpts...); err == nil {
+					r.graph.set(relPath, note)
+					r.matter.set(relPath, note.Frontmatter)
+				}
+			}
+			update(func(p *Progress) { p.FilesScanned++ })
+			continue
+		}
+
 		// Split the markdown into doc fragments
-		doc, err := markdown.Load(ctx, basePath, relPath)
+		note, err := markdown.LoadNote(ctx, basePath, relPath, loadOpts...)
 		if err != nil {
 			r.Log(fmt.Sprintf("Failed to load document %s: %v", match, err))
+			update(func(p *Progress) {
+				p.FilesScanned++
+				p.Failures++
+			})
 			continue
 		}
 
-		// Find existing relevant doc fragment IDs
-		docIds := findRelevantDocs(relPath, keys)
+		// Find existing doc fragment IDs
+		old, ok := r.manifest.get(relPath)
+		existing := old.Fragments
+		if !ok {
+			if byPath == nil {
+				byPath = r.fragmentsByPath(ctx)
+			}
+			existing = byPath[relPath]
+		}
 		// validIds will be used to keep track of the docs that are still valid
 		validIds := make([]string, 0)
 
 		// Convert the schema.document(s) into chromem.document(s)
 		bLoaded := false
-		for _, d := range doc {
+		r.graph.set(relPath, note)
+		r.matter.set(relPath, note.Frontmatter)
+		reindexed = append(reindexed, relPath)
+		for _, src := range note.Transcluded {
+			if entry.Transcluded == nil {
+				entry.Transcluded = make(map[string]string)
+			}
+			// What was indexed of the embedded note, rather than the file, so changes to what it embeds count too
+			e, _ := r.manifest.get(src)
+			entry.Transcluded[src] = e.contentHash()
+		}
+		for _, d := range note.Fragments {
 			docId := relPath + "|" + sha256Hash(d.PageContent)
+			if slices.Contains(entry.Fragments, docId) {
+				// The same content appears more than once in the file
+				continue
+			}
+			entry.Fragments = append(entry.Fragments, docId)
 			// Is thing already in the DB?
-			exists := slices.Contains(docIds, docId)
+			exists := slices.Contains(existing, docId)
 			if exists {
 				// Valid doc
 				validIds = append(validIds, docId)
@@ -187,33 +506,182 @@ func (r *ChromemRag) reloadDocuments(ctx context.Context, basePath string, paths
 			docs = append(docs, chromem.Document{
 				Content:  r.prompts.EmbeddingPrefix + d.PageContent + docContextFooter(d.Metadata),
 				Metadata: md,
-				ID:       docId, // TODO: Figure out how to do ID in such a way that we can smartly update documents
+				ID:       docId,
 			})
 		}
 
 		// The difference of the two slices will give us the docIds that are no longer valid
 		// and should be removed
-		if len(docIds) > 0 && len(validIds) < len(docIds) {
+		if len(existing) > 0 && len(validIds) < len(existing) {
 			// Find the difference between the two slices
-			invalidIds := difference(docIds, validIds)
+			invalidIds := difference(existing, validIds)
 			r.Log(fmt.Sprintf("Removing %v document fragments from DB...", len(invalidIds)))
-			err := r.col.Delete(ctx, nil, nil, invalidIds...)
+			err := r.deleteFragments(ctx, invalidIds)
 			if err != nil {
+				wg.Wait()
 				return err
 			}
 		}
+
+		if bLoaded {
+			pending[relPath] = pendingFile{entry: entry, validIds: validIds}
+		} else {
+			r.manifest.set(relPath, entry)
+		}
+		update(func(p *Progress) { p.FilesScanned++ })
+		if len(docs) >= r.embedder.BatchSize() {
+			flush()
+		}
 	}
 
-	if len(docs) == 0 {
-		// nothing to do
+	// Add whatever is left to the DB
+	flush()
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	update(func(p *Progress) { p.Done = true })
+	err := r.save()
+	if err != nil {
+		return err
+	}
+	return r.reloadTranscluders(ctx, basePath, reindexed)
+}
+
+// reloadTranscluders reindexes the notes embedding any of the given (changed or removed) notes.  Only those whose
+// embedded content actually changed are reindexed; see transclusionsChanged.
+func (r *ChromemRag) reloadTranscluders(ctx context.Context, basePath string, relPaths []string) error {
+	if r.embedDepth <= 0 || len(relPaths) == 0 {
 		return nil
 	}
+	deps := r.graph.transcluders(relPaths)
+	if len(deps) == 0 {
+		return nil
+	}
+	paths := make([]string, len(deps))
+	for i, relPath := range deps {
+		paths[i] = basePath + relPath
+	}
+	return r.reloadDocuments(ctx, basePath, paths, nil)
+}
 
-	// Add the raw collection to the DB
+// transclusionsChanged reports whether what was indexed of any of the notes embedded into a note changed since it was
+// indexed, or whether its embeds now resolve to notes that weren't embedded then (e.g. because they've since been
+// created).  What's indexed of a note only depends on the files, so this settles even when notes embed each other.
+func (r *ChromemRag) transclusionsChanged(relPath string, e manifestEntry) bool {
+	if r.embedDepth <= 0 {
+		return false
+	}
+	for src, hash := range e.Transcluded {
+		if cur, _ := r.manifest.get(src); cur.contentHash() != hash {
+			return true
+		}
+	}
+	for _, src := range r.graph.resolve().embeds[relPath] {
+		if _, ok := e.Transcluded[src]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// relPaths strips basePath off the given paths.
+func relPaths(basePath string, paths []string) []string {
+	res := make([]string, len(paths))
+	for i, p := range paths {
+		res[i] = p[len(basePath):]
+	}
+	return res
+}
+
+// addDocuments embeds the given fragments and adds them to the DB.
+func (r *ChromemRag) addDocuments(ctx context.Context, docs []chromem.Document) error {
 	r.Log(fmt.Sprintf("Adding %v document fragments to DB...", len(docs)))
-	err := r.col.AddDocuments(ctx, docs, runtime.NumCPU())
+	texts := make([]string, len(docs))
+	for i, d := range docs {
+		texts[i] = d.Content
+	}
+	vectors, err := r.embedder.Embed(ctx, texts)
+	if err != nil {
+		return err
+	}
+	for i := range docs {
+		docs[i].Embedding = vectors[i]
+	}
+	err = r.collection().AddDocuments(ctx, docs, 1)
+	if err != nil {
+		return err
+	}
+	for _, d := range docs {
+		r.lexical.add(d.ID, r.lexicalText(d.Content))
+	}
+	return nil
+}
 
-	return err
+// deleteFragments removes the given fragments from the DB.
+func (r *ChromemRag) deleteFragments(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := r.collection().Delete(ctx, nil, nil, ids...)
+	if err != nil {
+		return err
+	}
+	r.lexical.remove(ids...)
+	return nil
+}
+
+// save writes the manifest, the lexical index, the link graph and the frontmatter index to disk.
+func (r *ChromemRag) save() error {
+	err := r.manifest.save()
+	if err != nil {
+		return err
+	}
+	err = r.lexical.save()
+	if err != nil {
+		return err
+	}
+	err = r.graph.save()
+	if err != nil {
+		return err
+	}
+	return r.matter.save()
+}
+
+// checkManifest compares a file against its manifest entry, reporting whether it's unchanged.  If it isn't, a fresh
+// entry (without fragments) describing the file is returned.  A file that was merely touched gets its entry updated in
+// place and is reported as unchanged.
+func (r *ChromemRag) checkManifest(path, relPath string) (entry manifestEntry, unchanged bool, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return manifestEntry{}, false, err
+	}
+	old, ok := r.manifest.get(relPath)
+	if ok && old.unchanged(fi, r.model, r.chunking.String()) && !r.transclusionsChanged(relPath, old) {
+		return manifestEntry{}, true, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return manifestEntry{}, false, err
+	}
+	entry = manifestEntry{Hash: sha256Hash(string(b)), ModTime: fi.ModTime(), Size: fi.Size(), Model: r.model, Chunking: r.chunking.String()}
+	if ok && old.Hash == entry.Hash && old.Model == entry.Model && old.chunking() == entry.Chunking && !r.transclusionsChanged(relPath, old) {
+		// Touched, but the contents are the same
+		old.ModTime, old.Size = entry.ModTime, entry.Size
+		r.manifest.set(relPath, old)
+		return manifestEntry{}, true, nil
+	}
+	return entry, false, nil
+}
+
+// fragmentsByPath groups every fragment ID in the DB by the relative path of its file.
+func (r *ChromemRag) fragmentsByPath(ctx context.Context) map[string][]string {
+	byPath := make(map[string][]string)
+	for _, id := range r.collection().ListIDs(ctx) {
+		relPath := docPath(id)
+		byPath[relPath] = append(byPath[relPath], id)
+	}
+	return byPath
 }
 
 func difference(sliceA, sliceB []string) []string {
@@ -234,59 +702,38 @@ func difference(sliceA, sliceB []string) []string {
 	return diff
 }
 
-// findRelevantDocs returns a slice of applicable doc keys
-// from the given list of all known docKeys.  It is expected that the doc keys
-// follow the pattern of "relPath|hash" where relPath is the relative path to the
-// document and hash is the sha256 hash of the document content.
-func findRelevantDocs(relPath string, docKeys []string) []string {
-	matches := make([]string, 0)
-	for _, k := range docKeys {
-		if strings.HasPrefix(k, relPath) {
-			// Split the prefix off the key
-			matches = append(matches, k)
-		}
-	}
-	return matches
-}
+// docContextSeparator introduces the metadata footer of a fragment.
+const docContextSeparator = "\n---\nDocument metadata:\n"
 
 func docContextFooter(metadata map[string]any) string {
 	sb := strings.Builder{}
-	sb.WriteString("\n---\nDocument metadata:\n")
+	sb.WriteString(docContextSeparator)
 	for k, v := range metadata {
-		sb.WriteString(fmt.Sprintf("%s: %v\n", k, v))
+		sb.WriteString(fmt.Sprintf("%s: %s\n", k, metadataValue(v)))
 	}
 	return sb.String()
 }
 
-func (r *ChromemRag) Query(ctx context.Context, queryText string, nResults int, where, whereDocument map[string]any) ([]schema.Document, error) {
-	// Convert the metadata maps
-	whereString := stringifyMetadata(where)
-	whereDocumentString := stringifyMetadata(whereDocument)
-	res, err := r.col.Query(ctx, r.prompts.QueryPrefix+queryText, nResults, whereString, whereDocumentString)
-	if err != nil {
-		return nil, err
-	}
+// FragmentText returns the content of a retrieved fragment without the metadata footer that was added to it for the
+// embedding model.
+func FragmentText(content string) string {
+	text, _, _ := strings.Cut(content, docContextSeparator)
+	return text
+}
 
-	// Convert the chromem.Document(s) into schema.Document(s)
-	var docs []schema.Document
-	for _, d := range res {
-		// Convert metadata into a map[string]any
-		metadata := make(map[string]any)
-		for k, v := range d.Metadata {
-			metadata[k] = v
-		}
-		docs = append(docs, schema.Document{
-			PageContent: d.Content,
-			Metadata:    metadata,
-			Score:       d.Similarity,
-		})
-	}
+// Embed creates an embedding for the given text using the same embedding function as the DB.
+// No prompt prefix is applied.
+func (r *ChromemRag) Embed(ctx context.Context, text string) ([]float32, error) {
+	return r.embed(ctx, text)
+}
 
-	return docs, nil
+// EmbedBatch embeds many texts at once with the batch embedder used for indexing (see WithBatchEmbedder).
+func (r *ChromemRag) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	return r.embedder.Embed(ctx, texts)
 }
 
 func (r *ChromemRag) docExistsInDB(ctx context.Context, id string) (bool, error) {
-	_, err := r.col.GetByID(ctx, id)
+	_, err := r.collection().GetByID(ctx, id)
 	return err == nil, nil
 }
 
@@ -300,11 +747,30 @@ func (r *ChromemRag) Log(msg string) {
 func stringifyMetadata(m map[string]any) map[string]string {
 	sm := make(map[string]string)
 	for k, v := range m {
-		sm[k] = fmt.Sprintf("%v", v)
+		sm[k] = metadataValue(v)
 	}
 	return sm
 }
 
+// metadataValue formats a metadata value as a string.  Lists (e.g. frontmatter tags) are comma-separated, like the
+// link metadata, rather than printed the way Go prints slices; the frontmatter index keeps them as lists.
+func metadataValue(v any) string {
+	switch v := jsonValue(v).(type) {
+	case []any:
+		items := make([]string, len(v))
+		for i, e := range v {
+			items[i] = metadataValue(e)
+		}
+		return strings.Join(items, ", ")
+	case map[string]any:
+		b, err := json.Marshal(v)
+		if err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
+
 func sha256Hash(input string) string {
 	hash := sha256.New()
 	hash.Write([]byte(input))
@@ -0,0 +1,356 @@
+package rag
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/document/markdown"
+	"github.com/tj/go-naturaldate"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// DateMode decides what a date range mentioned in a query (see ParseDates) does to retrieval.
+type DateMode string
+
+const (
+	// DateFilter only retrieves fragments of notes dated within the range
+	DateFilter DateMode = "filter"
+	// DateBoost ranks fragments of notes dated within the range higher; see Retrieval.DateBoost
+	DateBoost DateMode = "boost"
+	// DateIgnore leaves retrieval as it is
+	DateIgnore DateMode = "off"
+)
+
+// Valid reports whether m is a known date mode.
+func (m DateMode) Valid() bool {
+	return m == DateFilter || m == DateBoost || m == DateIgnore
+}
+
+// DateRange is the span of time an expression in a query refers to, from From (inclusive) to To (exclusive).
+type DateRange struct {
+	From, To time.Time
+	// Phrase is the expression, as written in the query
+	Phrase string
+}
+
+// Contains reports whether t is within the range.
+func (d DateRange) Contains(t time.Time) bool {
+	return !t.Before(d.From) && t.Before(d.To)
+}
+
+// String returns the dates the range spans, e.g. 2024-03-04 to 2024-03-10.
+func (d DateRange) String() string {
+	last := d.To.AddDate(0, 0, -1)
+	if !last.After(d.From) {
+		return d.From.Format(time.DateOnly)
+	}
+	return d.From.Format(time.DateOnly) + " to " + last.Format(time.DateOnly)
+}
+
+// dateUnit is the granularity of a date expression.
+type dateUnit int
+
+const (
+	// namedUnit stands for the unit named in the expression, e.g. week in "2 weeks ago"
+	namedUnit dateUnit = iota
+	dayUnit
+	weekUnit
+	monthUnit
+	yearUnit
+)
+
+// start returns the beginning of the day, week (starting on Monday), month or year t is in.
+func (u dateUnit) start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch u {
+	case weekUnit:
+		d -= (int(t.Weekday()) + 6) % 7
+	case monthUnit:
+		d = 1
+	case yearUnit:
+		m, d = time.January, 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// next returns the beginning of the day, week, month or year after the one starting at t.
+func (u dateUnit) next(t time.Time) time.Time {
+	switch u {
+	case weekUnit:
+		return t.AddDate(0, 0, 7)
+	case monthUnit:
+		return t.AddDate(0, 1, 0)
+	case yearUnit:
+		return t.AddDate(1, 0, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+const (
+	numberWords = `\d+|an?|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve`
+	weekdays    = `monday|tuesday|wednesday|thursday|friday|saturday|sunday`
+	monthNames  = `january|february|march|april|may|june|july|august|september|october|november|december`
+)
+
+// dateRule recognizes one kind of date expression.
+type dateRule struct {
+	pattern *regexp.Regexp
+	unit    dateUnit
+	// rolling expressions ("the past 3 days") span from the date they name until today
+	rolling bool
+	// current expressions ("this week") span the unit today is in
+	current bool
+	// anchor returns a date within the span the expression refers to, given its submatches; nil leaves it to
+	// go-naturaldate
+	anchor func(m []string, now time.Time) (time.Time, bool)
+}
+
+// newDateRule compiles a date expression, along with the preposition that may precede it; with prep set, one must.
+// The expression is the second submatch, since or after (if either precedes it) the first.
+func newDateRule(expr string, prep bool) dateRule {
+	preps := `(?:(since|after)\s+|(?:in|on|during|from|over|for)\s+(?:the\s+)?)`
+	if !prep {
+		preps += `?(?:the\s+)?`
+	}
+	return dateRule{pattern: regexp.MustCompile(`(?i)\b` + preps + `(` + expr + `)(?:'s)?\b`)}
+}
+
+// dateRules are tried in order; the first to match wins.
+var dateRules = []dateRule{
+	func() dateRule {
+		r := newDateRule(`\d{4}-\d{2}-\d{2}`, false)
+		r.unit = dayUnit
+		r.anchor = func(m []string, _ time.Time) (time.Time, bool) { return markdown.ParseDate(m[2]) }
+		return r
+	}(),
+	func() dateRule {
+		r := newDateRule(`(`+monthNames+`)\s+(\d{1,2})(?:st|nd|rd|th)?(?:,?\s+(\d{4}))?`, false)
+		r.unit = dayUnit
+		r.anchor = func(m []string, now time.Time) (time.Time, bool) { return monthDate(m[3], m[4], m[5], now) }
+		return r
+	}(),
+	func() dateRule {
+		r := newDateRule(`(`+monthNames+`),?\s+(\d{4})`, false)
+		r.unit = monthUnit
+		r.anchor = func(m []string, now time.Time) (time.Time, bool) { return monthDate(m[3], "", m[4], now) }
+		return r
+	}(),
+	func() dateRule {
+		r := newDateRule(`(?:past|last|previous)\s+(?:`+numberWords+`)\s+(?:day|week|month|year)s?`, false)
+		r.rolling = true
+		return r
+	}(),
+	func() dateRule {
+		r := newDateRule(`past\s+(?:day|week|month|year)`, false)
+		r.rolling = true
+		return r
+	}(),
+	newDateRule(`(?:last|previous)\s+(?:day|week|month|year)`, false),
+	func() dateRule {
+		r := newDateRule(`this\s+(?:week|month|year)`, false)
+		r.current = true
+		return r
+	}(),
+	newDateRule(`(?:`+numberWords+`)\s+(?:day|week|month|year)s?\s+ago`, false),
+	func() dateRule {
+		r := newDateRule(`today|yesterday`, false)
+		r.unit = dayUnit
+		return r
+	}(),
+	func() dateRule {
+		r := newDateRule(`(?:(?:last|this)\s+)?(?:`+weekdays+`)`, false)
+		r.unit = dayUnit
+		return r
+	}(),
+	func() dateRule {
+		// A bare month name needs a preposition or "last", since some (may, march) are words, too
+		r := newDateRule(`(?:last\s+)?(`+monthNames+`)`, true)
+		r.unit = monthUnit
+		r.anchor = func(m []string, now time.Time) (time.Time, bool) { return monthDate(m[3], "", "", now) }
+		return r
+	}(),
+	func() dateRule {
+		r := newDateRule(`last\s+(`+monthNames+`)`, false)
+		r.unit = monthUnit
+		r.anchor = func(m []string, now time.Time) (time.Time, bool) { return monthDate(m[3], "", "", now) }
+		return r
+	}(),
+	func() dateRule {
+		r := newDateRule(`(?:19|20)\d{2}`, true)
+		r.unit = yearUnit
+		r.anchor = func(m []string, now time.Time) (time.Time, bool) {
+			y, _ := strconv.Atoi(m[2])
+			return time.Date(y, time.January, 1, 0, 0, 0, 0, now.Location()), true
+		}
+		return r
+	}(),
+}
+
+var (
+	// unitNamePattern finds the unit named in an expression
+	unitNamePattern = regexp.MustCompile(`(?i)\b(day|week|month|year)s?\b`)
+	// articlePattern matches "a" or "an" as a number, as in "a week ago"
+	articlePattern = regexp.MustCompile(`(^|\s)an?\s`)
+	// spaceBeforePunctPattern matches the space an expression leaves before punctuation, as in "what happened ?"
+	spaceBeforePunctPattern = regexp.MustCompile(`\s+([?.!,;:])`)
+)
+
+// ParseDates finds an expression of time in a query, such as "yesterday", "last week", "3 days ago", "on friday",
+// "in march", "march 3rd", "in 2024" or "since last month", returning the query without it and the range of dates it
+// refers to, relative to now.  Expressions of a day, week, month or year span all of it (last week is Monday to
+// Sunday); those of a stretch of time until now ("the past 3 days", or anything after since) span until the end of
+// today.  Without an expression, the range is nil and the query is returned as it is.
+func ParseDates(query string, now time.Time) (string, *DateRange) {
+	for _, rule := range dateRules {
+		loc := rule.pattern.FindStringSubmatchIndex(query)
+		if loc == nil {
+			continue
+		}
+		m := make([]string, len(loc)/2)
+		for i := range m {
+			if loc[2*i] >= 0 {
+				m[i] = query[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		expr := strings.ToLower(m[2])
+		anchor, ok := time.Time{}, false
+		if rule.anchor != nil {
+			anchor, ok = rule.anchor(m, now)
+		} else {
+			// go-naturaldate doesn't take "a week" for one
+			phrase := articlePattern.ReplaceAllString(strings.Join(strings.Fields(expr), " "), "${1}one ")
+			t, err := naturaldate.Parse(phrase, now, naturaldate.WithDirection(naturaldate.Past))
+			anchor, ok = t, err == nil
+		}
+		if !ok {
+			continue
+		}
+		unit := rule.unit
+		if unit == namedUnit {
+			unit = namedDateUnit(expr)
+		}
+		var from, to time.Time
+		switch {
+		case rule.current:
+			from = unit.start(now)
+			to = unit.next(from)
+		case rule.rolling || m[1] != "":
+			from = dayUnit.start(anchor)
+			if !rule.rolling {
+				from = unit.start(anchor)
+			}
+			to = dayUnit.next(dayUnit.start(now))
+		default:
+			from = unit.start(anchor)
+			to = unit.next(from)
+		}
+		rest := strings.Join(strings.Fields(query[:loc[0]]+query[loc[1]:]), " ")
+		rest = spaceBeforePunctPattern.ReplaceAllString(rest, "$1")
+		return rest, &DateRange{From: from, To: to, Phrase: query[loc[0]:loc[1]]}
+	}
+	return query, nil
+}
+
+// namedDateUnit returns the unit named in an expression, e.g. weeks in "2 weeks ago", or days if there's none.
+func namedDateUnit(expr string) dateUnit {
+	m := unitNamePattern.FindStringSubmatch(expr)
+	if m == nil {
+		return dayUnit
+	}
+	switch strings.ToLower(m[1]) {
+	case "week":
+		return weekUnit
+	case "month":
+		return monthUnit
+	case "year":
+		return yearUnit
+	}
+	return dayUnit
+}
+
+// monthDate returns the date of the given month name, day (the first, if empty) and year.  Without a year, it's the
+// last such date up to now.
+func monthDate(month, day, year string, now time.Time) (time.Time, bool) {
+	i := slices.Index(strings.Split(monthNames, "|"), strings.ToLower(month))
+	if i < 0 {
+		return time.Time{}, false
+	}
+	d := 1
+	if day != "" {
+		d, _ = strconv.Atoi(day)
+		if d < 1 || d > 31 {
+			return time.Time{}, false
+		}
+	}
+	y := now.Year()
+	if year != "" {
+		y, _ = strconv.Atoi(year)
+	}
+	t := time.Date(y, time.Month(i+1), d, 0, 0, 0, 0, now.Location())
+	if t.Day() != d {
+		// Such as February 30th
+		return time.Time{}, false
+	}
+	if year == "" && t.After(now) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
+
+// weighDates rescales the scores of fragments by the dates of their notes, and sorts them by their new scores: in
+// boost mode, those dated within the range gain the DateBoost share of their score; with a recency half-life set
+// and no range, older notes lose up to RecencyWeight of it.
+func (r *ChromemRag) weighDates(docs []schema.Document, ret Retrieval, dates *DateRange, now time.Time) []schema.Document {
+	boost := dates != nil && ret.Dates == DateBoost && ret.DateBoost > 0
+	decay := dates == nil && ret.RecencyHalfLife > 0 && ret.RecencyWeight > 0
+	if !boost && !decay {
+		return docs
+	}
+	for i, d := range docs {
+		date, ok := r.noteDate(fmt.Sprint(d.Metadata["Source"]))
+		if !ok {
+			continue
+		}
+		if boost && dates.Contains(date) {
+			docs[i].Score = scaleScore(docs[i].Score, 1+ret.DateBoost)
+		}
+		if decay {
+			age := max(now.Sub(date), 0)
+			docs[i].Score = scaleScore(docs[i].Score, 1-ret.RecencyWeight+ret.RecencyWeight*math.Pow(0.5, float64(age)/float64(ret.RecencyHalfLife)))
+		}
+	}
+	slices.SortStableFunc(docs, func(a, b schema.Document) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return docs
+}
+
+// scaleScore scales a score by f, the other way around for negative scores (as rerankers may give), so a factor over
+// one always ranks a fragment higher.
+func scaleScore(score float32, f float64) float32 {
+	if score < 0 {
+		return float32(float64(score) / f)
+	}
+	return float32(float64(score) * f)
+}
+
+// withinDates returns a copy of the filter that also only lets notes dated within the range through.
+func withinDates(f *Filter, dates DateRange) *Filter {
+	var within Filter
+	if f != nil {
+		within = *f
+	}
+	if within.After.IsZero() || within.After.Before(dates.From) {
+		within.After = dates.From
+	}
+	if within.Before.IsZero() || within.Before.After(dates.To) {
+		within.Before = dates.To
+	}
+	return &within
+}
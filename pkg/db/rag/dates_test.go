@@ -0,0 +1,206 @@
+package rag
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestParseDates(t *testing.T) {
+	// A Thursday afternoon
+	now := time.Date(2025, time.June, 12, 15, 4, 0, 0, time.Local)
+	tests := []struct {
+		query     string
+		wantRest  string
+		wantDates string
+	}{
+		// Named days, weeks, months and years span all of them
+		{"what did I do last week", "what did I do", "2025-06-02 to 2025-06-08"},
+		{"standup notes in March 2025", "standup notes", "2025-03-01 to 2025-03-31"},
+		{"march, 2024 retro", "retro", "2024-03-01 to 2024-03-31"},
+		{"meeting on 2025-06-01?", "meeting?", "2025-06-01"},
+		{"yesterday's standup", "standup", "2025-06-11"},
+		{"what's planned today", "what's planned", "2025-06-12"},
+		{"this week", "", "2025-06-09 to 2025-06-15"},
+		{"this month's goals", "goals", "2025-06-01 to 2025-06-30"},
+		{"notes from last month", "notes", "2025-05-01 to 2025-05-31"},
+		{"what did I decide 2 weeks ago", "what did I decide", "2025-05-26 to 2025-06-01"},
+		{"a week ago", "", "2025-06-02 to 2025-06-08"},
+		{"the call on friday", "the call", "2025-06-06"},
+		{"march 3rd planning", "planning", "2025-03-03"},
+		{"budget in 2024", "budget", "2024-01-01 to 2024-12-31"},
+		// A month without a year is the last one up to now
+		{"trip in march", "trip", "2025-03-01 to 2025-03-31"},
+		{"party in december", "party", "2024-12-01 to 2024-12-31"},
+		{"last december", "", "2024-12-01 to 2024-12-31"},
+		// Stretches of time until now, and anything after since, run until the end of today
+		{"the past 3 days", "", "2025-06-09 to 2025-06-12"},
+		{"over the past week", "", "2025-06-05 to 2025-06-12"},
+		{"bugs since last month", "bugs", "2025-05-01 to 2025-06-12"},
+		{"changes after 2025-06-10", "changes", "2025-06-10 to 2025-06-12"},
+		// Words that only look like dates
+		{"may I ask about kafka", "may I ask about kafka", ""},
+		{"the 2024 roadmap", "the 2024 roadmap", ""},
+		{"february 30th", "february 30th", ""},
+		{"kafka retention", "kafka retention", ""},
+	}
+	for _, tt := range tests {
+		rest, dates := ParseDates(tt.query, now)
+		got := ""
+		if dates != nil {
+			got = dates.String()
+		}
+		if rest != tt.wantRest || got != tt.wantDates {
+			t.Errorf("ParseDates(%q) = %q, %q; want %q, %q", tt.query, rest, got, tt.wantRest, tt.wantDates)
+		}
+	}
+}
+
+func TestDateRangeContains(t *testing.T) {
+	d := DateRange{From: date("2025-03-01"), To: date("2025-04-01")}
+	for day, want := range map[string]bool{"2025-02-28": false, "2025-03-01": true, "2025-03-31": true, "2025-04-01": false} {
+		if got := d.Contains(date(day)); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", day, got, want)
+		}
+	}
+}
+
+func TestScaleScore(t *testing.T) {
+	tests := []struct {
+		score float32
+		f     float64
+		want  float32
+	}{
+		{0.5, 1.5, 0.75},
+		{0.5, 0.5, 0.25},
+		// Negative scores still move up with a factor over one
+		{-1, 2, -0.5},
+		{-1, 0.5, -2},
+		{0, 2, 0},
+	}
+	for _, tt := range tests {
+		if got := scaleScore(tt.score, tt.f); got != tt.want {
+			t.Errorf("scaleScore(%v, %v) = %v, want %v", tt.score, tt.f, got, tt.want)
+		}
+	}
+}
+
+// datedDocs returns fragments of the filter test notes with the given scores, in order of their paths.
+func datedDocs(scores map[string]float32) []schema.Document {
+	var docs []schema.Document
+	for _, source := range slices.Sorted(maps.Keys(scores)) {
+		docs = append(docs, schema.Document{Score: scores[source], Metadata: map[string]any{"Source": source}})
+	}
+	return docs
+}
+
+func TestWeighDates(t *testing.T) {
+	r := newFilterTestRag(t)
+	const (
+		standup = "/work/2024/standup.md"           // dated 2024-03-01 in its frontmatter
+		kafka   = "/work/projects/kafka.md"         // modified 2024-05-10
+		journal = "/personal/journal/2024-06-02.md" // dated by its name
+		missing = "/not/indexed.md"                 // no date at all
+	)
+	now := date("2024-06-02")
+	march := &DateRange{From: date("2024-03-01"), To: date("2024-04-01")}
+	tests := []struct {
+		name   string
+		ret    Retrieval
+		dates  *DateRange
+		scores map[string]float32
+		want   map[string]float64
+		order  []string
+	}{
+		{
+			name:   "boost lifts notes within the range",
+			ret:    Retrieval{Dates: DateBoost, DateBoost: 0.5},
+			dates:  march,
+			scores: map[string]float32{standup: 0.4, kafka: 0.5, missing: 0.45},
+			want:   map[string]float64{standup: 0.6, kafka: 0.5, missing: 0.45},
+			order:  []string{standup, kafka, missing},
+		},
+		{
+			name:   "boost with negative scores",
+			ret:    Retrieval{Dates: DateBoost, DateBoost: 1},
+			dates:  march,
+			scores: map[string]float32{standup: -2, kafka: -1.5},
+			want:   map[string]float64{standup: -1, kafka: -1.5},
+			order:  []string{standup, kafka},
+		},
+		{
+			name:   "filter mode leaves the fragments alone",
+			ret:    Retrieval{Dates: DateFilter, DateBoost: 0.5},
+			dates:  march,
+			scores: map[string]float32{standup: 0.4, kafka: 0.5},
+			want:   map[string]float64{standup: 0.4, kafka: 0.5},
+			order:  []string{standup, kafka},
+		},
+		{
+			// kafka is 23 days old, standup 93; a 23 day half-life halves the edge of each 23 days
+			name:   "recency decays older notes",
+			ret:    Retrieval{RecencyHalfLife: 23 * 24 * time.Hour, RecencyWeight: 0.5},
+			scores: map[string]float32{standup: 1, kafka: 1, journal: 1, missing: 1},
+			want: map[string]float64{
+				journal: 1,
+				kafka:   0.5 + 0.5*0.5,
+				standup: 0.5 + 0.5*math.Pow(0.5, 93.0/23),
+				missing: 1,
+			},
+			// Ties keep their order
+			order: []string{missing, journal, kafka, standup},
+		},
+		{
+			name:   "recency is off when the query names dates",
+			ret:    Retrieval{RecencyHalfLife: 24 * time.Hour, RecencyWeight: 0.5},
+			dates:  march,
+			scores: map[string]float32{standup: 1, kafka: 0.9},
+			want:   map[string]float64{standup: 1, kafka: 0.9},
+			order:  []string{standup, kafka},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := r.weighDates(datedDocs(tt.scores), tt.ret, tt.dates, now)
+			var order []string
+			for _, d := range docs {
+				source := d.Metadata["Source"].(string)
+				order = append(order, source)
+				if math.Abs(float64(d.Score)-tt.want[source]) > 1e-5 {
+					t.Errorf("score of %s = %v, want %v", source, d.Score, tt.want[source])
+				}
+			}
+			if !slices.Equal(order, tt.order) {
+				t.Errorf("order = %q, want %q", order, tt.order)
+			}
+		})
+	}
+}
+
+func TestWithinDates(t *testing.T) {
+	march := DateRange{From: date("2025-03-01"), To: date("2025-04-01")}
+	tests := []struct {
+		name             string
+		filter           *Filter
+		wantFrom, wantTo string
+	}{
+		{"no filter", nil, "2025-03-01", "2025-04-01"},
+		{"wider filter", &Filter{After: date("2025-01-01"), Before: date("2025-12-01")}, "2025-03-01", "2025-04-01"},
+		{"narrower filter", &Filter{After: date("2025-03-10"), Before: date("2025-03-20")}, "2025-03-10", "2025-03-20"},
+	}
+	for _, tt := range tests {
+		f := withinDates(tt.filter, march)
+		if !f.After.Equal(date(tt.wantFrom)) || !f.Before.Equal(date(tt.wantTo)) {
+			t.Errorf("%s: within %v to %v, want %s to %s", tt.name, f.After, f.Before, tt.wantFrom, tt.wantTo)
+		}
+	}
+	// The filter passed in is left alone
+	f := &Filter{Tags: []string{"work"}}
+	if within := withinDates(f, march); !f.After.IsZero() || !slices.Equal(within.Tags, f.Tags) {
+		t.Errorf("withinDates changed the filter or lost its tags: %+v, %+v", f, within)
+	}
+}
@@ -0,0 +1,220 @@
+package rag
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/document/markdown"
+)
+
+// Filter narrows a query down to notes by their tags, folder, date and frontmatter.  Empty fields don't filter.
+type Filter struct {
+	// Tags must all be on the note, in its frontmatter or inline; a tag also matches the tags nested under it, so
+	// project matches project/x
+	Tags []string
+	// Paths are folders or notes relative to the document folder, one of which the note must be in
+	Paths []string
+	// After (inclusive) and Before (exclusive) bound the note's date: its date or created frontmatter field, the date
+	// in its name (as daily notes have), or when it was last modified
+	After, Before time.Time
+	// Fields maps other frontmatter fields to values, one of which the field must have (or, for lists, contain);
+	// values are compared case-insensitively
+	Fields map[string][]string
+}
+
+// IsZero reports whether the filter lets every note through.
+func (f Filter) IsZero() bool {
+	return len(f.Tags) == 0 && len(f.Paths) == 0 && f.After.IsZero() && f.Before.IsZero() && len(f.Fields) == 0
+}
+
+// String returns the filter in the syntax ParseFilter reads.
+func (f Filter) String() string {
+	var parts []string
+	for _, t := range f.Tags {
+		parts = append(parts, "tag:"+quoteFilterValue(t))
+	}
+	for _, p := range f.Paths {
+		parts = append(parts, "path:"+quoteFilterValue(p))
+	}
+	if !f.After.IsZero() {
+		parts = append(parts, "after:"+f.After.Format(time.DateOnly))
+	}
+	if !f.Before.IsZero() {
+		parts = append(parts, "before:"+f.Before.Format(time.DateOnly))
+	}
+	for _, k := range slices.Sorted(maps.Keys(f.Fields)) {
+		for _, v := range f.Fields[k] {
+			parts = append(parts, k+":"+quoteFilterValue(v))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// filterTermPattern matches key:value terms, with the value optionally quoted, e.g. path:"work notes/"
+var filterTermPattern = regexp.MustCompile(`(^|\s)([\p{L}][\p{L}\p{N}_-]*):("[^"]*"|[^\s"]+)`)
+
+// ParseFilter strips the filter terms out of a query, returning the rest of the query and the filter they make up.
+// The terms are tag: (or tags:), path: (a folder, note or glob such as work/*/standup*), after: and before: (dates
+// such as 2024-01-01), and any frontmatter field of the indexed notes, e.g. type:meeting.  Anything else that looks like a term (a URL, a time of day, a field no note
+// has) is left in the query.
+func (r *ChromemRag) ParseFilter(query string) (string, Filter) {
+	var f Filter
+	rest := filterTermPattern.ReplaceAllStringFunc(query, func(term string) string {
+		m := filterTermPattern.FindStringSubmatch(term)
+		key, value := strings.ToLower(m[2]), strings.Trim(m[3], `"`)
+		if value == "" || strings.HasPrefix(value, "//") {
+			return term
+		}
+		switch key {
+		case "tag", "tags":
+			for _, t := range strings.Split(value, ",") {
+				if t = strings.TrimPrefix(strings.TrimSpace(t), "#"); t != "" {
+					f.Tags = append(f.Tags, t)
+				}
+			}
+		case "path", "folder":
+			f.Paths = append(f.Paths, value)
+		case "after", "since", "before", "until":
+			t, ok := markdown.ParseDate(value)
+			if !ok {
+				return term
+			}
+			if key == "after" || key == "since" {
+				f.After = t
+			} else {
+				f.Before = t
+			}
+		default:
+			if !r.matter.hasField(m[2]) {
+				return term
+			}
+			if f.Fields == nil {
+				f.Fields = make(map[string][]string)
+			}
+			f.Fields[m[2]] = append(f.Fields[m[2]], value)
+		}
+		return m[1]
+	})
+	return strings.Join(strings.Fields(rest), " "), f
+}
+
+// ParseQueryOptions reads the filter terms (see ParseFilter) and an expression of time (see ParseDates, relative to
+// now) out of a query, returning the options to search for the rest of it with.  A query made of nothing else is
+// searched for as is.
+func (r *ChromemRag) ParseQueryOptions(query string, now time.Time) QueryOptions {
+	opts := QueryOptions{Text: query}
+	if text, filter := r.ParseFilter(query); !filter.IsZero() {
+		if text != "" {
+			opts.Text = text
+		}
+		opts.Filter = &filter
+	}
+	if text, dates := ParseDates(opts.Text, now); dates != nil {
+		if text != "" {
+			opts.Text = text
+		}
+		opts.Dates = dates
+	}
+	return opts
+}
+
+// filterNotes returns the notes passing the filter, or nil if there's no filter.
+func (r *ChromemRag) filterNotes(f *Filter) map[string]bool {
+	if f == nil || f.IsZero() {
+		return nil
+	}
+	notes := make(map[string]bool)
+	for _, relPath := range r.manifest.paths() {
+		if r.noteMatches(relPath, *f) {
+			notes[relPath] = true
+		}
+	}
+	return notes
+}
+
+// noteMatches reports whether the note at relPath passes the filter.
+func (r *ChromemRag) noteMatches(relPath string, f Filter) bool {
+	if len(f.Paths) > 0 && !slices.ContainsFunc(f.Paths, func(p string) bool { return inPath(relPath, p) }) {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		tags := r.graph.tags(relPath)
+		for _, want := range f.Tags {
+			if !slices.ContainsFunc(tags, func(t string) bool { return tagMatches(t, want) }) {
+				return false
+			}
+		}
+	}
+	matter, _ := r.matter.get(relPath)
+	for field, values := range f.Fields {
+		v, ok := lookupField(matter, field)
+		if !ok || !slices.ContainsFunc(values, func(want string) bool { return valueMatches(v, want) }) {
+			return false
+		}
+	}
+	if !f.After.IsZero() || !f.Before.IsZero() {
+		date, ok := r.noteDate(relPath)
+		if !ok || date.Before(f.After) || !f.Before.IsZero() && !date.Before(f.Before) {
+			return false
+		}
+	}
+	return true
+}
+
+// noteDate returns the date of the note at relPath: its date or created frontmatter field, the date in its name, or
+// otherwise when the file was last modified.
+func (r *ChromemRag) noteDate(relPath string) (time.Time, bool) {
+	e, ok := r.manifest.get(relPath)
+	if !ok {
+		return time.Time{}, false
+	}
+	matter, _ := r.matter.get(relPath)
+	return markdown.NoteDate(relPath, matter, e.ModTime)
+}
+
+// inPath reports whether the note at relPath is (in) the given folder or note, case-insensitively.  p may be a glob,
+// e.g. work/*/standup*, matching the note (with or without its extension) or any folder it's in.
+func inPath(relPath, p string) bool {
+	rel := strings.ToLower(strings.TrimPrefix(relPath, "/"))
+	p = strings.ToLower(strings.Trim(p, "/"))
+	if p == "" || rel == p || rel == p+".md" || strings.HasPrefix(rel, p+"/") {
+		return true
+	}
+	if !strings.ContainsAny(p, "*?[") {
+		return false
+	}
+	for dir := rel; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		for _, name := range []string{dir, strings.TrimSuffix(dir, ".md")} {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tagMatches reports whether a note's tag is the wanted tag or nested under it, case-insensitively.
+func tagMatches(tag, want string) bool {
+	tag, want = strings.ToLower(tag), strings.ToLower(strings.TrimSuffix(want, "/"))
+	return tag == want || strings.HasPrefix(tag, want+"/")
+}
+
+// valueMatches reports whether a frontmatter value is (or, for a list, contains) the wanted one, case-insensitively.
+func valueMatches(v any, want string) bool {
+	if l, ok := v.([]any); ok {
+		return slices.ContainsFunc(l, func(e any) bool { return valueMatches(e, want) })
+	}
+	return strings.EqualFold(strings.TrimSpace(fmt.Sprint(v)), want)
+}
+
+func quoteFilterValue(v string) string {
+	if strings.ContainsAny(v, " \t") {
+		return `"` + v + `"`
+	}
+	return v
+}
@@ -0,0 +1,189 @@
+package rag
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/document/markdown"
+)
+
+// newFilterTestRag returns a RAG knowing of a few notes, with their tags, frontmatter and modification times.
+func newFilterTestRag(t *testing.T) *ChromemRag {
+	t.Helper()
+	dir := t.TempDir()
+	m, err := loadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := loadLinkGraph(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matter, err := loadFrontmatterIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := []struct {
+		path    string
+		tags    []string
+		matter  map[string]any
+		modTime string
+	}{
+		{"/work/2024/standup.md", []string{"work/standup"}, map[string]any{"type": "meeting", "attendees": []any{"Ann", "Bob"}, "date": "2024-03-01"}, "2024-06-30"},
+		{"/work/projects/kafka.md", []string{"project/kafka", "work"}, map[string]any{"type": "design", "Status": "Draft"}, "2024-05-10"},
+		{"/personal/journal/2024-06-02.md", []string{"journal"}, nil, "2024-06-30"},
+		{"/personal/recipes.md", nil, map[string]any{"type": "list"}, "2023-01-01"},
+	}
+	for _, n := range notes {
+		modTime, _ := time.ParseInLocation(time.DateOnly, n.modTime, time.Local)
+		m.set(n.path, manifestEntry{ModTime: modTime})
+		g.set(n.path, markdown.Note{Links: markdown.Links{Tags: n.tags}})
+		if n.matter != nil {
+			matter.set(n.path, n.matter)
+		}
+	}
+	return &ChromemRag{manifest: m, graph: g, matter: matter}
+}
+
+func date(s string) time.Time {
+	t, _ := time.ParseInLocation(time.DateOnly, s, time.Local)
+	return t
+}
+
+func TestParseFilter(t *testing.T) {
+	r := newFilterTestRag(t)
+	tests := []struct {
+		name     string
+		query    string
+		wantRest string
+		want     Filter
+	}{
+		{
+			name:     "tags",
+			query:    "standup notes tag:work/standup tags:#a,b",
+			wantRest: "standup notes",
+			want:     Filter{Tags: []string{"work/standup", "a", "b"}},
+		},
+		{
+			name:     "paths and globs",
+			query:    `path:work/ what folder:"personal stuff" path:work/*/standup*`,
+			wantRest: "what",
+			want:     Filter{Paths: []string{"work/", "personal stuff", "work/*/standup*"}},
+		},
+		{
+			name:     "date range",
+			query:    "since:2024-01-01 decisions before:2024-06-01",
+			wantRest: "decisions",
+			want:     Filter{After: date("2024-01-01"), Before: date("2024-06-01")},
+		},
+		{
+			name:     "frontmatter fields, keeping the field's case",
+			query:    "type:meeting type:design status:draft attendees:ann",
+			wantRest: "",
+			want:     Filter{Fields: map[string][]string{"type": {"meeting", "design"}, "status": {"draft"}, "attendees": {"ann"}}},
+		},
+		{
+			name:     "terms that aren't filters stay in the query",
+			query:    "see https://example.com at 10:30 mood:happy after:soon",
+			wantRest: "see https://example.com at 10:30 mood:happy after:soon",
+		},
+		{
+			name:     "malformed terms stay in the query",
+			query:    `tag: tag:"" kafka path:"work notes`,
+			wantRest: `tag: tag:"" kafka path:"work notes`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rest, f := r.ParseFilter(tt.query)
+			if rest != tt.wantRest {
+				t.Errorf("rest = %q, want %q", rest, tt.wantRest)
+			}
+			if !slices.Equal(f.Tags, tt.want.Tags) || !slices.Equal(f.Paths, tt.want.Paths) || !f.After.Equal(tt.want.After) ||
+				!f.Before.Equal(tt.want.Before) || !maps.EqualFunc(f.Fields, tt.want.Fields, slices.Equal) {
+				t.Errorf("filter = %+v, want %+v", f, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterNotes(t *testing.T) {
+	r := newFilterTestRag(t)
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		// Nested tags match their parents, case-insensitively
+		{"tag:work", []string{"/work/2024/standup.md", "/work/projects/kafka.md"}},
+		{"tag:Project", []string{"/work/projects/kafka.md"}},
+		{"tag:work tag:project/kafka", []string{"/work/projects/kafka.md"}},
+		{"tag:proj", nil},
+		// Folders, notes and globs
+		{"path:personal/", []string{"/personal/journal/2024-06-02.md", "/personal/recipes.md"}},
+		{"path:personal/recipes", []string{"/personal/recipes.md"}},
+		{"path:pers", nil},
+		{"path:work/*/standup*", []string{"/work/2024/standup.md"}},
+		{"path:*/journal", []string{"/personal/journal/2024-06-02.md"}},
+		{"path:*/*/[k2]*", []string{"/personal/journal/2024-06-02.md", "/work/projects/kafka.md"}},
+		{"path:*/r*", []string{"/personal/recipes.md"}},
+		{"path:work/projects path:personal/recipes.md", []string{"/personal/recipes.md", "/work/projects/kafka.md"}},
+		// Dates come from the frontmatter, then the name, then the modification time
+		{"after:2024-06-01", []string{"/personal/journal/2024-06-02.md"}},
+		{"after:2024-03-01 before:2024-06-02", []string{"/work/2024/standup.md", "/work/projects/kafka.md"}},
+		{"before:2024-01-01", []string{"/personal/recipes.md"}},
+		// Frontmatter values, one of which must match, and list fields containing them
+		{"type:meeting", []string{"/work/2024/standup.md"}},
+		{"type:meeting type:list", []string{"/personal/recipes.md", "/work/2024/standup.md"}},
+		{"attendees:bob", []string{"/work/2024/standup.md"}},
+		{"status:DRAFT", []string{"/work/projects/kafka.md"}},
+		{"type:meeting tag:project", nil},
+	}
+	for _, tt := range tests {
+		_, f := r.ParseFilter(tt.filter)
+		if f.IsZero() {
+			t.Fatalf("%q doesn't parse into a filter", tt.filter)
+		}
+		got := slices.Sorted(maps.Keys(r.filterNotes(&f)))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: notes = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+	if notes := r.filterNotes(&Filter{}); notes != nil {
+		t.Errorf("an empty filter let %v through, want every note", notes)
+	}
+}
+
+func TestParseQueryOptions(t *testing.T) {
+	r := newFilterTestRag(t)
+	now := date("2024-06-12")
+	tests := []struct {
+		query      string
+		wantText   string
+		wantFilter string
+		wantDates  string
+	}{
+		{"kafka retention", "kafka retention", "", ""},
+		{"tag:work what did we decide last week", "what did we decide", "tag:work", "2024-06-03 to 2024-06-09"},
+		{"type:meeting on 2024-03-01", "on 2024-03-01", "type:meeting", "2024-03-01"},
+		{"tag:work", "tag:work", "tag:work", ""},
+		{"last week", "last week", "", "2024-06-03 to 2024-06-09"},
+	}
+	for _, tt := range tests {
+		opts := r.ParseQueryOptions(tt.query, now)
+		if opts.Text != tt.wantText {
+			t.Errorf("%q: text = %q, want %q", tt.query, opts.Text, tt.wantText)
+		}
+		var filter, dates string
+		if opts.Filter != nil {
+			filter = opts.Filter.String()
+		}
+		if opts.Dates != nil {
+			dates = opts.Dates.String()
+		}
+		if filter != tt.wantFilter || dates != tt.wantDates {
+			t.Errorf("%q: filter %q and dates %q, want %q and %q", tt.query, filter, dates, tt.wantFilter, tt.wantDates)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package rag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// frontmatterFile is the name of the frontmatter index within the DB folder.
+const frontmatterFile = "frontmatter.json"
+
+// frontmatterIndex keeps the frontmatter of every indexed note, keyed by relative path, with its lists, numbers and
+// dates intact; fragment metadata only holds strings.
+type frontmatterIndex struct {
+	path  string
+	mu    sync.RWMutex
+	notes map[string]map[string]any
+	dirty bool
+	// saveMu keeps concurrent saves from renaming an older snapshot over a newer one
+	saveMu sync.Mutex
+}
+
+// loadFrontmatterIndex reads the frontmatter index stored in dbPath.  A missing index yields an empty one.
+func loadFrontmatterIndex(dbPath string) (*frontmatterIndex, error) {
+	fi := &frontmatterIndex{
+		path:  filepath.Join(dbPath, frontmatterFile),
+		notes: make(map[string]map[string]any),
+	}
+	b, err := os.ReadFile(fi.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fi, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &fi.notes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse frontmatter index %s: %w", fi.path, err)
+	}
+	return fi, nil
+}
+
+// set records the frontmatter of the note at relPath.
+func (fi *frontmatterIndex) set(relPath string, matter map[string]any) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	m := make(map[string]any, len(matter))
+	for k, v := range matter {
+		m[k] = jsonValue(v)
+	}
+	fi.notes[relPath] = m
+	fi.dirty = true
+}
+
+// get returns the frontmatter of the note at relPath.
+func (fi *frontmatterIndex) get(relPath string) (map[string]any, bool) {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	m, ok := fi.notes[relPath]
+	return m, ok
+}
+
+// has reports whether the frontmatter of the note at relPath is known.
+func (fi *frontmatterIndex) has(relPath string) bool {
+	_, ok := fi.get(relPath)
+	return ok
+}
+
+func (fi *frontmatterIndex) remove(relPath string) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if _, ok := fi.notes[relPath]; !ok {
+		return
+	}
+	delete(fi.notes, relPath)
+	fi.dirty = true
+}
+
+// paths returns the relative paths of every note in the index.
+func (fi *frontmatterIndex) paths() []string {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	paths := make([]string, 0, len(fi.notes))
+	for p := range fi.notes {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// hasField reports whether any note has the given frontmatter field (case-insensitively).
+func (fi *frontmatterIndex) hasField(field string) bool {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	for _, m := range fi.notes {
+		if _, ok := lookupField(m, field); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reset empties the index.
+func (fi *frontmatterIndex) reset() {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	fi.notes = make(map[string]map[string]any)
+	fi.dirty = true
+}
+
+// save writes the index to disk, if it changed since it was last written.
+func (fi *frontmatterIndex) save() error {
+	fi.saveMu.Lock()
+	defer fi.saveMu.Unlock()
+	fi.mu.Lock()
+	if !fi.dirty {
+		fi.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(fi.notes)
+	fi.dirty = false
+	fi.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fi.path, b)
+}
+
+// lookupField returns the value of a frontmatter field, matching its name case-insensitively.
+func lookupField(matter map[string]any, field string) (any, bool) {
+	if v, ok := matter[field]; ok {
+		return v, true
+	}
+	for k, v := range matter {
+		if strings.EqualFold(k, field) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// jsonValue converts a frontmatter value into one that survives a round trip through JSON: nested maps get string
+// keys, and times become dates (or RFC 3339 timestamps, if they have a time of day).
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[k] = jsonValue(e)
+		}
+		return m
+	case []any:
+		l := make([]any, len(v))
+		for i, e := range v {
+			l[i] = jsonValue(e)
+		}
+		return l
+	case time.Time:
+		if v.Equal(v.Truncate(24 * time.Hour)) {
+			return v.Format(time.DateOnly)
+		}
+		return v.Format(time.RFC3339)
+	}
+	return v
+}
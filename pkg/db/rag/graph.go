@@ -0,0 +1,425 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/clocklear/texttrove/pkg/document/markdown"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// graphFile is the name of the link graph within the DB folder.
+const graphFile = "graph.json"
+
+// graphNote records what a note links to, as written in it.
+type graphNote struct {
+	Links   []string `json:"links,omitempty"`
+	Embeds  []string `json:"embeds,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Aliases []string `json:"aliases,omitempty"`
+	// Transcluded lists the notes whose content was embedded into this one when it was indexed
+	Transcluded []string `json:"transcluded,omitempty"`
+}
+
+// linkGraph holds the wikilinks, embeds, tags and aliases of every indexed note, keyed by relative path.  Links are
+// resolved the way Obsidian does: by path, then by note name, then by alias.
+type linkGraph struct {
+	path  string
+	mu    sync.RWMutex
+	notes map[string]graphNote
+	// files holds notes known to exist that may not have been indexed yet, so links to them resolve while indexing
+	files map[string]bool
+	dirty bool
+	// names caches the index links are resolved with; it's dropped whenever a note comes, goes or changes its
+	// aliases
+	names *nameIndex
+	// resolved caches the resolved links and backlinks; it's dropped whenever a note changes
+	resolved *resolvedGraph
+	// saveMu keeps concurrent saves from renaming an older snapshot over a newer one
+	saveMu sync.Mutex
+}
+
+// nameIndex finds notes by path (without the extension), name and alias, case-insensitively.
+type nameIndex struct {
+	byPath  map[string]string
+	byName  map[string][]string
+	byAlias map[string]string
+}
+
+// resolvedGraph maps notes to the notes they link to (including embeds) and the notes linking to them.
+type resolvedGraph struct {
+	outgoing  map[string][]string
+	backlinks map[string][]string
+	// embeds maps notes to the notes they embed
+	embeds map[string][]string
+}
+
+// loadLinkGraph reads the link graph stored in dbPath.  A missing graph yields an empty one.
+func loadLinkGraph(dbPath string) (*linkGraph, error) {
+	g := &linkGraph{
+		path:  filepath.Join(dbPath, graphFile),
+		notes: make(map[string]graphNote),
+		files: make(map[string]bool),
+	}
+	b, err := os.ReadFile(g.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &g.notes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse link graph %s: %w", g.path, err)
+	}
+	return g, nil
+}
+
+// set records the links of the note at relPath.
+func (g *linkGraph) set(relPath string, n markdown.Note) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	old, ok := g.notes[relPath]
+	if !ok && !g.files[relPath] || !slices.Equal(old.Aliases, n.Aliases) {
+		g.names = nil
+	}
+	g.notes[relPath] = graphNote{
+		Links:       n.Links.Links,
+		Embeds:      n.Links.Embeds,
+		Tags:        n.Links.Tags,
+		Aliases:     n.Aliases,
+		Transcluded: n.Transcluded,
+	}
+	g.resolved = nil
+	g.dirty = true
+}
+
+// addFiles records that the notes at the given relative paths exist, whether or not they've been indexed yet.
+func (g *linkGraph) addFiles(relPaths []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, p := range relPaths {
+		if _, ok := g.notes[p]; !ok && !g.files[p] {
+			g.names = nil
+			g.resolved = nil
+		}
+		g.files[p] = true
+	}
+}
+
+// has reports whether the links of the note at relPath are known.
+func (g *linkGraph) has(relPath string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.notes[relPath]
+	return ok
+}
+
+func (g *linkGraph) remove(relPath string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.files, relPath)
+	if _, ok := g.notes[relPath]; !ok {
+		return
+	}
+	delete(g.notes, relPath)
+	g.names = nil
+	g.resolved = nil
+	g.dirty = true
+}
+
+// tags returns the tags of the note at relPath, both inline and in its frontmatter.
+func (g *linkGraph) tags(relPath string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.notes[relPath].Tags
+}
+
+// paths returns the relative paths of every note in the graph.
+func (g *linkGraph) paths() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	paths := make([]string, 0, len(g.notes))
+	for p := range g.notes {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// reset empties the graph.
+func (g *linkGraph) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.notes = make(map[string]graphNote)
+	g.files = make(map[string]bool)
+	g.names = nil
+	g.resolved = nil
+	g.dirty = true
+}
+
+// save writes the graph to disk, if it changed since it was last written.
+func (g *linkGraph) save() error {
+	g.saveMu.Lock()
+	defer g.saveMu.Unlock()
+	g.mu.Lock()
+	if !g.dirty {
+		g.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(g.notes)
+	g.dirty = false
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(g.path, b)
+}
+
+// Resolve returns the note a link in the note at from points to; it makes the graph a markdown.Resolver.
+func (g *linkGraph) Resolve(from, target string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.nameIndex().lookup(from, target)
+}
+
+// linkedNote is a note reached by following links from a retrieved one.
+type linkedNote struct {
+	path string
+	// from is the retrieved note the links were followed from
+	from string
+	hops int
+}
+
+// neighbors returns the notes within the given number of hops of the given ones, following links in either
+// direction.  Nearer notes come first, and at equal distance, those reached from earlier notes.  The given notes
+// themselves aren't included.
+func (g *linkGraph) neighbors(paths []string, hops int) []linkedNote {
+	r := g.resolve()
+	seen := make(map[string]bool)
+	var frontier []linkedNote
+	for _, p := range paths {
+		seen[p] = true
+		frontier = append(frontier, linkedNote{path: p, from: p})
+	}
+	var res []linkedNote
+	for hop := 1; hop <= hops && len(frontier) > 0; hop++ {
+		var next []linkedNote
+		for _, n := range frontier {
+			for _, p := range slices.Concat(r.outgoing[n.path], r.backlinks[n.path]) {
+				if !seen[p] {
+					seen[p] = true
+					next = append(next, linkedNote{path: p, from: n.from, hops: hop})
+				}
+			}
+		}
+		res = append(res, next...)
+		frontier = next
+	}
+	return res
+}
+
+// transcluders returns the notes that embed any of the given ones, directly or through other notes, or that have
+// embeds resolving to them now.  The given notes themselves aren't included.
+func (g *linkGraph) transcluders(relPaths []string) []string {
+	r := g.resolve()
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var res []string
+	for p, n := range g.notes {
+		if slices.Contains(relPaths, p) {
+			continue
+		}
+		for _, t := range slices.Concat(n.Transcluded, r.embeds[p]) {
+			if slices.Contains(relPaths, t) {
+				res = append(res, p)
+				break
+			}
+		}
+	}
+	slices.Sort(res)
+	return res
+}
+
+// resolve returns the resolved graph, building it if any note changed since it was last built.
+func (g *linkGraph) resolve() *resolvedGraph {
+	g.mu.RLock()
+	r := g.resolved
+	g.mu.RUnlock()
+	if r != nil {
+		return r
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resolved != nil {
+		return g.resolved
+	}
+	names := g.nameIndex()
+	r = &resolvedGraph{
+		outgoing:  make(map[string][]string),
+		backlinks: make(map[string][]string),
+		embeds:    make(map[string][]string),
+	}
+	for p, n := range g.notes {
+		for _, target := range slices.Concat(n.Links, n.Embeds) {
+			t, ok := names.lookup(p, target)
+			if !ok || t == p {
+				continue
+			}
+			if slices.Contains(n.Embeds, target) && !slices.Contains(r.embeds[p], t) {
+				r.embeds[p] = append(r.embeds[p], t)
+			}
+			if slices.Contains(r.outgoing[p], t) {
+				continue
+			}
+			r.outgoing[p] = append(r.outgoing[p], t)
+			r.backlinks[t] = append(r.backlinks[t], p)
+		}
+	}
+	// Keep the order stable across builds
+	for _, ps := range r.backlinks {
+		slices.Sort(ps)
+	}
+	g.resolved = r
+	return r
+}
+
+// nameIndex returns the index links are resolved with, building it if needed.  g.mu must be held for writing.
+func (g *linkGraph) nameIndex() *nameIndex {
+	if g.names != nil {
+		return g.names
+	}
+	idx := &nameIndex{
+		byPath:  make(map[string]string),
+		byName:  make(map[string][]string),
+		byAlias: make(map[string]string),
+	}
+	add := func(p string, aliases []string) {
+		key := noteKey(p)
+		if _, ok := idx.byPath[key]; ok {
+			return
+		}
+		idx.byPath[key] = p
+		name := path.Base(key)
+		idx.byName[name] = append(idx.byName[name], p)
+		for _, a := range aliases {
+			idx.byAlias[strings.ToLower(a)] = p
+		}
+	}
+	for p, n := range g.notes {
+		add(p, n.Aliases)
+	}
+	for p := range g.files {
+		add(p, nil)
+	}
+	for _, ps := range idx.byName {
+		// Like Obsidian, prefer the note closest to the root when names clash
+		slices.SortFunc(ps, func(a, b string) int {
+			if d := strings.Count(a, "/") - strings.Count(b, "/"); d != 0 {
+				return d
+			}
+			return strings.Compare(a, b)
+		})
+	}
+	g.names = idx
+	return idx
+}
+
+// lookup returns the note a link in the note at from points to.
+func (idx *nameIndex) lookup(from, target string) (string, bool) {
+	key := noteKey(target)
+	if p, ok := idx.byPath[key]; ok {
+		return p, true
+	}
+	// Markdown links are relative to the note they're in
+	if p, ok := idx.byPath[noteKey(path.Join(path.Dir(from), target))]; ok {
+		return p, true
+	}
+	if ps := idx.byName[path.Base(key)]; len(ps) > 0 {
+		if !strings.Contains(key, "/") {
+			return ps[0], true
+		}
+		// A partial path, e.g. project/plan for notes/project/plan.md
+		for _, p := range ps {
+			if strings.HasSuffix(noteKey(p), "/"+key) {
+				return p, true
+			}
+		}
+	}
+	p, ok := idx.byAlias[strings.ToLower(target)]
+	return p, ok
+}
+
+// noteKey returns the key a note is found by in a nameIndex: its path without the leading / and the extension,
+// lowercased.
+func noteKey(p string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(p, "/"), ".md"))
+}
+
+// Metadata keys of the fragments added to the results because their note links to (or is linked from) a retrieved
+// one; see Retrieval.LinkHops.
+const (
+	MetadataLinkedFrom = "LinkedFrom"
+	MetadataLinkHops   = "LinkHops"
+)
+
+// expandLinks adds to the hits the fragments most relevant to the query from the notes within ret.LinkHops links of
+// theirs, nearest first, up to ret.LinkBudget fragments (one per note).  Linked notes are subject to the query's
+// filters, too.
+func (r *ChromemRag) expandLinks(ctx context.Context, opts QueryOptions, ret Retrieval, hits []schema.Document) ([]schema.Document, error) {
+	if ret.LinkHops <= 0 || ret.LinkBudget <= 0 || len(hits) == 0 {
+		return hits, nil
+	}
+	var sources []string
+	for _, d := range hits {
+		if s, ok := d.Metadata["Source"].(string); ok && !slices.Contains(sources, s) {
+			sources = append(sources, s)
+		}
+	}
+	linked := r.graph.neighbors(sources, ret.LinkHops)
+	if notes := r.filterNotes(opts.Filter); notes != nil {
+		linked = slices.DeleteFunc(linked, func(n linkedNote) bool { return !notes[n.path] })
+	}
+	if len(linked) == 0 {
+		return hits, nil
+	}
+
+	col := r.collection()
+	if col.Count() == 0 {
+		return hits, nil
+	}
+	embedding, err := r.embed(ctx, r.prompts.QueryPrefix+opts.Text)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create embedding of query: %w", err)
+	}
+	whereDocument := stringifyMetadata(opts.WhereDocument)
+	added := 0
+	for _, n := range linked {
+		if added == ret.LinkBudget {
+			break
+		}
+		where := stringifyMetadata(opts.Where)
+		where["Source"] = n.path
+		res, err := queryEmbedding(ctx, col, embedding, 1, where, whereDocument)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range res {
+			doc := toSchemaDocument(d.Content, d.Metadata, d.Similarity)
+			doc.Metadata[MetadataLinkedFrom] = n.from
+			doc.Metadata[MetadataLinkHops] = n.hops
+			hits = append(hits, doc)
+			added++
+		}
+	}
+	return hits, nil
+}
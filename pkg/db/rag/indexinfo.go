@@ -0,0 +1,159 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ModelChangePolicy decides what happens when the index was built with different embedding settings than the
+// configured ones.
+type ModelChangePolicy string
+
+const (
+	// RebuildOnModelChange discards the index and embeds every document again
+	RebuildOnModelChange ModelChangePolicy = "rebuild"
+	// RefuseOnModelChange leaves the index alone and fails instead
+	RefuseOnModelChange ModelChangePolicy = "refuse"
+)
+
+// ErrModelChanged is returned when the index was built with different embedding settings and the policy is to refuse.
+var ErrModelChanged = errors.New("the index was built with different embedding settings")
+
+// collectionName is the name of the chromem collection holding the document fragments.
+const collectionName = "texttrove"
+
+// indexInfoFile is the name of the file within the DB folder describing how the index was built.
+const indexInfoFile = "index.json"
+
+// indexInfo describes the embedding settings an index was built with.  Vectors built with different settings can't
+// be compared, so any change requires a rebuild.
+type indexInfo struct {
+	Model string `json:"model"`
+	// Dimension is the length of the vectors; it's zero until the first embedding is made
+	Dimension int          `json:"dimension,omitempty"`
+	Prompts   ModelPrompts `json:"prompts"`
+}
+
+// metadata renders the info as chromem collection metadata.
+func (i indexInfo) metadata() map[string]string {
+	return map[string]string{
+		"embedding_model":  i.Model,
+		"embedding_prefix": i.Prompts.EmbeddingPrefix,
+		"query_prefix":     i.Prompts.QueryPrefix,
+	}
+}
+
+// diff describes what changed between i and the given (newer) info.
+func (i indexInfo) diff(o indexInfo) string {
+	var changes []string
+	if i.Model != o.Model {
+		changes = append(changes, fmt.Sprintf("embedding model %q is now %q", i.Model, o.Model))
+	}
+	if i.Dimension != 0 && o.Dimension != 0 && i.Dimension != o.Dimension {
+		changes = append(changes, fmt.Sprintf("vector dimension %d is now %d", i.Dimension, o.Dimension))
+	}
+	if i.Prompts != o.Prompts {
+		changes = append(changes, "embedding prompt prefixes changed")
+	}
+	return strings.Join(changes, ", ")
+}
+
+// loadIndexInfo reads the info stored in dbPath, reporting whether there was any.
+func loadIndexInfo(dbPath string) (indexInfo, bool, error) {
+	var info indexInfo
+	b, err := os.ReadFile(filepath.Join(dbPath, indexInfoFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return info, false, nil
+	}
+	if err != nil {
+		return info, false, err
+	}
+	err = json.Unmarshal(b, &info)
+	if err != nil {
+		return info, false, fmt.Errorf("failed to parse %s: %w", indexInfoFile, err)
+	}
+	return info, true, nil
+}
+
+func saveIndexInfo(dbPath string, info indexInfo) error {
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dbPath, indexInfoFile), b)
+}
+
+// checkModel compares the embedding settings the index was built with against the configured ones, applying the
+// model change policy if they differ.  Only the model name and prompts are compared; see checkDimension.
+func (r *ChromemRag) checkModel() error {
+	stored, ok, err := loadIndexInfo(r.dbPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Either a new index, or one that predates index info; assume it matches
+		return saveIndexInfo(r.dbPath, r.info)
+	}
+	if stored.Model == r.info.Model && stored.Prompts == r.info.Prompts {
+		r.info.Dimension = stored.Dimension
+		return nil
+	}
+	return r.modelChanged(stored)
+}
+
+// checkDimension embeds a probe to find the length of the vectors the embedding model produces.  The first time around
+// it's recorded; after that, a change (e.g. a model pulled again under the same name) is treated as a model change.
+// If the model can't be reached, the check is skipped; the sync will fail file by file and be retried later.
+func (r *ChromemRag) checkDimension(ctx context.Context) error {
+	v, err := r.embed(ctx, "texttrove")
+	if err != nil {
+		r.Log(fmt.Sprintf("err: failed to reach the embedding model: %v", err))
+		return nil
+	}
+	if r.info.Dimension == len(v) {
+		return nil
+	}
+	stored := r.info
+	r.info.Dimension = len(v)
+	if stored.Dimension == 0 {
+		return saveIndexInfo(r.dbPath, r.info)
+	}
+	return r.modelChanged(stored)
+}
+
+// modelChanged applies the model change policy, given the settings the index was built with.
+func (r *ChromemRag) modelChanged(stored indexInfo) error {
+	if r.policy == RefuseOnModelChange {
+		return fmt.Errorf("%w: %s", ErrModelChanged, stored.diff(r.info))
+	}
+	r.Log(fmt.Sprintf("Embedding settings changed (%s); rebuilding the index...", stored.diff(r.info)))
+	return r.rebuild()
+}
+
+// rebuild swaps the collection for an empty one, so that every document gets embedded again on the next sync.
+func (r *ChromemRag) rebuild() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.db.DeleteCollection(collectionName)
+	if err != nil {
+		return err
+	}
+	r.col, err = r.db.CreateCollection(collectionName, r.info.metadata(), r.embed)
+	if err != nil {
+		return err
+	}
+	r.manifest.reset()
+	r.lexical.reset()
+	r.graph.reset()
+	r.matter.reset()
+	err = r.save()
+	if err != nil {
+		return err
+	}
+	return saveIndexInfo(r.dbPath, r.info)
+}
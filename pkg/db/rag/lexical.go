@@ -0,0 +1,289 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// lexicalFile is the name of the lexical index within the DB folder.
+const lexicalFile = "lexical.json"
+
+// lexicalVersion changes whenever what's indexed for a fragment does, so older indexes are rebuilt rather than mixed
+// with newer entries.
+const lexicalVersion = 2
+
+// lexicalData is the lexical index as stored on disk.
+type lexicalData struct {
+	Version int                       `json:"version"`
+	Docs    map[string]map[string]int `json:"docs"`
+}
+
+// BM25 parameters; these are the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// lexicalIndex is an inverted index over the fragments in the DB, scoring them against a query with BM25.  It finds
+// the exact identifiers, ticket numbers, acronyms and names that embeddings tend to blur.  It holds the same fragments
+// as the vector collection and can always be rebuilt from it.
+type lexicalIndex struct {
+	path string
+	mu   sync.RWMutex
+	// docs maps fragment IDs to their term frequencies
+	docs map[string]map[string]int
+	// postings maps terms to the fragments containing them, with their frequency
+	postings map[string]map[string]int
+	// lengths holds the number of terms in each fragment, and totalLength their sum
+	lengths     map[string]int
+	totalLength int
+	dirty       bool
+	// saveMu keeps concurrent saves from renaming an older snapshot over a newer one
+	saveMu sync.Mutex
+}
+
+// lexicalHit is a fragment matching a lexical query.
+type lexicalHit struct {
+	ID    string
+	Score float64
+}
+
+// loadLexicalIndex reads the lexical index stored in dbPath, reporting whether there was any.  An index of an older
+// version is dropped, to be rebuilt from the collection.
+func loadLexicalIndex(dbPath string) (*lexicalIndex, bool, error) {
+	l := &lexicalIndex{path: filepath.Join(dbPath, lexicalFile)}
+	l.reset()
+	b, err := os.ReadFile(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var data lexicalData
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse lexical index %s: %w", l.path, err)
+	}
+	if data.Version != lexicalVersion {
+		return l, false, nil
+	}
+	for id, terms := range data.Docs {
+		l.addTerms(id, terms)
+	}
+	l.dirty = false
+	return l, true, nil
+}
+
+// add indexes a fragment, replacing it if it's already there.
+func (l *lexicalIndex) add(id, content string) {
+	terms := make(map[string]int)
+	for _, t := range tokenize(content) {
+		terms[t]++
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.removeID(id)
+	l.addTerms(id, terms)
+}
+
+// remove drops the given fragments from the index.
+func (l *lexicalIndex) remove(ids ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		l.removeID(id)
+	}
+}
+
+// reset empties the index.
+func (l *lexicalIndex) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.docs = make(map[string]map[string]int)
+	l.postings = make(map[string]map[string]int)
+	l.lengths = make(map[string]int)
+	l.totalLength = 0
+	l.dirty = true
+}
+
+// ids returns the IDs of every indexed fragment.
+func (l *lexicalIndex) ids() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	ids := make([]string, 0, len(l.docs))
+	for id := range l.docs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// addTerms indexes a fragment given its term frequencies; l.mu must be held.
+func (l *lexicalIndex) addTerms(id string, terms map[string]int) {
+	length := 0
+	for t, tf := range terms {
+		p, ok := l.postings[t]
+		if !ok {
+			p = make(map[string]int)
+			l.postings[t] = p
+		}
+		p[id] = tf
+		length += tf
+	}
+	l.docs[id] = terms
+	l.lengths[id] = length
+	l.totalLength += length
+	l.dirty = true
+}
+
+// removeID drops a fragment from the index; l.mu must be held.
+func (l *lexicalIndex) removeID(id string) {
+	terms, ok := l.docs[id]
+	if !ok {
+		return
+	}
+	for t := range terms {
+		delete(l.postings[t], id)
+		if len(l.postings[t]) == 0 {
+			delete(l.postings, t)
+		}
+	}
+	l.totalLength -= l.lengths[id]
+	delete(l.docs, id)
+	delete(l.lengths, id)
+	l.dirty = true
+}
+
+// search scores every fragment containing at least one of the query's terms, best first.
+func (l *lexicalIndex) search(query string) []lexicalHit {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.docs) == 0 {
+		return nil
+	}
+	n := float64(len(l.docs))
+	avgLength := float64(l.totalLength) / n
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, t := range tokenize(query) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		p := l.postings[t]
+		if len(p) == 0 {
+			continue
+		}
+		df := float64(len(p))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, tf := range p {
+			f := float64(tf)
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(l.lengths[id])/avgLength)
+			scores[id] += idf * f * (bm25K1 + 1) / (f + norm)
+		}
+	}
+	hits := make([]lexicalHit, 0, len(scores))
+	for id, s := range scores {
+		hits = append(hits, lexicalHit{ID: id, Score: s})
+	}
+	slices.SortFunc(hits, func(a, b lexicalHit) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return hits
+}
+
+// save writes the index to disk if it changed since it was last saved.
+func (l *lexicalIndex) save() error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(lexicalData{Version: lexicalVersion, Docs: l.docs})
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, b)
+}
+
+// tokenize splits text into lowercase terms.  Words joined by -, _, . or / (ticket numbers, file names, versions) are
+// kept whole as well as split, so "JIRA-1234" matches both "jira-1234" and "1234".
+func tokenize(s string) []string {
+	isJoiner := func(c rune) bool {
+		return c == '-' || c == '_' || c == '.' || c == '/'
+	}
+	var tokens []string
+	words := strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c) && !isJoiner(c)
+	})
+	for _, w := range words {
+		w = strings.TrimFunc(w, isJoiner)
+		if w == "" {
+			continue
+		}
+		tokens = append(tokens, w)
+		if strings.IndexFunc(w, isJoiner) >= 0 {
+			tokens = append(tokens, strings.FieldsFunc(w, isJoiner)...)
+		}
+	}
+	return tokens
+}
+
+// lexicalText returns what's indexed for a fragment stored with content: its text, without the embedding prefix or the
+// metadata footer, whose labels would otherwise match every query that mentions them.
+func (r *ChromemRag) lexicalText(content string) string {
+	return FragmentText(strings.TrimPrefix(content, r.prompts.EmbeddingPrefix))
+}
+
+// syncLexical brings the lexical index in line with the vector collection, indexing fragments it's missing (e.g. in a
+// DB that predates it) and dropping ones that are gone.
+func (r *ChromemRag) syncLexical(ctx context.Context) error {
+	col := r.collection()
+	colIds := col.ListIDs(ctx)
+	indexed := make(map[string]bool)
+	for _, id := range r.lexical.ids() {
+		indexed[id] = true
+	}
+	var missing []string
+	for _, id := range colIds {
+		if indexed[id] {
+			delete(indexed, id)
+			continue
+		}
+		missing = append(missing, id)
+	}
+	if len(missing) > 0 {
+		r.Log(fmt.Sprintf("Adding %d document fragments to the lexical index...", len(missing)))
+	}
+	for _, id := range missing {
+		d, err := col.GetByID(ctx, id)
+		if err != nil {
+			// Deleted in the meantime
+			continue
+		}
+		r.lexical.add(id, r.lexicalText(d.Content))
+	}
+	for id := range indexed {
+		r.lexical.remove(id)
+	}
+	return r.lexical.save()
+}
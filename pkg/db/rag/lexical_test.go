@@ -0,0 +1,129 @@
+package rag
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"JIRA-1234", []string{"jira-1234", "jira", "1234"}},
+		{"Fixed in v1.2.3, see notes/kafka_setup.md!", []string{"fixed", "in", "v1.2.3", "v1", "2", "3", "see", "notes/kafka_setup.md", "notes", "kafka", "setup", "md"}},
+		{"end of sentence. Next", []string{"end", "of", "sentence", "next"}},
+		{"--flag -- _private_", []string{"flag", "private"}},
+		{"Café ÜBER", []string{"café", "über"}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func newTestLexicalIndex(t *testing.T, docs map[string]string) *lexicalIndex {
+	t.Helper()
+	l, _, err := loadLexicalIndex(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, content := range docs {
+		l.add(id, content)
+	}
+	return l
+}
+
+func hitIDs(hits []lexicalHit) []string {
+	ids := make([]string, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	return ids
+}
+
+func TestLexicalSearch(t *testing.T) {
+	l := newTestLexicalIndex(t, map[string]string{
+		"incident": "JIRA-1234 broke the kafka consumer; JIRA-1234 was rolled back",
+		"ticket":   "Follow up on JIRA-1234 next sprint with the platform team and the data team",
+		"kafka":    "Kafka retention is seven days on the shared cluster",
+		"lunch":    "Team lunch is on Friday",
+	})
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		// More occurrences in a shorter fragment rank higher
+		{"JIRA-1234", []string{"incident", "ticket"}},
+		{"1234", []string{"incident", "ticket"}},
+		// Matching more of the query's terms ranks higher
+		{"kafka retention", []string{"kafka", "incident"}},
+		// Length normalization lets a short fragment outrank a longer one that mentions the term more often
+		{"team", []string{"lunch", "ticket"}},
+		{"retention retention", []string{"kafka"}},
+		{"postgres", []string{}},
+	}
+	for _, tt := range tests {
+		if got := hitIDs(l.search(tt.query)); !slices.Equal(got, tt.want) {
+			t.Errorf("search(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// Replacing and removing fragments updates the postings
+	l.add("lunch", "Kafka workshop over lunch")
+	l.remove("incident")
+	if got := hitIDs(l.search("kafka")); !slices.Equal(got, []string{"lunch", "kafka"}) {
+		t.Errorf("after updates, search(kafka) = %q, want lunch then kafka", got)
+	}
+	if got := l.search("JIRA-1234"); len(got) != 1 || got[0].ID != "ticket" {
+		t.Errorf("after removing incident, search(JIRA-1234) = %v, want ticket", got)
+	}
+}
+
+func TestLexicalIndexSave(t *testing.T) {
+	dir := t.TempDir()
+	l, _, err := loadLexicalIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.add("a", "kafka retention")
+	if err := l.save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok, err := loadLexicalIndex(dir)
+	if err != nil || !ok {
+		t.Fatalf("load = %v, %v; want the saved index", ok, err)
+	}
+	if got := hitIDs(loaded.search("retention")); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("search after reload = %q, want a", got)
+	}
+
+	// An index from before the footer was left out is dropped, to be rebuilt
+	err = os.WriteFile(filepath.Join(dir, lexicalFile), []byte(`{"a":{"kafka":1,"source":1}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, ok, err = loadLexicalIndex(dir)
+	if err != nil || ok || len(loaded.ids()) != 0 {
+		t.Errorf("loading an old index = %v (%d fragments), %v; want it dropped", ok, len(loaded.ids()), err)
+	}
+}
+
+func TestLexicalText(t *testing.T) {
+	r := &ChromemRag{prompts: ModelPrompts{EmbeddingPrefix: "search_document: "}}
+	content := r.prompts.EmbeddingPrefix + "Kafka retention is seven days" + docContextFooter(map[string]any{"Source": "kafka.md", "DocId": "abc"})
+	if got := r.lexicalText(content); got != "Kafka retention is seven days" {
+		t.Errorf("lexicalText = %q, want the fragment body", got)
+	}
+	// The footer labels aren't searchable
+	l := newTestLexicalIndex(t, map[string]string{"a": r.lexicalText(content)})
+	for _, q := range []string{"source", "docid", "document metadata", "abc"} {
+		if got := l.search(q); len(got) != 0 {
+			t.Errorf("search(%q) = %v, want no hits", q, got)
+		}
+	}
+}
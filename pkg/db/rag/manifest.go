@@ -0,0 +1,149 @@
+package rag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/document/markdown"
+)
+
+// manifestFile is the name of the manifest within the DB folder; chromem ignores files at the top level.
+const manifestFile = "manifest.json"
+
+// manifestEntry records what the DB holds for a single file.
+type manifestEntry struct {
+	// Fragments holds the IDs of the file's fragments in the DB
+	Fragments []string  `json:"fragments"`
+	Hash      string    `json:"hash"` // sha256 of the file contents
+	ModTime   time.Time `json:"mod_time"`
+	Size      int64     `json:"size"`
+	Model     string    `json:"model"` // embedding model the fragments were embedded with
+	// Chunking identifies how the file was split into fragments (see markdown.Chunking.String)
+	Chunking string `json:"chunking,omitempty"`
+	// Transcluded maps the notes embedded into the file to their content hashes when it was indexed
+	Transcluded map[string]string `json:"transcluded,omitempty"`
+}
+
+// unchanged reports whether the entry still describes the file with the given stats, embedded with the given model
+// and split with the given chunking settings.
+func (e manifestEntry) unchanged(fi os.FileInfo, model, chunking string) bool {
+	return e.ModTime.Equal(fi.ModTime()) && e.Size == fi.Size() && e.Model == model && e.chunking() == chunking
+}
+
+// chunking returns how the file was split; entries that predate chunking settings were split the default way.
+func (e manifestEntry) chunking() string {
+	if e.Chunking == "" {
+		return markdown.DefaultChunking.String()
+	}
+	return e.Chunking
+}
+
+// contentHash identifies what the DB holds for the file: its fragments, embedded notes and all.
+func (e manifestEntry) contentHash() string {
+	return sha256Hash(strings.Join(e.Fragments, "\n"))
+}
+
+// manifest maps the relative path of every indexed file to its entry, so a file's fragments can be found without
+// scanning every ID in the DB, and unchanged files can be skipped without parsing them.
+type manifest struct {
+	path  string
+	mu    sync.Mutex
+	files map[string]manifestEntry
+	// saveMu keeps concurrent saves from renaming an older snapshot over a newer one
+	saveMu sync.Mutex
+}
+
+// loadManifest reads the manifest stored in dbPath.  A missing manifest yields an empty one.
+func loadManifest(dbPath string) (*manifest, error) {
+	m := &manifest{
+		path:  filepath.Join(dbPath, manifestFile),
+		files: make(map[string]manifestEntry),
+	}
+	b, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &m.files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", m.path, err)
+	}
+	return m, nil
+}
+
+func (m *manifest) get(relPath string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.files[relPath]
+	return e, ok
+}
+
+func (m *manifest) set(relPath string, e manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[relPath] = e
+}
+
+func (m *manifest) remove(relPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, relPath)
+}
+
+// reset forgets about every file.
+func (m *manifest) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.files)
+}
+
+// paths returns the relative paths of all files in the manifest.
+func (m *manifest) paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	paths := make([]string, 0, len(m.files))
+	for p := range m.files {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// save writes the manifest to disk.
+func (m *manifest) save() error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	m.mu.Lock()
+	b, err := json.Marshal(m.files)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, b)
+}
+
+// writeFileAtomic writes to a temp file and renames it into place, so a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
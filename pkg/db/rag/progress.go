@@ -0,0 +1,36 @@
+package rag
+
+import "time"
+
+// Progress describes how far along a sync of the document folder is.
+type Progress struct {
+	FilesTotal        int
+	FilesScanned      int
+	FragmentsEmbedded int
+	// Failures counts files that couldn't be loaded or embedded
+	Failures int
+	Started  time.Time
+	Done     bool
+}
+
+// ETA estimates the time left in the sync, based on the rate files have been scanned so far.
+// It returns zero until there's something to go on.
+func (p Progress) ETA() time.Duration {
+	if p.FilesScanned == 0 || p.Done {
+		return 0
+	}
+	elapsed := time.Since(p.Started)
+	perFile := elapsed / time.Duration(p.FilesScanned)
+	return perFile * time.Duration(p.FilesTotal-p.FilesScanned)
+}
+
+// Fraction returns the share of files scanned so far, between 0 and 1.
+func (p Progress) Fraction() float64 {
+	if p.FilesTotal == 0 {
+		if p.Done {
+			return 1
+		}
+		return 0
+	}
+	return float64(p.FilesScanned) / float64(p.FilesTotal)
+}
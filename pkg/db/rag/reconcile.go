@@ -0,0 +1,85 @@
+package rag
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ReconcileReport describes fragments in the DB whose files no longer exist on disk, e.g. because they were
+// deleted or renamed while nothing was watching.
+type ReconcileReport struct {
+	// Orphans maps the relative path of each missing file to the number of its fragments in the DB
+	Orphans map[string]int
+	// Fragments is the total number of orphaned fragments
+	Fragments int
+}
+
+// Paths returns the relative paths of the missing files, sorted.
+func (rr ReconcileReport) Paths() []string {
+	return slices.Sorted(maps.Keys(rr.Orphans))
+}
+
+// Reconcile compares the DB against the files under basePath matching filePattern and purges the fragments of files
+// that are gone.  With dryRun set, nothing is purged; the report shows what would be.
+func (r *ChromemRag) Reconcile(ctx context.Context, basePath, filePattern string, dryRun bool) (ReconcileReport, error) {
+	matches, err := findDocuments(basePath, filePattern)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+	return r.reconcile(ctx, basePath, matches, dryRun)
+}
+
+func (r *ChromemRag) reconcile(ctx context.Context, basePath string, paths []string, dryRun bool) (ReconcileReport, error) {
+	onDisk := make(map[string]struct{}, len(paths))
+	for _, p := range paths {
+		onDisk[p[len(basePath):]] = struct{}{}
+	}
+
+	report := ReconcileReport{Orphans: make(map[string]int)}
+	var orphanIds []string
+	for _, id := range r.collection().ListIDs(ctx) {
+		relPath := docPath(id)
+		if _, ok := onDisk[relPath]; ok {
+			continue
+		}
+		report.Orphans[relPath]++
+		report.Fragments++
+		orphanIds = append(orphanIds, id)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	err := r.deleteFragments(ctx, orphanIds)
+	if err != nil {
+		return report, err
+	}
+	// Forget about missing files, whether or not they had fragments
+	for _, relPath := range r.manifest.paths() {
+		if _, ok := onDisk[relPath]; !ok {
+			r.manifest.remove(relPath)
+		}
+	}
+	for _, relPath := range r.graph.paths() {
+		if _, ok := onDisk[relPath]; !ok {
+			r.graph.remove(relPath)
+		}
+	}
+	for _, relPath := range r.matter.paths() {
+		if _, ok := onDisk[relPath]; !ok {
+			r.matter.remove(relPath)
+		}
+	}
+	return report, r.save()
+}
+
+// docPath returns the relative path of the file a fragment ID (of the form "relPath|hash") belongs to.
+func docPath(id string) string {
+	i := strings.LastIndex(id, "|")
+	if i < 0 {
+		return id
+	}
+	return id[:i]
+}
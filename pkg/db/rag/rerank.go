@@ -0,0 +1,80 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Metadata keys recording how a reranked fragment was retrieved, so reranking can be tuned.
+const (
+	MetadataRetrievalRank  = "RetrievalRank"
+	MetadataRetrievalScore = "RetrievalScore"
+)
+
+// Reranker scores candidate fragments against a query, such as those in pkg/rerank.  Higher is more relevant; the
+// scores only need to be comparable within a single call.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, texts []string) ([]float64, error)
+}
+
+// WithReranker adds a rerank step to every query: the given number of candidates is retrieved, scored by the reranker
+// and only the best of them are returned.
+func WithReranker(reranker Reranker, candidates int) Option {
+	return func(r *ChromemRag) {
+		r.reranker = reranker
+		r.rerankCandidates = candidates
+	}
+}
+
+// rerank orders the candidates by their reranker score and keeps the best n.  Each one's score becomes its rerank
+// score, and its original rank and score are recorded in its metadata.  If the reranker fails, the candidates are
+// returned in their original order.
+func (r *ChromemRag) rerank(ctx context.Context, query string, candidates []schema.Document, n int) []schema.Document {
+	if len(candidates) == 0 {
+		return nil
+	}
+	texts := make([]string, len(candidates))
+	for i, d := range candidates {
+		texts[i] = d.PageContent
+	}
+	scores, err := r.reranker.Rerank(ctx, query, texts)
+	if err == nil && len(scores) != len(candidates) {
+		err = fmt.Errorf("asked for %d scores but got %d", len(candidates), len(scores))
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			r.Log(fmt.Sprintf("err: failed to rerank; using retrieval order: %v", err))
+		}
+		return candidates[:min(n, len(candidates))]
+	}
+
+	type ranked struct {
+		doc   schema.Document
+		score float64
+	}
+	res := make([]ranked, len(candidates))
+	for i, d := range candidates {
+		d.Metadata[MetadataRetrievalRank] = i + 1
+		d.Metadata[MetadataRetrievalScore] = d.Score
+		d.Score = float32(scores[i])
+		res[i] = ranked{doc: d, score: scores[i]}
+	}
+	// Stable, so ties keep their retrieval order
+	slices.SortStableFunc(res, func(a, b ranked) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		return 0
+	})
+	docs := make([]schema.Document, 0, n)
+	for _, rd := range res[:min(n, len(res))] {
+		docs = append(docs, rd.doc)
+	}
+	return docs
+}
@@ -0,0 +1,412 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/clocklear/chromem-go"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// RetrievalMode decides how fragments are found for a query.
+type RetrievalMode string
+
+const (
+	// HybridRetrieval fuses the vector and lexical rankings
+	HybridRetrieval RetrievalMode = "hybrid"
+	// VectorRetrieval ranks fragments by cosine similarity to the query alone
+	VectorRetrieval RetrievalMode = "vector"
+	// LexicalRetrieval ranks fragments by BM25 alone; handy for debugging retrieval
+	LexicalRetrieval RetrievalMode = "lexical"
+)
+
+// Valid reports whether m is a known retrieval mode.
+func (m RetrievalMode) Valid() bool {
+	return m == HybridRetrieval || m == VectorRetrieval || m == LexicalRetrieval
+}
+
+// Retrieval describes how a query is answered.  In hybrid mode, the vector and lexical rankings are combined with
+// reciprocal rank fusion, each weighted as given; a weight of zero ignores that ranking.
+type Retrieval struct {
+	Mode          RetrievalMode
+	VectorWeight  float64
+	LexicalWeight float64
+	// LinkHops and LinkBudget expand the results along wikilinks: up to LinkBudget fragments are added from the notes
+	// within LinkHops links of (or backlinks to) the retrieved ones.  Either being zero turns expansion off.
+	LinkHops   int
+	LinkBudget int
+	// Dates decides what the date range a query is about does (see QueryOptions.Dates); in boost mode, fragments of
+	// notes dated within it gain DateBoost times their score
+	Dates     DateMode
+	DateBoost float64
+	// RecencyHalfLife, unless zero, favors recent notes for queries without a date range: a fragment's score is scaled
+	// by 1 - RecencyWeight + RecencyWeight * 0.5^(age/RecencyHalfLife), where age is how old its note is
+	RecencyHalfLife time.Duration
+	RecencyWeight   float64
+}
+
+// DefaultRetrieval weighs the vector and lexical rankings equally, and only retrieves notes from the date range a
+// query is about.
+var DefaultRetrieval = Retrieval{Mode: HybridRetrieval, VectorWeight: 1, LexicalWeight: 1, Dates: DateFilter, DateBoost: 1, RecencyWeight: 0.5}
+
+// rrfK dampens the advantage of the very top ranks in reciprocal rank fusion; 60 is the value from the original paper.
+const rrfK = 60
+
+// rrfDepth is how many more candidates than requested each ranking contributes to the fusion, so fragments that rank
+// well in both can rise to the top.
+const rrfDepth = 4
+
+// WithRetrieval sets how queries are answered unless they say otherwise.  The default is DefaultRetrieval.
+func WithRetrieval(ret Retrieval) Option {
+	return func(r *ChromemRag) {
+		r.retrieval = ret
+	}
+}
+
+// QueryOptions describes a query.
+type QueryOptions struct {
+	Text string
+	// Queries are extra queries (e.g. sub-questions of Text) whose rankings are fused with Text's using reciprocal
+	// rank fusion.  Reranking is done against Text alone.
+	Queries []string
+	N       int
+	// Where filters fragments by metadata, WhereDocument by content ($contains and $not_contains)
+	Where         map[string]any
+	WhereDocument map[string]any
+	// Filter narrows the query down to notes by their tags, folder, date and frontmatter; see ParseFilter
+	Filter *Filter
+	// Dates is the date range the query is about (see ParseDates), which filters or boosts fragments as the
+	// retrieval settings say
+	Dates *DateRange
+	// Retrieval overrides the DB's retrieval settings for this query
+	Retrieval *Retrieval
+	// SkipRerank returns the retrieved fragments as they are, even if a reranker is set
+	SkipRerank bool
+}
+
+func (r *ChromemRag) Query(ctx context.Context, queryText string, nResults int, where, whereDocument map[string]any) ([]schema.Document, error) {
+	return r.QueryWithOptions(ctx, QueryOptions{
+		Text:          queryText,
+		N:             nResults,
+		Where:         where,
+		WhereDocument: whereDocument,
+	})
+}
+
+// QueryWithOptions returns the fragments most relevant to the query.  Scores are cosine similarities in vector mode,
+// BM25 scores in lexical mode and fused scores in hybrid mode, so they're only comparable within a mode.  With a
+// reranker, more candidates are retrieved and scored by the reranker instead; see WithReranker.  Scores are then
+// weighed by the dates of the notes, if a date boost or recency decay applies.  Fragments from linked notes follow the
+// results, if the retrieval settings ask for them.
+func (r *ChromemRag) QueryWithOptions(ctx context.Context, opts QueryOptions) ([]schema.Document, error) {
+	ret := r.retrieval
+	if opts.Retrieval != nil {
+		ret = *opts.Retrieval
+	}
+	if !ret.Mode.Valid() {
+		return nil, fmt.Errorf("unknown retrieval mode %q", ret.Mode)
+	}
+	if ret.Dates != "" && !ret.Dates.Valid() {
+		return nil, fmt.Errorf("unknown date mode %q", ret.Dates)
+	}
+	if opts.N <= 0 {
+		return nil, nil
+	}
+	if opts.Dates != nil && ret.Dates == DateFilter {
+		opts.Filter = withinDates(opts.Filter, *opts.Dates)
+	}
+	weighed := opts.Dates != nil && ret.Dates == DateBoost && ret.DateBoost > 0 ||
+		opts.Dates == nil && ret.RecencyHalfLife > 0 && ret.RecencyWeight > 0
+	n := opts.N
+	if weighed {
+		// Fragments further down may overtake the top ones once weighed
+		n *= rrfDepth
+	}
+	if r.reranker != nil && !opts.SkipRerank {
+		n = max(n, r.rerankCandidates)
+	}
+	var candidates []schema.Document
+	if len(opts.Queries) == 0 {
+		var err error
+		candidates, err = r.retrieve(ctx, opts, ret, n)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rankings := make([][]schema.Document, 0, len(opts.Queries)+1)
+		for _, q := range append([]string{opts.Text}, opts.Queries...) {
+			qopts := opts
+			qopts.Text = q
+			docs, err := r.retrieve(ctx, qopts, ret, n)
+			if err != nil {
+				return nil, err
+			}
+			rankings = append(rankings, docs)
+		}
+		candidates = fuseRankings(rankings, n)
+	}
+	keep := opts.N
+	if weighed {
+		keep = len(candidates)
+	}
+	var hits []schema.Document
+	if r.reranker == nil || opts.SkipRerank {
+		hits = candidates[:min(keep, len(candidates))]
+	} else {
+		hits = r.rerank(ctx, opts.Text, candidates, keep)
+	}
+	if weighed {
+		hits = r.weighDates(hits, ret, opts.Dates, time.Now())
+		hits = hits[:min(opts.N, len(hits))]
+	}
+	return r.expandLinks(ctx, opts, ret, hits)
+}
+
+// retrieve returns the n fragments ranked highest by the given retrieval settings.
+func (r *ChromemRag) retrieve(ctx context.Context, opts QueryOptions, ret Retrieval, n int) ([]schema.Document, error) {
+	// Convert the metadata maps
+	where := stringifyMetadata(opts.Where)
+	whereDocument := stringifyMetadata(opts.WhereDocument)
+	notes := r.filterNotes(opts.Filter)
+	if notes != nil && len(notes) == 0 {
+		return nil, nil
+	}
+
+	col := r.collection()
+	// There's no point asking for more fragments than there are; the count may change while querying, which
+	// queryEmbedding copes with
+	n = min(n, col.Count())
+	if n <= 0 {
+		return nil, nil
+	}
+
+	switch ret.Mode {
+	case VectorRetrieval:
+		res, err := r.vectorQuery(ctx, col, opts.Text, n, where, whereDocument, notes)
+		if err != nil {
+			return nil, err
+		}
+		docs := make([]schema.Document, 0, len(res))
+		for _, d := range res {
+			docs = append(docs, toSchemaDocument(d.Content, d.Metadata, d.Similarity))
+		}
+		return docs, nil
+	case LexicalRetrieval:
+		res := r.lexicalQuery(ctx, col, opts.Text, n, where, whereDocument, notes)
+		docs := make([]schema.Document, 0, len(res))
+		for _, h := range res {
+			docs = append(docs, toSchemaDocument(h.doc.Content, h.doc.Metadata, float32(h.score)))
+		}
+		return docs, nil
+	}
+
+	// Hybrid; skip whichever ranking doesn't count
+	depth := min(n*rrfDepth, col.Count())
+	hits := make(map[string]schema.Document)
+	scores := make(map[string]float64)
+	if ret.VectorWeight > 0 {
+		res, err := r.vectorQuery(ctx, col, opts.Text, depth, where, whereDocument, notes)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, len(res))
+		for i, d := range res {
+			ids[i] = d.ID
+			hits[d.ID] = toSchemaDocument(d.Content, d.Metadata, 0)
+		}
+		addRRFScores(scores, ids, ret.VectorWeight)
+	}
+	if ret.LexicalWeight > 0 {
+		res := r.lexicalQuery(ctx, col, opts.Text, depth, where, whereDocument, notes)
+		ids := make([]string, len(res))
+		for i, h := range res {
+			ids[i] = h.doc.ID
+			if _, ok := hits[h.doc.ID]; !ok {
+				hits[h.doc.ID] = toSchemaDocument(h.doc.Content, h.doc.Metadata, 0)
+			}
+		}
+		addRRFScores(scores, ids, ret.LexicalWeight)
+	}
+	return bestFused(hits, scores, n), nil
+}
+
+// fuseRankings combines the rankings of several queries with reciprocal rank fusion, keeping the best n.  Fragments
+// are told apart by their DocId.
+func fuseRankings(rankings [][]schema.Document, n int) []schema.Document {
+	hits := make(map[string]schema.Document)
+	scores := make(map[string]float64)
+	for _, ranking := range rankings {
+		ids := make([]string, len(ranking))
+		for i, d := range ranking {
+			ids[i] = fmt.Sprintf("%v", d.Metadata["DocId"])
+			if _, ok := hits[ids[i]]; !ok {
+				hits[ids[i]] = d
+			}
+		}
+		addRRFScores(scores, ids, 1)
+	}
+	return bestFused(hits, scores, n)
+}
+
+// addRRFScores adds the reciprocal rank fusion scores of a ranking (best first) to scores.
+func addRRFScores(scores map[string]float64, ranking []string, weight float64) {
+	for i, id := range ranking {
+		scores[id] += weight / float64(rrfK+i+1)
+	}
+}
+
+// bestFused returns the n hits with the highest fused scores, with their scores set to them.
+func bestFused(hits map[string]schema.Document, scores map[string]float64, n int) []schema.Document {
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		if scores[a] != scores[b] {
+			if scores[a] > scores[b] {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	docs := make([]schema.Document, 0, min(n, len(ids)))
+	for _, id := range ids[:min(n, len(ids))] {
+		d := hits[id]
+		d.Score = float32(scores[id])
+		docs = append(docs, d)
+	}
+	return docs
+}
+
+// scoredDoc is a fragment found by a lexical query.
+type scoredDoc struct {
+	doc   chromem.Document
+	score float64
+}
+
+// preFilterNotes is the most notes a filter can let through for them to be searched one by one, rather than searching
+// everything and dropping the fragments of other notes.
+const preFilterNotes = 32
+
+// vectorQuery returns the n fragments closest to the query embedding.  If notes isn't nil, only their fragments are
+// returned.
+func (r *ChromemRag) vectorQuery(ctx context.Context, col *chromem.Collection, text string, n int, where, whereDocument map[string]string, notes map[string]bool) ([]chromem.Result, error) {
+	embedding, err := r.embed(ctx, r.prompts.QueryPrefix+text)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create embedding of query: %w", err)
+	}
+	if notes == nil {
+		return queryEmbedding(ctx, col, embedding, n, where, whereDocument)
+	}
+	if len(notes) <= preFilterNotes {
+		// Filter before searching: search each note
+		var res []chromem.Result
+		for relPath := range notes {
+			noteWhere := maps.Clone(where)
+			noteWhere["Source"] = relPath
+			hits, err := queryEmbedding(ctx, col, embedding, n, noteWhere, whereDocument)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, hits...)
+		}
+		slices.SortFunc(res, func(a, b chromem.Result) int {
+			return cmp.Or(cmp.Compare(b.Similarity, a.Similarity), strings.Compare(a.ID, b.ID))
+		})
+		return res[:min(n, len(res))], nil
+	}
+	// Filter after searching, widening the search until enough of the results are in the notes
+	for depth := n; ; depth *= 4 {
+		hits, err := queryEmbedding(ctx, col, embedding, depth, where, whereDocument)
+		if err != nil {
+			return nil, err
+		}
+		res := slices.DeleteFunc(hits, func(d chromem.Result) bool { return !notes[docPath(d.ID)] })
+		if len(res) >= n || depth >= col.Count() {
+			return res[:min(n, len(res))], nil
+		}
+	}
+}
+
+// errTooManyResults is the start of the error chromem returns when asked for more results than it holds documents.
+const errTooManyResults = "nResults must be <="
+
+// queryEmbedding returns the n fragments of col closest to embedding, or all of them if there are fewer.  chromem
+// refuses to return more results than there are documents, and notes indexed in the background can shrink the
+// collection between counting and querying, so a query that loses that race is tried again with the new count.
+func queryEmbedding(ctx context.Context, col *chromem.Collection, embedding []float32, n int, where, whereDocument map[string]string) ([]chromem.Result, error) {
+	for {
+		count := col.Count()
+		if min(n, count) <= 0 {
+			return nil, nil
+		}
+		res, err := col.QueryEmbedding(ctx, embedding, min(n, count), where, whereDocument)
+		if err != nil && strings.HasPrefix(err.Error(), errTooManyResults) && col.Count() < count {
+			continue
+		}
+		return res, err
+	}
+}
+
+// lexicalQuery returns the n best BM25 matches for the query that pass the filters.  If notes isn't nil, only their
+// fragments are returned.
+func (r *ChromemRag) lexicalQuery(ctx context.Context, col *chromem.Collection, text string, n int, where, whereDocument map[string]string, notes map[string]bool) []scoredDoc {
+	var res []scoredDoc
+	for _, h := range r.lexical.search(text) {
+		if len(res) == n {
+			break
+		}
+		if notes != nil && !notes[docPath(h.ID)] {
+			continue
+		}
+		d, err := col.GetByID(ctx, h.ID)
+		if err != nil || !matchesFilters(d, where, whereDocument) {
+			// Either filtered out or deleted since the search
+			continue
+		}
+		res = append(res, scoredDoc{doc: d, score: h.Score})
+	}
+	return res
+}
+
+// matchesFilters applies chromem's where and whereDocument filters to a fragment.
+func matchesFilters(d chromem.Document, where, whereDocument map[string]string) bool {
+	for k, v := range where {
+		if d.Metadata[k] != v {
+			return false
+		}
+	}
+	for k, v := range whereDocument {
+		switch k {
+		case "$contains":
+			if !strings.Contains(d.Content, v) {
+				return false
+			}
+		case "$not_contains":
+			if strings.Contains(d.Content, v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// toSchemaDocument converts a chromem fragment into a schema.Document.
+func toSchemaDocument(content string, md map[string]string, score float32) schema.Document {
+	// Convert metadata into a map[string]any
+	metadata := make(map[string]any)
+	for k, v := range md {
+		metadata[k] = v
+	}
+	return schema.Document{
+		PageContent: content,
+		Metadata:    metadata,
+		Score:       score,
+	}
+}
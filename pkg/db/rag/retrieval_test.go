@@ -0,0 +1,190 @@
+package rag
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/clocklear/chromem-go"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// newTestRag returns a DB holding the given fragments, each embedded as given.  Queries are embedded as [1, 0].
+func newTestRag(t *testing.T, fragments map[string]string, embeddings map[string][]float32) *ChromemRag {
+	t.Helper()
+	embed := func(ctx context.Context, text string) ([]float32, error) {
+		return []float32{1, 0}, nil
+	}
+	col, err := chromem.NewDB().CreateCollection("test", nil, embed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &ChromemRag{col: col, embed: embed, lexical: newTestLexicalIndex(t, fragments), retrieval: DefaultRetrieval}
+	for id, content := range fragments {
+		d := chromem.Document{ID: id, Content: content, Embedding: embeddings[id], Metadata: map[string]string{"DocId": id}}
+		if err := col.AddDocument(context.Background(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func rankedDocs(ids ...string) []schema.Document {
+	docs := make([]schema.Document, len(ids))
+	for i, id := range ids {
+		docs[i] = schema.Document{PageContent: "fragment " + id, Metadata: map[string]any{"DocId": id}}
+	}
+	return docs
+}
+
+func docIDs(docs []schema.Document) []string {
+	ids := make([]string, len(docs))
+	for i, d := range docs {
+		ids[i] = d.Metadata["DocId"].(string)
+	}
+	return ids
+}
+
+func TestQueryWithOptions(t *testing.T) {
+	// By vector, a is closest to the query, then b, d and c; only b and c mention apples
+	r := newTestRag(t, map[string]string{
+		"a": "pears and plums",
+		"b": "apple pie with pears",
+		"c": "apple sauce, apple juice",
+		"d": "pears and cream",
+	}, map[string][]float32{
+		"a": {1, 0},
+		"b": {0.8, 0.6},
+		"c": {0, 1},
+		"d": {0.6, 0.8},
+	})
+
+	tests := []struct {
+		name string
+		ret  Retrieval
+		n    int
+		want []string
+	}{
+		{"vector", Retrieval{Mode: VectorRetrieval}, 3, []string{"a", "b", "d"}},
+		{"lexical", Retrieval{Mode: LexicalRetrieval}, 3, []string{"c", "b"}},
+		// b and c are found by both rankings, so they overtake a, which only the vector ranking found
+		{"hybrid", DefaultRetrieval, 2, []string{"b", "c"}},
+		{"hybrid without lexical", Retrieval{Mode: HybridRetrieval, VectorWeight: 1}, 3, []string{"a", "b", "d"}},
+		{"hybrid without vector", Retrieval{Mode: HybridRetrieval, LexicalWeight: 1}, 3, []string{"c", "b"}},
+		{"more than there are", DefaultRetrieval, 10, []string{"b", "c", "a", "d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := r.QueryWithOptions(context.Background(), QueryOptions{Text: "apple", N: tt.n, Retrieval: &tt.ret})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := docIDs(docs); !slices.Equal(ids, tt.want) {
+				t.Errorf("query = %q, want %q", ids, tt.want)
+			}
+		})
+	}
+
+	if _, err := r.QueryWithOptions(context.Background(), QueryOptions{Text: "apple", N: 1, Retrieval: &Retrieval{Mode: "fuzzy"}}); err == nil {
+		t.Error("unknown mode: err = nil, want an error")
+	}
+}
+
+func TestFuseRankings(t *testing.T) {
+	tests := []struct {
+		name     string
+		rankings [][]schema.Document
+		n        int
+		want     []string
+	}{
+		{
+			name:     "a single ranking keeps its order",
+			rankings: [][]schema.Document{rankedDocs("a", "b", "c")},
+			n:        3,
+			want:     []string{"a", "b", "c"},
+		},
+		{
+			name:     "fragments found by both rankings come first",
+			rankings: [][]schema.Document{rankedDocs("a", "b", "c"), rankedDocs("c", "d", "b")},
+			n:        4,
+			want:     []string{"c", "b", "a", "d"},
+		},
+		{
+			name:     "ties are broken by ID",
+			rankings: [][]schema.Document{rankedDocs("b", "x"), rankedDocs("a", "y")},
+			n:        2,
+			want:     []string{"a", "b"},
+		},
+		{
+			name:     "only the best n are kept",
+			rankings: [][]schema.Document{rankedDocs("a", "b", "c"), rankedDocs("b", "a")},
+			n:        1,
+			want:     []string{"a"},
+		},
+		{
+			name:     "no rankings",
+			rankings: nil,
+			n:        3,
+			want:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fuseRankings(tt.rankings, tt.n)
+			if ids := docIDs(got); !slices.Equal(ids, tt.want) {
+				t.Errorf("fused = %q, want %q", ids, tt.want)
+			}
+			for i := 1; i < len(got); i++ {
+				if got[i].Score > got[i-1].Score {
+					t.Errorf("scores aren't descending: %v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestAddRRFScores(t *testing.T) {
+	scores := make(map[string]float64)
+	addRRFScores(scores, []string{"a", "b"}, 1)
+	addRRFScores(scores, []string{"b"}, 0.5)
+	want := map[string]float64{
+		"a": 1.0 / (rrfK + 1),
+		"b": 1.0/(rrfK+2) + 0.5/(rrfK+1),
+	}
+	for id, w := range want {
+		if scores[id] != w {
+			t.Errorf("score of %s = %v, want %v", id, scores[id], w)
+		}
+	}
+	// A weighted ranking can lift a fragment it ranks lower
+	if scores["b"] <= scores["a"] {
+		t.Errorf("b (%v) should outscore a (%v)", scores["b"], scores["a"])
+	}
+}
+
+func TestQueryEmbedding(t *testing.T) {
+	embed := func(ctx context.Context, text string) ([]float32, error) {
+		return []float32{1, 0}, nil
+	}
+	col, err := chromem.NewDB().CreateCollection("test", nil, embed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := []float32{1, 0}
+
+	// An empty collection has nothing to return, rather than an error
+	if res, err := queryEmbedding(context.Background(), col, query, 3, nil, nil); err != nil || len(res) != 0 {
+		t.Errorf("query of empty collection = %v, %v; want nothing", res, err)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		if err := col.AddDocument(context.Background(), chromem.Document{ID: id, Content: id, Embedding: query}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Asking for more than there are returns them all
+	res, err := queryEmbedding(context.Background(), col, query, 5, nil, nil)
+	if err != nil || len(res) != 2 {
+		t.Errorf("query for 5 of 2 = %d results, %v; want both", len(res), err)
+	}
+}
@@ -0,0 +1,349 @@
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/clocklear/texttrove/pkg/tokens"
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+// ChunkStrategy decides how notes are split into fragments.
+type ChunkStrategy string
+
+const (
+	// MarkdownChunks packs paragraphs, list items and table rows into fragments with langchaingo's markdown splitter;
+	// code blocks are left out
+	MarkdownChunks ChunkStrategy = "markdown"
+	// HeadingChunks makes a fragment of each heading section, splitting sections over the chunk size as BlockChunks
+	// does
+	HeadingChunks ChunkStrategy = "heading"
+	// SentenceChunks makes fragments of consecutive sentences, each window overlapping the one before
+	SentenceChunks ChunkStrategy = "sentence"
+	// BlockChunks packs whole paragraphs, lists, code blocks and tables into fragments
+	BlockChunks ChunkStrategy = "blocks"
+)
+
+// Valid reports whether s is a known chunking strategy.
+func (s ChunkStrategy) Valid() bool {
+	return s == MarkdownChunks || s == HeadingChunks || s == SentenceChunks || s == BlockChunks
+}
+
+// ChunkUnits is what chunk sizes are measured in.
+type ChunkUnits string
+
+const (
+	CharUnits  ChunkUnits = "chars"
+	TokenUnits ChunkUnits = "tokens"
+)
+
+// Valid reports whether u is a known unit.
+func (u ChunkUnits) Valid() bool {
+	return u == CharUnits || u == TokenUnits
+}
+
+// Chunking describes how notes are split into fragments of up to Size characters or tokens, with consecutive
+// fragments of a section sharing up to Overlap of them.  Apart from the markdown strategy, fragments don't span
+// headings and start with the headings they're under, and fenced code blocks and tables are never cut in half, even
+// if that makes a fragment bigger than Size.
+type Chunking struct {
+	Strategy ChunkStrategy
+	Size     int
+	Overlap  int
+	Units    ChunkUnits
+	// Tokens estimates token counts for TokenUnits; nil uses the estimate for an unknown model
+	Tokens tokens.Estimator
+}
+
+// DefaultChunking is how notes have always been split.
+var DefaultChunking = Chunking{Strategy: MarkdownChunks, Size: 300, Overlap: 32, Units: CharUnits}
+
+// String identifies the settings, e.g. markdown/300/32/chars, so notes can be split again when they change.
+func (c Chunking) String() string {
+	return fmt.Sprintf("%s/%d/%d/%s", c.Strategy, c.Size, c.Overlap, c.Units)
+}
+
+// WithChunking sets how the note is split into fragments.  The default is DefaultChunking.
+func WithChunking(c Chunking) LoadOption {
+	return func(o *loadOptions) {
+		o.chunking = c
+	}
+}
+
+// length returns the function measuring text in the chunking units.
+func (c Chunking) length() func(string) int {
+	if c.Units != TokenUnits {
+		return utf8.RuneCountInString
+	}
+	if c.Tokens == nil {
+		return tokens.ForModel("")
+	}
+	return c.Tokens
+}
+
+// splitter returns the text splitter for text.
+func (c Chunking) splitter(text string) textsplitter.TextSplitter {
+	if c.Strategy != MarkdownChunks && c.Strategy != "" {
+		return chunker{c}
+	}
+	size, overlap := c.Size, c.Overlap
+	if c.Units == TokenUnits {
+		// The markdown splitter only counts characters, so convert at the note's own rate
+		ratio := float64(utf8.RuneCountInString(text)) / float64(max(c.length()(text), 1))
+		size, overlap = int(float64(size)*ratio), int(float64(overlap)*ratio)
+	}
+	return textsplitter.NewMarkdownTextSplitter(textsplitter.WithChunkSize(size), textsplitter.WithChunkOverlap(overlap), textsplitter.WithHeadingHierarchy(true))
+}
+
+// chunker splits notes by heading section, sentence window or block.
+type chunker struct {
+	Chunking
+}
+
+// section is the text under a heading, up to the next one.
+type section struct {
+	// headings are the heading lines the section is under, outermost first
+	headings []string
+	blocks   []block
+}
+
+// block is a paragraph, list, fenced code block or table.
+type block struct {
+	text string
+	// atomic blocks (code and tables) are never split
+	atomic bool
+}
+
+// unit is the smallest piece of text a chunk is made of, along with what separates it from the one before.
+type unit struct {
+	text, sep string
+	atomic    bool
+}
+
+// sentenceEndPattern matches the end of a sentence and the space after it
+var sentenceEndPattern = regexp.MustCompile(`[.!?]["')\]*_]*\s+`)
+
+func (c chunker) SplitText(text string) ([]string, error) {
+	length := c.length()
+	var chunks []string
+	for _, s := range parseSections(text) {
+		if len(s.blocks) == 0 {
+			chunks = append(chunks, strings.Join(s.headings, "\n"))
+			continue
+		}
+		var units []unit
+		switch c.Strategy {
+		case SentenceChunks:
+			units = sentenceUnits(s.blocks)
+		case HeadingChunks:
+			units = blockUnits(s.blocks)
+			if sumLength(units, length) <= c.Size {
+				// The section fits in a single fragment
+				units = []unit{{text: joinUnits(units)}}
+			} else {
+				units = c.splitOversized(units, length)
+			}
+		default:
+			units = c.splitOversized(blockUnits(s.blocks), length)
+		}
+		for _, body := range pack(units, c.Size, c.Overlap, length) {
+			if len(s.headings) > 0 {
+				body = strings.Join(s.headings, "\n") + "\n" + body
+			}
+			chunks = append(chunks, body)
+		}
+	}
+	return chunks, nil
+}
+
+// splitOversized splits the text blocks bigger than the chunk size into sentences (which pack will join back up to
+// the size), and the sentences bigger than that into words.
+func (c chunker) splitOversized(units []unit, length func(string) int) []unit {
+	var res []unit
+	for _, u := range units {
+		if u.atomic || length(u.text) <= c.Size {
+			res = append(res, u)
+			continue
+		}
+		for i, s := range sentenceUnits([]block{{text: u.text}}) {
+			if i == 0 {
+				s.sep = u.sep
+			}
+			if length(s.text) <= c.Size {
+				res = append(res, s)
+				continue
+			}
+			for j, w := range strings.Fields(s.text) {
+				sep := " "
+				if j == 0 {
+					sep = s.sep
+				}
+				res = append(res, unit{text: w, sep: sep})
+			}
+		}
+	}
+	return res
+}
+
+// parseSections splits markdown into heading sections made of blocks.
+func parseSections(text string) []section {
+	var sections []section
+	cur := section{}
+	var lines []string
+	// kind is what the block being read is: a paragraph, a fence (with its marker) or a table
+	kind, fence := "", ""
+	endBlock := func() {
+		if len(lines) > 0 {
+			cur.blocks = append(cur.blocks, block{text: strings.Join(lines, "\n"), atomic: kind != "text"})
+		}
+		lines, kind, fence = nil, "", ""
+	}
+	type heading struct {
+		level int
+		line  string
+	}
+	var stack []heading
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case kind == "fence":
+			lines = append(lines, line)
+			if strings.HasPrefix(trimmed, fence) {
+				endBlock()
+			}
+			continue
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			endBlock()
+			kind, fence = "fence", trimmed[:3]
+			lines = append(lines, line)
+			continue
+		}
+		if m := sectionHeadingPattern.FindStringSubmatch(line); m != nil {
+			endBlock()
+			if len(cur.blocks) > 0 {
+				sections = append(sections, cur)
+			}
+			for len(stack) > 0 && stack[len(stack)-1].level >= len(m[1]) {
+				stack = stack[:len(stack)-1]
+			}
+			stack = append(stack, heading{level: len(m[1]), line: strings.TrimSpace(line)})
+			cur = section{}
+			for _, h := range stack {
+				cur.headings = append(cur.headings, h.line)
+			}
+			continue
+		}
+		switch {
+		case trimmed == "":
+			endBlock()
+		case strings.HasPrefix(trimmed, "|"):
+			if kind != "table" {
+				endBlock()
+				kind = "table"
+			}
+			lines = append(lines, line)
+		default:
+			if kind == "table" {
+				endBlock()
+			}
+			kind = "text"
+			lines = append(lines, line)
+		}
+	}
+	endBlock()
+	if len(cur.blocks) > 0 || len(sections) == 0 && len(cur.headings) > 0 {
+		// A note of nothing but headings is still worth a fragment
+		sections = append(sections, cur)
+	}
+	return sections
+}
+
+// blockUnits makes a unit of each block.
+func blockUnits(blocks []block) []unit {
+	units := make([]unit, len(blocks))
+	for i, b := range blocks {
+		units[i] = unit{text: b.text, sep: "\n\n", atomic: b.atomic}
+	}
+	return units
+}
+
+// sentenceUnits makes a unit of each sentence (or list item) of the text blocks, and of each code block and table.
+func sentenceUnits(blocks []block) []unit {
+	var units []unit
+	for _, b := range blocks {
+		if b.atomic {
+			units = append(units, unit{text: b.text, sep: "\n\n", atomic: true})
+			continue
+		}
+		sep := "\n\n"
+		for _, line := range strings.Split(b.text, "\n") {
+			if listItemPattern.MatchString(line) {
+				units = append(units, unit{text: line, sep: sep})
+				sep = "\n"
+				continue
+			}
+			last := 0
+			for _, loc := range sentenceEndPattern.FindAllStringIndex(line, -1) {
+				units = append(units, unit{text: strings.TrimSpace(line[last:loc[1]]), sep: sep})
+				sep, last = " ", loc[1]
+			}
+			if rest := strings.TrimSpace(line[last:]); rest != "" {
+				units = append(units, unit{text: rest, sep: sep})
+			}
+			sep = "\n"
+		}
+	}
+	return units
+}
+
+// pack joins consecutive units into chunks of up to size, each starting with the last units of the one before that
+// fit in overlap.  A unit bigger than size makes a chunk of its own.
+func pack(units []unit, size, overlap int, length func(string) int) []string {
+	var chunks []string
+	for start := 0; start < len(units); {
+		end, n := start, 0
+		for end < len(units) {
+			l := length(units[end].text)
+			if end > start && n+l > size {
+				break
+			}
+			n += l
+			end++
+		}
+		chunks = append(chunks, joinUnits(units[start:end]))
+		if end == len(units) {
+			break
+		}
+		// Always move forward, even if the overlap would take in the whole chunk
+		next, shared := end, 0
+		for next-1 > start && shared+length(units[next-1].text) <= overlap {
+			next--
+			shared += length(units[next].text)
+		}
+		start = next
+	}
+	return chunks
+}
+
+// joinUnits joins units with the separators between them.
+func joinUnits(units []unit) string {
+	var sb strings.Builder
+	for i, u := range units {
+		if i > 0 {
+			sb.WriteString(u.sep)
+		}
+		sb.WriteString(u.text)
+	}
+	return sb.String()
+}
+
+// sumLength returns the total length of the units.
+func sumLength(units []unit, length func(string) int) int {
+	n := 0
+	for _, u := range units {
+		n += length(u.text)
+	}
+	return n
+}
@@ -0,0 +1,68 @@
+package markdown
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MetadataDate is the metadata key of the note's date (YYYY-MM-DD); see NoteDate.
+const MetadataDate = "Date"
+
+var (
+	// dailyNamePattern matches a date in a file name, e.g. 2024-03-01.md, 2024_03_01 Friday.md or 20240301.md
+	dailyNamePattern = regexp.MustCompile(`(?:^|[^\d])(\d{4})([-_.]?)(\d{2})([-_.]?)(\d{2})(?:[^\d]|$)`)
+	// dailyPathPattern matches a date spread over folders, e.g. journal/2024/03/01.md or journal/2024/03/2024-03-01.md
+	dailyPathPattern = regexp.MustCompile(`(?:^|/)(\d{4})/(\d{2})/(\d{2})(?:[^\d][^/]*)?\.md$`)
+)
+
+// NoteDate returns the date of the note at relPath: its date or created frontmatter field, the date in its name (as
+// daily notes have), or otherwise modTime, when the file was last modified.
+func NoteDate(relPath string, matter map[string]any, modTime time.Time) (time.Time, bool) {
+	for _, field := range []string{"date", "created"} {
+		for k, v := range matter {
+			if !strings.EqualFold(k, field) {
+				continue
+			}
+			if t, ok := v.(time.Time); ok {
+				return t, true
+			}
+			if t, ok := ParseDate(fmt.Sprint(v)); ok {
+				return t, true
+			}
+		}
+	}
+	if t, ok := nameDate(relPath); ok {
+		return t, true
+	}
+	return modTime, !modTime.IsZero()
+}
+
+// nameDate returns the date in the name of the note at relPath, or the folders it's in.
+func nameDate(relPath string) (time.Time, bool) {
+	if m := dailyNamePattern.FindStringSubmatch(path.Base(relPath)); m != nil && m[2] == m[4] {
+		if t, err := time.ParseInLocation(time.DateOnly, m[1]+"-"+m[3]+"-"+m[5], time.Local); err == nil {
+			return t, true
+		}
+	}
+	if m := dailyPathPattern.FindStringSubmatch(relPath); m != nil {
+		if t, err := time.ParseInLocation(time.DateOnly, m[1]+"-"+m[2]+"-"+m[3], time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseDate reads a date as it's commonly written in frontmatter and queries: 2024-03-01, 2024-03-01 10:00 or an RFC
+// 3339 timestamp.
+func ParseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.DateOnly, time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006/01/02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -3,33 +3,97 @@ package markdown
 import (
 	"bytes"
 	"context"
+	"maps"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/adrg/frontmatter"
 	"github.com/tmc/langchaingo/schema"
 	"github.com/tmc/langchaingo/textsplitter"
 )
 
+// Note is a markdown file split into fragments, along with what it links to.
+type Note struct {
+	Fragments []schema.Document
+	// Links holds the links, embeds and tags of the whole note, including the tags in its frontmatter
+	Links Links
+	// Aliases are the other names the note goes by (the aliases field of its frontmatter), which links may use
+	Aliases []string
+	// Frontmatter holds the note's frontmatter as parsed, with lists, numbers and nested fields intact
+	Frontmatter map[string]any
+	// Transcluded lists the notes embedded into this one, including those embedded into them; see WithEmbeds
+	Transcluded []string
+	// Date is the note's date; see NoteDate
+	Date time.Time
+}
+
 // Load converts a markdown file into a slice of schema.Document.
-func Load(ctx context.Context, basePath, relPath string) ([]schema.Document, error) {
+func Load(ctx context.Context, basePath, relPath string, opts ...LoadOption) ([]schema.Document, error) {
+	n, err := LoadNote(ctx, basePath, relPath, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return n.Fragments, nil
+}
+
+// LoadNote splits a markdown file into fragments and finds its links.  Each fragment's own links, embeds and tags
+// are recorded in its metadata (see MetadataLinks), along with the note's date (see MetadataDate).
+func LoadNote(ctx context.Context, basePath, relPath string, opts ...LoadOption) (Note, error) {
+	o := loadOptions{chunking: DefaultChunking}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	// Read the contents of path into a string.
 	contents, err := os.ReadFile(path.Join(basePath, relPath))
 	if err != nil {
-		return nil, err
+		return Note{}, err
+	}
+	fi, err := os.Stat(path.Join(basePath, relPath))
+	if err != nil {
+		return Note{}, err
 	}
 
 	// Parse any potential frontmatter
 	matter := make(map[string]any)
 	rest, err := frontmatter.Parse(bytes.NewReader(contents), &matter)
 	if err != nil {
-		return nil, err
+		return Note{}, err
+	}
+	n := Note{
+		Links:       Links{Tags: frontmatterList(matter, "tags")},
+		Aliases:     frontmatterList(matter, "aliases"),
+		Frontmatter: maps.Clone(matter),
+	}
+	if date, ok := NoteDate(relPath, matter, fi.ModTime()); ok {
+		n.Date = date
+		matter[MetadataDate] = date.Format(time.DateOnly)
+	}
+	// The note's own links; embedded notes have theirs
+	n.Links.merge(ParseLinks(string(rest)))
+
+	text := string(rest)
+	if o.resolver != nil {
+		t := &transcluder{basePath: basePath, resolver: o.resolver}
+		text = t.transclude(relPath, text, o.embedDepth, nil)
+		n.Transcluded = t.sources
+	}
+	if len(n.Transcluded) > 0 {
+		matter[MetadataTranscluded] = strings.Join(n.Transcluded, ", ")
 	}
 
 	// Add relative path elements as metadata for context
 	matter["Source"] = relPath
 
 	// Parse (split) the markdown file into a slice of schema.Document.
-	splitter := textsplitter.NewMarkdownTextSplitter(textsplitter.WithChunkSize(300), textsplitter.WithChunkOverlap(32), textsplitter.WithHeadingHierarchy(true))
-	return textsplitter.CreateDocuments(splitter, []string{string(rest)}, []map[string]any{matter})
+	n.Fragments, err = textsplitter.CreateDocuments(o.chunking.splitter(text), []string{text}, []map[string]any{matter})
+	if err != nil {
+		return Note{}, err
+	}
+	for _, d := range n.Fragments {
+		ParseLinks(d.PageContent).metadata(d.Metadata)
+	}
+	return n, nil
 }
@@ -0,0 +1,137 @@
+package markdown
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Metadata keys of the links found in a fragment; values are comma-separated lists.
+const (
+	MetadataLinks  = "Links"
+	MetadataEmbeds = "Embeds"
+	MetadataTags   = "Tags"
+)
+
+var (
+	// wikilinkPattern matches [[target]], [[target#heading]], [[target|alias]] and, with the leading !, ![[embeds]]
+	wikilinkPattern = regexp.MustCompile(`(!?)\[\[([^\[\]]+?)\]\]`)
+	// mdLinkPattern matches markdown links to local notes, e.g. [plan](projects/plan.md)
+	mdLinkPattern = regexp.MustCompile(`(!?)\[[^\]]*\]\(([^)\s]+\.md)(?:#[^)]*)?\)`)
+	// tagPattern matches #tags; they need a letter, so #123 (e.g. an issue number) isn't one
+	tagPattern = regexp.MustCompile(`(?m)(?:^|[\s(])#([\p{L}\p{N}_/\-]*\p{L}[\p{L}\p{N}_/\-]*)`)
+	// fencePattern and inlineCodePattern match code, which is left alone
+	fencePattern      = regexp.MustCompile("(?ms)^\\s*(```|~~~).*?^\\s*(```|~~~)")
+	inlineCodePattern = regexp.MustCompile("`[^`\n]*`")
+	// headingLinePattern matches headings, whose # isn't a tag
+	headingLinePattern = regexp.MustCompile(`(?m)^#{1,6}\s`)
+)
+
+// Links holds what a note (or a fragment of one) links to, in the order first seen and without duplicates.
+type Links struct {
+	// Links are the targets of [[wikilinks]] and markdown links to notes, without headings, block references or
+	// aliases; they're resolved against the vault later
+	Links []string
+	// Embeds are the targets of ![[embeds]]
+	Embeds []string
+	// Tags are the #tags, without the #
+	Tags []string
+}
+
+// ParseLinks finds the links, embeds and tags in markdown text.  Code blocks and inline code are skipped.
+func ParseLinks(text string) Links {
+	text = fencePattern.ReplaceAllString(text, "")
+	text = inlineCodePattern.ReplaceAllString(text, "")
+
+	var l Links
+	for _, m := range wikilinkPattern.FindAllStringSubmatch(text, -1) {
+		target := linkTarget(m[2])
+		if target == "" {
+			// e.g. [[#heading]], a link within the note
+			continue
+		}
+		if m[1] == "!" {
+			l.Embeds = appendUnique(l.Embeds, target)
+		} else {
+			l.Links = appendUnique(l.Links, target)
+		}
+	}
+	for _, m := range mdLinkPattern.FindAllStringSubmatch(text, -1) {
+		target, err := url.PathUnescape(m[2])
+		if err != nil || strings.Contains(target, "://") {
+			continue
+		}
+		if m[1] == "!" {
+			l.Embeds = appendUnique(l.Embeds, target)
+		} else {
+			l.Links = appendUnique(l.Links, target)
+		}
+	}
+	text = headingLinePattern.ReplaceAllString(text, "")
+	for _, m := range tagPattern.FindAllStringSubmatch(text, -1) {
+		l.Tags = appendUnique(l.Tags, m[1])
+	}
+	return l
+}
+
+// linkTarget strips the heading, block reference and alias off the inside of a wikilink.
+func linkTarget(s string) string {
+	if i := strings.IndexAny(s, "|#^"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// merge adds the links of o to l.
+func (l *Links) merge(o Links) {
+	for _, t := range o.Links {
+		l.Links = appendUnique(l.Links, t)
+	}
+	for _, t := range o.Embeds {
+		l.Embeds = appendUnique(l.Embeds, t)
+	}
+	for _, t := range o.Tags {
+		l.Tags = appendUnique(l.Tags, t)
+	}
+}
+
+// metadata records the links in fragment metadata.
+func (l Links) metadata(md map[string]any) {
+	for k, v := range map[string][]string{MetadataLinks: l.Links, MetadataEmbeds: l.Embeds, MetadataTags: l.Tags} {
+		if len(v) > 0 {
+			md[k] = strings.Join(v, ", ")
+		}
+	}
+}
+
+// frontmatterList reads a frontmatter field that may be a single value or a list, such as tags or aliases.
+func frontmatterList(matter map[string]any, key string) []string {
+	var values []string
+	switch v := matter[key].(type) {
+	case string:
+		// Tags may also be written as "a, b" or "a b"
+		for _, s := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || (key == "tags" && r == ' ') }) {
+			values = append(values, strings.TrimSpace(s))
+		}
+	case []any:
+		for _, s := range v {
+			values = append(values, strings.TrimSpace(fmt.Sprint(s)))
+		}
+	}
+	values = slices.DeleteFunc(values, func(s string) bool { return s == "" })
+	if key == "tags" {
+		for i, t := range values {
+			values[i] = strings.TrimPrefix(t, "#")
+		}
+	}
+	return values
+}
+
+func appendUnique(list []string, s string) []string {
+	if slices.Contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
@@ -0,0 +1,269 @@
+package markdown
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/adrg/frontmatter"
+)
+
+// MetadataTranscluded is the metadata key of the notes whose content was embedded into a note (comma-separated).
+const MetadataTranscluded = "Transcluded"
+
+// DefaultEmbedDepth is how deep embeds within embedded notes are resolved by default.
+const DefaultEmbedDepth = 3
+
+var (
+	// embedPattern matches ![[embeds]], capturing the target (note, heading and block reference, without the alias)
+	embedPattern = regexp.MustCompile(`!\[\[([^\[\]|]+)(?:\|[^\[\]]*)?\]\]`)
+	// sectionHeadingPattern matches a heading, capturing its level and text
+	sectionHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	// blockIdPattern matches the block ID at the end of a line, e.g. "Some paragraph ^summary"
+	blockIdPattern = regexp.MustCompile(`(?:^|\s)\^([\w-]+)\s*$`)
+	// listItemPattern matches a list item
+	listItemPattern = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s`)
+)
+
+// Resolver finds the note a link in the note at from points to, returning its relative path.
+type Resolver interface {
+	Resolve(from, target string) (string, bool)
+}
+
+// LoadOption configures how a note is loaded.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	resolver   Resolver
+	embedDepth int
+	chunking   Chunking
+}
+
+// WithEmbeds resolves ![[embeds]] of other notes (whole notes, heading sections or block references) with the given
+// resolver, replacing them with the embedded content so it's indexed along with the note.  Embeds within embedded
+// notes are resolved up to depth levels deep; embeds beyond that, of missing notes or forming a cycle are left as
+// they are.
+func WithEmbeds(resolver Resolver, depth int) LoadOption {
+	return func(o *loadOptions) {
+		o.resolver = resolver
+		o.embedDepth = depth
+	}
+}
+
+// transcluder replaces embeds with the content they refer to, remembering the notes it embedded.
+type transcluder struct {
+	basePath string
+	resolver Resolver
+	// sources lists the notes embedded so far, in the order first seen
+	sources []string
+}
+
+// transclude resolves the embeds in text, which belongs to the note at relPath.  stack holds the notes being
+// embedded into, to detect cycles.
+func (t *transcluder) transclude(relPath, text string, depth int, stack []string) string {
+	if depth <= 0 {
+		return text
+	}
+	stack = append(stack, relPath)
+	// Embeds in code are left alone
+	code := fencePattern.FindAllStringIndex(text, -1)
+	inCode := func(i int) bool {
+		for _, c := range code {
+			if i >= c[0] && i < c[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, loc := range embedPattern.FindAllStringSubmatchIndex(text, -1) {
+		if inCode(loc[0]) {
+			continue
+		}
+		content, ok := t.embed(relPath, text[loc[2]:loc[3]], depth, stack)
+		if !ok {
+			continue
+		}
+		// Nest the embedded headings under the one the embed is in, so what follows stays under it, too
+		content = nestHeadings(content, headingLevel(text[:loc[0]]))
+		sb.WriteString(text[last:loc[0]])
+		sb.WriteString(content)
+		last = loc[1]
+	}
+	if last == 0 {
+		return text
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// embed returns the content an embed in the note at from refers to, with its own embeds resolved.
+func (t *transcluder) embed(from, target string, depth int, stack []string) (string, bool) {
+	name, block, isBlock := strings.Cut(target, "^")
+	name, heading, _ := strings.Cut(name, "#")
+	name = strings.TrimSpace(name)
+	if ext := path.Ext(name); ext != "" && ext != ".md" {
+		// An image, PDF or other attachment
+		return "", false
+	}
+	relPath := from
+	if name != "" {
+		var ok bool
+		relPath, ok = t.resolver.Resolve(from, name)
+		if !ok {
+			return "", false
+		}
+	}
+	if slices.Contains(stack, relPath) && (relPath != from || heading == "" && !isBlock) {
+		// A cycle; a note may embed its own sections, though
+		return "", false
+	}
+	// Remembered even if the heading or block is missing, since it may be added later
+	if relPath != from && !slices.Contains(t.sources, relPath) {
+		t.sources = append(t.sources, relPath)
+	}
+
+	contents, err := os.ReadFile(path.Join(t.basePath, relPath))
+	if err != nil {
+		return "", false
+	}
+	rest, err := frontmatter.Parse(bytes.NewReader(contents), &map[string]any{})
+	if err != nil {
+		return "", false
+	}
+	text := string(rest)
+	switch {
+	case isBlock:
+		text, err = blockText(text, strings.TrimSpace(block))
+	case heading != "":
+		// [[note#Heading#Subheading]] refers to the last heading
+		headings := strings.Split(heading, "#")
+		text, err = sectionText(text, strings.TrimSpace(headings[len(headings)-1]))
+	}
+	if err != nil {
+		return "", false
+	}
+	text = strings.TrimSpace(t.transclude(relPath, text, depth-1, stack))
+	if strings.Contains(text, "\n") {
+		// Keep headings and lists on lines of their own
+		text = "\n\n" + text + "\n\n"
+	}
+	return text, true
+}
+
+// headingLevel returns the level of the last heading in text, or zero if there's none.
+func headingLevel(text string) int {
+	level := 0
+	inFence := false
+	for _, line := range strings.Split(text, "\n") {
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+		if m := sectionHeadingPattern.FindStringSubmatch(line); m != nil && !inFence {
+			level = len(m[1])
+		}
+	}
+	return level
+}
+
+// nestHeadings demotes the headings in text so the highest of them is one level below the given one (down to level
+// 6 at most).
+func nestHeadings(text string, level int) string {
+	lines := strings.Split(text, "\n")
+	top := 0
+	inFence := false
+	var headings []int
+	for i, line := range lines {
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+		if m := sectionHeadingPattern.FindStringSubmatch(line); m != nil && !inFence {
+			headings = append(headings, i)
+			if top == 0 || len(m[1]) < top {
+				top = len(m[1])
+			}
+		}
+	}
+	shift := level + 1 - top
+	if len(headings) == 0 || shift <= 0 {
+		return text
+	}
+	for _, i := range headings {
+		hashes := len(lines[i]) - len(strings.TrimLeft(lines[i], "#"))
+		lines[i] = strings.Repeat("#", min(hashes+shift, 6)) + lines[i][hashes:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sectionText returns the section of text under the given heading (matched case-insensitively), up to the next
+// heading of the same or a higher level.  The heading itself is included.
+func sectionText(text, heading string) (string, error) {
+	var sb strings.Builder
+	level := 0
+	inFence := false
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+		if m := sectionHeadingPattern.FindStringSubmatch(line); m != nil && !inFence {
+			switch {
+			case level > 0 && len(m[1]) <= level:
+				return sb.String(), nil
+			case level == 0 && strings.EqualFold(m[2], heading):
+				level = len(m[1])
+			}
+		}
+		if level > 0 {
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+	}
+	if level == 0 {
+		return "", fmt.Errorf("heading %q not found", heading)
+	}
+	return sb.String(), nil
+}
+
+// blockText returns the block (paragraph, list item, or the table or list a standalone ID follows) with the given ID,
+// without the ID.
+func blockText(text, id string) (string, error) {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		m := blockIdPattern.FindStringSubmatchIndex(line)
+		if m == nil || line[m[2]:m[3]] != id {
+			continue
+		}
+		content := strings.TrimRight(line[:m[0]], " \t")
+		switch {
+		case strings.TrimSpace(content) == "":
+			// The ID has a line of its own, after the block it belongs to
+			end := i
+			for end > 0 && strings.TrimSpace(lines[end-1]) == "" {
+				end--
+			}
+			start := end
+			for start > 0 && strings.TrimSpace(lines[start-1]) != "" {
+				start--
+			}
+			return strings.Join(lines[start:end], "\n"), nil
+		case listItemPattern.MatchString(content):
+			return content, nil
+		}
+		// A paragraph
+		start := i
+		for start > 0 && strings.TrimSpace(lines[start-1]) != "" && !sectionHeadingPattern.MatchString(lines[start-1]) {
+			start--
+		}
+		return strings.Join(append(slices.Clone(lines[start:i]), content), "\n"), nil
+	}
+	return "", fmt.Errorf("block ^%s not found", id)
+}
@@ -0,0 +1,185 @@
+package markdown
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// nameResolver resolves a link by the name of the note, like Obsidian's shortest paths.
+type nameResolver map[string]string
+
+func (r nameResolver) Resolve(from, target string) (string, bool) {
+	p, ok := r[strings.TrimSuffix(target, ".md")]
+	return p, ok
+}
+
+// writeVault writes the notes (by relative path) to a temporary folder, returning it and a resolver for them.
+func writeVault(t *testing.T, notes map[string]string) (string, nameResolver) {
+	t.Helper()
+	dir := t.TempDir()
+	r := make(nameResolver)
+	for p, content := range notes {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		r[strings.TrimSuffix(filepath.Base(p), ".md")] = p
+	}
+	return dir, r
+}
+
+func TestTransclude(t *testing.T) {
+	dir, r := writeVault(t, map[string]string{
+		"self.md":      "Me ![[self]] again",
+		"a.md":         "A ![[b]]",
+		"b.md":         "B ![[a]]",
+		"one.md":       "1 ![[two]]",
+		"sub/two.md":   "2 ![[three]]",
+		"three.md":     "3 ![[four]]",
+		"four.md":      "4",
+		"blocks.md":    "---\ntitle: Blocks\n---\nFirst line\nof a paragraph ^para\n\n- an item ^item\n- another\n\n| k | v |\n| - | - |\n\n^table",
+		"sections.md":  "# Intro\nhello\n## Details\ndeep ^inner\n# Next\nbye",
+		"embedsown.md": "# Top\nsee ![[#^own]]\n\nMine ^own",
+	})
+	tests := []struct {
+		name        string
+		note        string
+		text        string
+		depth       int
+		want        string
+		wantSources []string
+	}{
+		{
+			name:        "a whole note",
+			note:        "x.md",
+			text:        "Then ![[four|the fourth]] and ![[four.md]].",
+			depth:       1,
+			want:        "Then 4 and 4.",
+			wantSources: []string{"four.md"},
+		},
+		{
+			name:  "a note embedding itself is left alone",
+			note:  "self.md",
+			text:  "Me ![[self]] again",
+			depth: 3,
+			want:  "Me ![[self]] again",
+		},
+		{
+			name:        "a cycle stops where it comes back",
+			note:        "a.md",
+			text:        "A ![[b]]",
+			depth:       3,
+			want:        "A B ![[a]]",
+			wantSources: []string{"b.md"},
+		},
+		{
+			name:        "embeds are resolved up to the depth",
+			note:        "one.md",
+			text:        "1 ![[two]]",
+			depth:       2,
+			want:        "1 2 3 ![[four]]",
+			wantSources: []string{"sub/two.md", "three.md"},
+		},
+		{
+			name:        "and all of them within it",
+			note:        "one.md",
+			text:        "1 ![[two]]",
+			depth:       DefaultEmbedDepth,
+			want:        "1 2 3 4",
+			wantSources: []string{"sub/two.md", "three.md", "four.md"},
+		},
+		{
+			name:  "no depth leaves the embeds alone",
+			note:  "one.md",
+			text:  "1 ![[two]]",
+			depth: 0,
+			want:  "1 ![[two]]",
+		},
+		{
+			name:  "missing notes and attachments are left alone",
+			note:  "x.md",
+			text:  "![[nowhere]] ![[diagram.png]]",
+			depth: 3,
+			want:  "![[nowhere]] ![[diagram.png]]",
+		},
+		{
+			name:        "a missing heading or block is left alone, but the note is remembered",
+			note:        "x.md",
+			text:        "![[sections#Nowhere]] ![[blocks#^nothing]]",
+			depth:       3,
+			want:        "![[sections#Nowhere]] ![[blocks#^nothing]]",
+			wantSources: []string{"sections.md", "blocks.md"},
+		},
+		{
+			name:        "a paragraph block, without its ID",
+			note:        "x.md",
+			text:        "See ![[blocks#^para]]",
+			depth:       1,
+			want:        "See \n\nFirst line\nof a paragraph\n\n",
+			wantSources: []string{"blocks.md"},
+		},
+		{
+			name:        "a list item block",
+			note:        "x.md",
+			text:        "See ![[blocks#^item]]",
+			depth:       1,
+			want:        "See - an item",
+			wantSources: []string{"blocks.md"},
+		},
+		{
+			name:        "the block a standalone ID follows",
+			note:        "x.md",
+			text:        "See ![[blocks#^table]]",
+			depth:       1,
+			want:        "See \n\n| k | v |\n| - | - |\n\n",
+			wantSources: []string{"blocks.md"},
+		},
+		{
+			name:        "a block within a section",
+			note:        "x.md",
+			text:        "See ![[sections#Details#^inner]]",
+			depth:       1,
+			want:        "See deep",
+			wantSources: []string{"sections.md"},
+		},
+		{
+			name:  "a block of the note itself",
+			note:  "embedsown.md",
+			text:  "# Top\nsee ![[#^own]]\n\nMine ^own",
+			depth: 1,
+			want:  "# Top\nsee Mine\n\nMine ^own",
+		},
+		{
+			name:        "a section, nested under the heading the embed is in",
+			note:        "x.md",
+			text:        "# Notes\n![[sections#Intro]]\nmore",
+			depth:       1,
+			want:        "# Notes\n\n\n## Intro\nhello\n### Details\ndeep ^inner\n\n\nmore",
+			wantSources: []string{"sections.md"},
+		},
+		{
+			name:  "embeds in code are left alone",
+			note:  "x.md",
+			text:  "```\n![[four]]\n```",
+			depth: 1,
+			want:  "```\n![[four]]\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &transcluder{basePath: dir, resolver: r}
+			if got := tr.transclude(tt.note, tt.text, tt.depth, nil); got != tt.want {
+				t.Errorf("transclude = %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(tr.sources, tt.wantSources) {
+				t.Errorf("sources = %q, want %q", tr.sources, tt.wantSources)
+			}
+		})
+	}
+}
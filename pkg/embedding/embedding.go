@@ -0,0 +1,144 @@
+// Package embedding provides the embedding providers used to build and query the document DB, for each of the kinds
+// of servers texttrove can talk to.
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/clocklear/chromem-go"
+)
+
+// Provider types
+const (
+	TypeOllama = "ollama"
+	TypeOpenAI = "openai"
+)
+
+// Provider creates embeddings for a batch of texts in a single request.
+type Provider interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Config describes an embedding provider.
+type Config struct {
+	// Type is one of TypeOllama or TypeOpenAI
+	Type  string
+	Model string
+	// URL is the base URL of the server, e.g. http://localhost:11434 for ollama or http://localhost:1234/v1 for an
+	// OpenAI-compatible server
+	URL string
+	// Client is used for all requests; set it to add headers (e.g. for auth).  Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// New creates the provider described by cfg.
+func New(cfg Config) (Provider, error) {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	switch cfg.Type {
+	case TypeOllama:
+		return NewOllama(cfg.Client, cfg.URL, cfg.Model), nil
+	case TypeOpenAI:
+		return NewOpenAI(cfg.Client, cfg.URL, cfg.Model), nil
+	}
+	return nil, fmt.Errorf("unknown embedding type %s", cfg.Type)
+}
+
+// Func adapts a provider to the single-text embedding function chromem uses.
+func Func(p Provider) chromem.EmbeddingFunc {
+	return func(ctx context.Context, text string) ([]float32, error) {
+		v, err := p.Embed(ctx, []string{text})
+		if err != nil {
+			return nil, err
+		}
+		return v[0], nil
+	}
+}
+
+// StatusError is returned when the server responds with an error status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("embedding API returned %s: %s", e.Status, e.Body)
+}
+
+// retryable reports whether a request that failed with err might succeed if tried again.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+	}
+	// Connection problems and the like
+	return true
+}
+
+// postJSON sends req as JSON to url and decodes the JSON response into resp.
+func postJSON(ctx context.Context, client *http.Client, url string, req, resp any) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: httpResp.StatusCode, Status: httpResp.Status, Body: string(bytes.TrimSpace(body))}
+	}
+	return json.Unmarshal(body, resp)
+}
+
+// checkCount makes sure the server returned one embedding per text.
+func checkCount(texts []string, vectors [][]float32) error {
+	if len(vectors) != len(texts) {
+		return fmt.Errorf("asked for %d embeddings but got %d", len(texts), len(vectors))
+	}
+	for _, v := range vectors {
+		if len(v) == 0 {
+			return errors.New("empty embedding in the response")
+		}
+	}
+	return nil
+}
+
+// normalize scales v to unit length, which chromem relies on to compute cosine similarity as a dot product.
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	norm := math.Sqrt(sum)
+	if norm == 0 || math.Abs(norm-1) < 1e-6 {
+		return v
+	}
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
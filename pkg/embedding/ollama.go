@@ -0,0 +1,41 @@
+package embedding
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Ollama embeds texts using an ollama server's /api/embed endpoint, which accepts many inputs per request.
+type Ollama struct {
+	client *http.Client
+	url    string
+	model  string
+}
+
+// NewOllama returns a provider backed by an ollama server at baseURL (e.g. http://localhost:11434).
+func NewOllama(client *http.Client, baseURL, model string) *Ollama {
+	return &Ollama{
+		client: client,
+		url:    strings.TrimSuffix(baseURL, "/") + "/api/embed",
+		model:  model,
+	}
+}
+
+func (o *Ollama) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	err := postJSON(ctx, o.client, o.url, map[string]any{"model": o.model, "input": texts}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCount(texts, resp.Embeddings)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range resp.Embeddings {
+		resp.Embeddings[i] = normalize(v)
+	}
+	return resp.Embeddings, nil
+}
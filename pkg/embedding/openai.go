@@ -0,0 +1,50 @@
+package embedding
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// OpenAI embeds texts using an OpenAI-compatible /embeddings endpoint (OpenAI, LM Studio, llama.cpp server, vLLM, ...).
+type OpenAI struct {
+	client *http.Client
+	url    string
+	model  string
+}
+
+// NewOpenAI returns a provider backed by an OpenAI-compatible server.  baseURL includes the version, e.g.
+// http://localhost:1234/v1.  Authenticate by adding an Authorization header to the client.
+func NewOpenAI(client *http.Client, baseURL, model string) *OpenAI {
+	return &OpenAI{
+		client: client,
+		url:    strings.TrimSuffix(baseURL, "/") + "/embeddings",
+		model:  model,
+	}
+}
+
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	err := postJSON(ctx, o.client, o.url, map[string]any{"model": o.model, "input": texts}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	// The results aren't guaranteed to be in the same order as the inputs
+	vectors := make([][]float32, len(resp.Data))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			continue
+		}
+		vectors[d.Index] = normalize(d.Embedding)
+	}
+	err = checkCount(texts, vectors)
+	if err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
@@ -0,0 +1,147 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Pipeline wraps a provider with the policies needed to embed a whole vault without overwhelming the server: texts are
+// sent in batches of bounded size, requests are rate limited, and failed requests are retried with exponential
+// backoff.  It's safe for concurrent use; Concurrency tells callers how many batches to have in flight.
+type Pipeline struct {
+	provider       Provider
+	batchSize      int
+	concurrency    int
+	maxRetries     int
+	initialBackoff time.Duration
+
+	// Requests are spaced at least interval apart
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+type PipelineOption func(*Pipeline)
+
+// WithBatchSize sets the maximum number of texts sent per request.
+func WithBatchSize(n int) PipelineOption {
+	return func(p *Pipeline) {
+		p.batchSize = max(n, 1)
+	}
+}
+
+// WithConcurrency sets the number of batches callers should have in flight at once.
+func WithConcurrency(n int) PipelineOption {
+	return func(p *Pipeline) {
+		p.concurrency = max(n, 1)
+	}
+}
+
+// WithRateLimit limits the pipeline to the given number of requests per second; zero means no limit.
+func WithRateLimit(perSecond float64) PipelineOption {
+	return func(p *Pipeline) {
+		p.interval = 0
+		if perSecond > 0 {
+			p.interval = time.Duration(float64(time.Second) / perSecond)
+		}
+	}
+}
+
+// WithRetries sets how many times a failed request is retried, and how long to wait before the first retry.  The wait
+// doubles with every attempt.
+func WithRetries(n int, initialBackoff time.Duration) PipelineOption {
+	return func(p *Pipeline) {
+		p.maxRetries = max(n, 0)
+		p.initialBackoff = initialBackoff
+	}
+}
+
+// NewPipeline wraps the given provider.
+func NewPipeline(provider Provider, opts ...PipelineOption) *Pipeline {
+	p := &Pipeline{
+		provider:       provider,
+		batchSize:      32,
+		concurrency:    2,
+		maxRetries:     3,
+		initialBackoff: 500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+func (p *Pipeline) BatchSize() int {
+	return p.batchSize
+}
+
+func (p *Pipeline) Concurrency() int {
+	return p.concurrency
+}
+
+// Embed embeds the given texts, splitting them into batches as needed.
+func (p *Pipeline) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += p.batchSize {
+		batch := texts[start:min(start+p.batchSize, len(texts))]
+		v, err := p.embedBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, v...)
+	}
+	return vectors, nil
+}
+
+// embedBatch sends a single batch, retrying transient failures.
+func (p *Pipeline) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	backoff := p.initialBackoff
+	for attempt := 0; ; attempt++ {
+		err := p.wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v, err := p.provider.Embed(ctx, texts)
+		if err == nil {
+			return v, nil
+		}
+		if attempt >= p.maxRetries || !retryable(err) {
+			if attempt > 0 {
+				err = fmt.Errorf("%w (after %d attempts)", err, attempt+1)
+			}
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// wait blocks until the rate limit allows another request.
+func (p *Pipeline) wait(ctx context.Context) error {
+	if p.interval == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	now := time.Now()
+	at := now
+	if p.next.After(now) {
+		at = p.next
+	}
+	p.next = at.Add(p.interval)
+	p.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+	return nil
+}
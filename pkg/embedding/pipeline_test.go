@@ -0,0 +1,195 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer is an OpenAI-compatible embeddings endpoint that fails its first calls with the given statuses (zero
+// for success), then embeds each text as [len(text), 0].
+type flakyServer struct {
+	failures []int
+
+	mu      sync.Mutex
+	calls   int
+	batches [][]string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Input []string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	call := s.calls
+	s.calls++
+	s.batches = append(s.batches, req.Input)
+	s.mu.Unlock()
+	if call < len(s.failures) && s.failures[call] != 0 {
+		http.Error(w, "try again", s.failures[call])
+		return
+	}
+	type datum struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	}
+	var resp struct {
+		Data []datum `json:"data"`
+	}
+	for i, t := range req.Input {
+		resp.Data = append(resp.Data, datum{Index: i, Embedding: []float32{float32(len(t)), 0}})
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func newTestPipeline(t *testing.T, s *flakyServer, opts ...PipelineOption) *Pipeline {
+	t.Helper()
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return NewPipeline(NewOpenAI(ts.Client(), ts.URL+"/v1", "test"), opts...)
+}
+
+func TestPipelineRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   []int
+		retries    int
+		wantCalls  int
+		wantStatus int
+		wantErr    string
+	}{
+		{name: "succeeds first time", retries: 3, wantCalls: 1},
+		{name: "retries rate limiting", failures: []int{429, 429}, retries: 3, wantCalls: 3},
+		{name: "retries server errors", failures: []int{500, 502, 503}, retries: 3, wantCalls: 4},
+		{name: "gives up after the retries", failures: []int{503, 503, 503}, retries: 2, wantCalls: 3, wantStatus: 503, wantErr: "(after 3 attempts)"},
+		{name: "doesn't retry client errors", failures: []int{400}, retries: 3, wantCalls: 1, wantStatus: 400},
+		{name: "no retries", failures: []int{429}, retries: 0, wantCalls: 1, wantStatus: 429},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &flakyServer{failures: tt.failures}
+			p := newTestPipeline(t, s, WithRetries(tt.retries, time.Millisecond))
+			vectors, err := p.Embed(context.Background(), []string{"a", "bb"})
+			if s.calls != tt.wantCalls {
+				t.Errorf("server called %d times, want %d", s.calls, tt.wantCalls)
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][0] != 1 {
+					// Embeddings are normalized, so [1, 0] and [2, 0] both become [1, 0]
+					t.Errorf("vectors = %v, want one per text", vectors)
+				}
+				return
+			}
+			var se *StatusError
+			if !errors.As(err, &se) || se.StatusCode != tt.wantStatus {
+				t.Fatalf("err = %v, want status %d", err, tt.wantStatus)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %q, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPipelineBackoff(t *testing.T) {
+	s := &flakyServer{failures: []int{429, 429}}
+	p := newTestPipeline(t, s, WithRetries(2, 20*time.Millisecond))
+	start := time.Now()
+	if _, err := p.Embed(context.Background(), []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	// The wait doubles: 20ms, then 40ms
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("took %s, want at least 60ms of backoff", elapsed)
+	}
+
+	// Cancelling stops the retries
+	s = &flakyServer{failures: []int{429, 429}}
+	p = newTestPipeline(t, s, WithRetries(2, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Embed(ctx, []string{"a"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the deadline", err)
+	}
+	if s.calls != 1 {
+		t.Errorf("server called %d times, want 1", s.calls)
+	}
+}
+
+func TestPipelineBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		texts     int
+		batchSize int
+		want      []int
+	}{
+		{"uneven", 7, 3, []int{3, 3, 1}},
+		{"exact", 6, 3, []int{3, 3}},
+		{"smaller than a batch", 2, 32, []int{2}},
+		{"one at a time", 3, 1, []int{1, 1, 1}},
+		{"nothing", 0, 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &flakyServer{}
+			p := newTestPipeline(t, s, WithBatchSize(tt.batchSize))
+			var texts []string
+			for i := range tt.texts {
+				texts = append(texts, strings.Repeat("x", i+1))
+			}
+			vectors, err := p.Embed(context.Background(), texts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sizes []int
+			var sent []string
+			for _, b := range s.batches {
+				sizes = append(sizes, len(b))
+				sent = append(sent, b...)
+			}
+			if !slices.Equal(sizes, tt.want) {
+				t.Errorf("batch sizes = %v, want %v", sizes, tt.want)
+			}
+			if !slices.Equal(sent, texts) || len(vectors) != len(texts) {
+				t.Errorf("sent %q and got %d vectors, want every text once, in order", sent, len(vectors))
+			}
+		})
+	}
+
+	// A failed batch fails the whole call, without sending the rest
+	s := &flakyServer{failures: []int{0, 400}}
+	p := newTestPipeline(t, s, WithBatchSize(2))
+	if _, err := p.Embed(context.Background(), []string{"a", "b", "c", "d", "e"}); err == nil {
+		t.Error("want an error when a batch fails")
+	}
+	if s.calls != 2 {
+		t.Errorf("server called %d times, want 2", s.calls)
+	}
+}
+
+func TestPipelineRateLimit(t *testing.T) {
+	s := &flakyServer{}
+	p := newTestPipeline(t, s, WithBatchSize(1), WithRateLimit(50))
+	start := time.Now()
+	if _, err := p.Embed(context.Background(), []string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	// Three requests 20ms apart
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("took %s, want the requests spaced 20ms apart", elapsed)
+	}
+}
@@ -0,0 +1,282 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// protocolVersions lists the MCP protocol revisions we can speak, newest first.
+var protocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// Ragger describes what we expect to be true of a thing that can RAG documents
+type Ragger interface {
+	Query(ctx context.Context, queryText string, nResults int, where, whereDocument map[string]any) ([]schema.Document, error)
+}
+
+// Config describes the dependencies of a Server.
+type Config struct {
+	// Name and Version are reported to clients during initialization
+	Name    string
+	Version string
+	// RAG backs the search_notes tool
+	RAG Ragger
+	// BasePath and FilePattern describe the notes exposed by list_notes, read_note and resources
+	BasePath    string
+	FilePattern string
+	// MaxDocumentResults is the default number of results returned by search_notes
+	MaxDocumentResults int
+	// Logger receives diagnostic output; may be nil.  It must not write to the stdio transport.
+	Logger func(string)
+}
+
+// Server is a Model Context Protocol server exposing the knowledge base as tools and resources.
+type Server struct {
+	cfg   Config
+	notes notes
+	tools []tool
+}
+
+func New(cfg Config) *Server {
+	s := &Server{
+		cfg:   cfg,
+		notes: notes{basePath: cfg.BasePath, filePattern: cfg.FilePattern},
+	}
+	s.tools = s.defaultTools()
+	return s
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// isNotification reports whether the request expects no response.
+func (r request) isNotification() bool {
+	return len(r.ID) == 0
+}
+
+// ServeStdio reads newline-delimited JSON-RPC messages from r and writes responses to w
+// until r is exhausted or ctx is done.  A read that's blocked when ctx is done is abandoned,
+// since readers like stdin can't be interrupted.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	enc := json.NewEncoder(w)
+	write := func(res *response) {
+		if res == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(res); err != nil {
+			s.log(fmt.Sprintf("err: failed to write response: %v", err))
+		}
+	}
+
+	// Read in the background, so a blocked read doesn't keep us from noticing ctx is done
+	lines := make(chan []byte)
+	var scanErr error
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			select {
+			case lines <- slices.Clone(scanner.Bytes()):
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr = scanner.Err()
+	}()
+
+	for {
+		var line []byte
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil
+		case l, ok := <-lines:
+			if !ok {
+				wg.Wait()
+				return scanErr
+			}
+			line = l
+		}
+		if len(line) == 0 {
+			continue
+		}
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			write(&response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: err.Error()}})
+			continue
+		}
+		// Handle requests concurrently so slow tool calls don't block the session
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			write(s.handle(ctx, req))
+		}()
+	}
+}
+
+// Handler returns an http.Handler accepting JSON-RPC messages via POST.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /mcp", func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: err.Error()}})
+			return
+		}
+		res := s.handle(r.Context(), req)
+		if res == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+	return mux
+}
+
+// handle dispatches a single request, returning nil for notifications.
+func (s *Server) handle(ctx context.Context, req request) *response {
+	result, err := s.dispatch(ctx, req)
+	if req.isNotification() {
+		if err != nil {
+			s.log(fmt.Sprintf("err: notification %s failed: %v", req.Method, err))
+		}
+		return nil
+	}
+	res := &response{JSONRPC: "2.0", ID: req.ID}
+	if err != nil {
+		var re *rpcError
+		if !errors.As(err, &re) {
+			re = &rpcError{Code: codeInternalError, Message: err.Error()}
+		}
+		res.Error = re
+		return res
+	}
+	res.Result = result
+	return res
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (any, error) {
+	if req.JSONRPC != "2.0" {
+		return nil, &rpcError{Code: codeInvalidRequest, Message: "jsonrpc must be \"2.0\""}
+	}
+	switch req.Method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if err := unmarshalParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		v := protocolVersions[0]
+		if slices.Contains(protocolVersions, p.ProtocolVersion) {
+			v = p.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": v,
+			"capabilities": map[string]any{
+				"tools":     map[string]any{},
+				"resources": map[string]any{},
+			},
+			"serverInfo": map[string]any{
+				"name":    s.cfg.Name,
+				"version": s.cfg.Version,
+			},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "notifications/initialized", "notifications/cancelled":
+		if !req.isNotification() {
+			// A request would get an empty response
+			return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("%s is a notification; send it without an id", req.Method)}
+		}
+		return nil, nil
+	case "tools/list":
+		return map[string]any{"tools": s.tools}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := unmarshalParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.callTool(ctx, p.Name, p.Arguments)
+	case "resources/list":
+		return s.listResources()
+	case "resources/templates/list":
+		return map[string]any{"resourceTemplates": []any{}}, nil
+	case "resources/read":
+		var p struct {
+			URI string `json:"uri"`
+		}
+		if err := unmarshalParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.readResource(p.URI)
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+}
+
+func unmarshalParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) log(msg string) {
+	if s.cfg.Logger == nil {
+		return
+	}
+	s.cfg.Logger(msg)
+}
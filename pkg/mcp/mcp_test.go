@@ -0,0 +1,135 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeStdio(t *testing.T) {
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"ping"}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"nonsense"}`,
+		``,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/list"}`,
+	}, "\n")
+	var out bytes.Buffer
+	s := New(Config{Name: "test"})
+	if err := s.ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	got := make(map[string]result)
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var res struct {
+			ID json.RawMessage `json:"id"`
+			result
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		got[string(res.ID)] = res.result
+	}
+
+	if len(got) != 4 {
+		t.Errorf("got %d responses, want one per request and none for the notification: %v", len(got), got)
+	}
+	if r := got["1"]; string(r.Result) != "{}" {
+		t.Errorf("ping = %s, want {}", r.Result)
+	}
+	// A notification sent as a request can't be answered with an empty result
+	for _, id := range []string{"2", "3"} {
+		if r := got[id]; r.Error == nil || r.Error.Code != codeMethodNotFound {
+			t.Errorf("response to %s = %+v, want method not found", id, r)
+		}
+	}
+	if r := got["4"]; r.Error != nil || !strings.Contains(string(r.Result), "search_notes") {
+		t.Errorf("tools/list = %+v, want the tools", r)
+	}
+}
+
+func TestServeStdioCancelled(t *testing.T) {
+	// A reader that blocks, like stdin with nothing to read
+	r, w := io.Pipe()
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- New(Config{}).ServeStdio(ctx, r, io.Discard)
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("err = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeStdio kept reading after the context was cancelled")
+	}
+}
+
+func TestNotesResolve(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p string) {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(target, p string) {
+		if err := os.Symlink(filepath.Join(dir, target), filepath.Join(dir, p)); err != nil {
+			t.Skipf("can't create symlinks: %v", err)
+		}
+	}
+	write("vault/note.md")
+	write("vault/sub/other.md")
+	write("vault/note.txt")
+	write("secret/key.md")
+	link("secret/key.md", "vault/key.md")
+	link("secret", "vault/secrets")
+	link("vault/sub/other.md", "vault/shortcut.md")
+	link("vault", "linked-vault")
+
+	tests := []struct {
+		base    string
+		path    string
+		wantErr bool
+	}{
+		{"vault", "note.md", false},
+		{"vault", "/sub/other.md", false},
+		{"vault", "shortcut.md", false},
+		{"vault", "../secret/key.md", true},
+		{"vault", "note.txt", true},
+		{"vault", "missing.md", true},
+		// Symlinks out of the vault
+		{"vault", "key.md", true},
+		{"vault", "secrets/key.md", true},
+		// The vault itself may be a symlink
+		{"linked-vault", "note.md", false},
+		{"linked-vault", "key.md", true},
+	}
+	for _, tt := range tests {
+		n := notes{basePath: filepath.Join(dir, tt.base), filePattern: "*.md"}
+		p, err := n.resolve(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolve(%q) in %s = %q, %v; want an error: %v", tt.path, tt.base, p, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package mcp
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// notes provides read-only access to the notes under basePath.
+type notes struct {
+	basePath    string
+	filePattern string
+}
+
+// list returns the paths, relative to basePath, of all notes whose relative path starts with prefix.
+func (n notes) list(prefix string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(n.basePath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		matched, err := filepath.Match(n.filePattern, filepath.Base(path))
+		if err != nil {
+			return err
+		}
+		if !matched {
+			return nil
+		}
+		rel, err := filepath.Rel(n.basePath, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, prefix) {
+			paths = append(paths, rel)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// resolve converts a note path relative to basePath into an absolute path, refusing
+// anything that escapes basePath (directly or through a symlink) or doesn't match the file pattern.
+func (n notes) resolve(relPath string) (string, error) {
+	relPath = strings.TrimPrefix(filepath.FromSlash(relPath), string(filepath.Separator))
+	base, err := filepath.Abs(n.basePath)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(base, relPath)
+	if !strings.HasPrefix(p, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside of the notes folder", relPath)
+	}
+	matched, err := filepath.Match(n.filePattern, filepath.Base(p))
+	if err != nil {
+		return "", err
+	}
+	if !matched {
+		return "", fmt.Errorf("path %q is not a note", relPath)
+	}
+	// Check again where symlinks lead
+	if base, err = filepath.EvalSymlinks(base); err != nil {
+		return "", err
+	}
+	if p, err = filepath.EvalSymlinks(p); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(p, base+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside of the notes folder", relPath)
+	}
+	return p, nil
+}
+
+// read returns the contents of the note at relPath.
+func (n notes) read(relPath string) (string, error) {
+	p, err := n.resolve(relPath)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// noteURI returns the resource URI for a note path relative to basePath.
+func noteURI(relPath string) string {
+	u := url.URL{Scheme: "note", Path: "/" + relPath}
+	return u.String()
+}
+
+// notePath returns the note path relative to basePath for a resource URI.
+func notePath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "note" {
+		return "", fmt.Errorf("unsupported resource URI %q", uri)
+	}
+	return strings.TrimPrefix(u.Path, "/"), nil
+}
+
+func (s *Server) listResources() (any, error) {
+	paths, err := s.notes.list("")
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]map[string]any, 0, len(paths))
+	for _, p := range paths {
+		resources = append(resources, map[string]any{
+			"uri":      noteURI(p),
+			"name":     p,
+			"mimeType": "text/markdown",
+		})
+	}
+	return map[string]any{"resources": resources}, nil
+}
+
+func (s *Server) readResource(uri string) (any, error) {
+	p, err := notePath(uri)
+	if err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	text, err := s.notes.read(p)
+	if err != nil {
+		return nil, &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return map[string]any{
+		"contents": []map[string]any{{
+			"uri":      uri,
+			"mimeType": "text/markdown",
+			"text":     text,
+		}},
+	}, nil
+}
@@ -0,0 +1,170 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/clocklear/texttrove/pkg/tools/date"
+)
+
+// tool describes a tool as advertised to clients, along with its implementation.
+type tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+
+	call func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+func (s *Server) defaultTools() []tool {
+	return []tool{
+		{
+			Name:        "search_notes",
+			Description: "Searches the user's notes for content relevant to a question or topic.  Returns the most relevant note fragments, ordered by relevance, along with their source paths.",
+			InputSchema: objectSchema(map[string]any{
+				"query": stringProperty("A question or topic to search for"),
+				"n":     map[string]any{"type": "integer", "description": "Maximum number of results to return"},
+			}, "query"),
+			call: s.searchNotes,
+		},
+		{
+			Name:        "read_note",
+			Description: "Reads the full contents of a note.",
+			InputSchema: objectSchema(map[string]any{
+				"path": stringProperty("Path of the note, relative to the notes folder, as returned by list_notes or search_notes"),
+			}, "path"),
+			call: s.readNote,
+		},
+		{
+			Name:        "list_notes",
+			Description: "Lists the paths of the user's notes, optionally restricted to those under a folder.",
+			InputSchema: objectSchema(map[string]any{
+				"prefix": stringProperty("Only list notes whose path starts with this prefix, e.g. a folder name"),
+			}),
+			call: s.listNotes,
+		},
+		{
+			Name:        "resolve_date",
+			Description: "Determines the date (YYYY-MM-DD) for a relative date expression such as 'today', 'last sunday' or 'in two weeks'.",
+			InputSchema: objectSchema(map[string]any{
+				"expression": stringProperty("A relative date expression"),
+			}, "expression"),
+			call: s.resolveDate,
+		},
+	}
+}
+
+func (s *Server) callTool(ctx context.Context, name string, args json.RawMessage) (any, error) {
+	for _, t := range s.tools {
+		if t.Name != name {
+			continue
+		}
+		s.log(fmt.Sprintf("MCP tool call: %s %s", name, args))
+		out, err := t.call(ctx, args)
+		if err != nil {
+			// Tool errors are reported in the result so the model can see them
+			return toolResult(err.Error(), true), nil
+		}
+		return toolResult(out, false), nil
+	}
+	return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool %q", name)}
+}
+
+func (s *Server) searchNotes(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Query string `json:"query"`
+		N     int    `json:"n"`
+	}
+	if err := unmarshalArgs(args, &p); err != nil {
+		return "", err
+	}
+	if p.Query == "" {
+		return "", fmt.Errorf("query is required")
+	}
+	if p.N <= 0 {
+		p.N = s.cfg.MaxDocumentResults
+	}
+	docs, err := s.cfg.RAG.Query(ctx, p.Query, p.N, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(docs) == 0 {
+		return "No relevant notes found.", nil
+	}
+	sb := strings.Builder{}
+	for i, d := range docs {
+		fmt.Fprintf(&sb, "%d. %v (score %.4f)\n%s\n\n", i+1, d.Metadata["Source"], d.Score, strings.TrimSpace(d.PageContent))
+	}
+	return sb.String(), nil
+}
+
+func (s *Server) readNote(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Path string `json:"path"`
+	}
+	if err := unmarshalArgs(args, &p); err != nil {
+		return "", err
+	}
+	return s.notes.read(p.Path)
+}
+
+func (s *Server) listNotes(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Prefix string `json:"prefix"`
+	}
+	if err := unmarshalArgs(args, &p); err != nil {
+		return "", err
+	}
+	paths, err := s.notes.list(strings.TrimPrefix(p.Prefix, "/"))
+	if err != nil {
+		return "", err
+	}
+	if len(paths) == 0 {
+		return "No notes found.", nil
+	}
+	return strings.Join(paths, "\n"), nil
+}
+
+func (s *Server) resolveDate(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Expression string `json:"expression"`
+	}
+	if err := unmarshalArgs(args, &p); err != nil {
+		return "", err
+	}
+	return date.New().Call(ctx, p.Expression)
+}
+
+func unmarshalArgs(args json.RawMessage, v any) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(args, v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+func toolResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	s := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func stringProperty(description string) map[string]any {
+	return map[string]any{"type": "string", "description": description}
+}
@@ -2,10 +2,15 @@ package models
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/prompts"
@@ -38,24 +43,77 @@ Whenever you reference the knowledge base or the provided context, you should al
 `
 
 type Chat struct {
-	title             string // TODO: Future use; summarize the conversation
+	id                string
+	title             string
+	model             string
+	createdAt         time.Time
+	updatedAt         time.Time
 	completedMessages []llms.MessageContent
+	contexts          []RetrievedContext
+	contextPolicy     ContextPolicy
+	interrupted       []int
 	streamingParts    []string
 	isStreaming       bool
 	err               error
 	mu                sync.RWMutex
 
+	// summary stands in for the messages before summaryThrough in the context window; see Window
+	summary        string
+	summaryThrough int
+	// revision counts the changes to the messages, contexts and summary; see Revision
+	revision int
+
 	systemPromptTpl prompts.PromptTemplate
 	contextTpl      prompts.PromptTemplate
 }
 
+// RetrievedContext records the documents that were retrieved for a turn of the conversation.
+type RetrievedContext struct {
+	// MessageIndex is the index of the (system) message the documents were rendered into
+	MessageIndex int               `json:"message_index"`
+	Documents    []schema.Document `json:"documents"`
+	// SupersededBy is the index of the context that replaced this one, after which it was no longer sent to the model
+	SupersededBy int `json:"superseded_by,omitempty"`
+}
+
+// ChatRecord is a snapshot of a chat, suitable for persisting.
+type ChatRecord struct {
+	ID        string                `json:"id"`
+	Title     string                `json:"title"`
+	Model     string                `json:"model"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+	Messages  []llms.MessageContent `json:"messages"`
+	Contexts  []RetrievedContext    `json:"contexts,omitempty"`
+	// Interrupted holds the indexes of answers that were cancelled before they were complete
+	Interrupted []int `json:"interrupted,omitempty"`
+	// Summary stands in for the messages before SummaryThrough when they no longer fit the context window
+	Summary        string `json:"summary,omitempty"`
+	SummaryThrough int    `json:"summary_through,omitempty"`
+}
+
+// HasUserMessages reports whether the user has said anything in the chat yet.
+func (r ChatRecord) HasUserMessages() bool {
+	for _, m := range r.Messages {
+		if m.Role == llms.ChatMessageTypeHuman {
+			return true
+		}
+	}
+	return false
+}
+
 type ChatOption func(*Chat) error
 
 func NewChat(opts ...ChatOption) (*Chat, error) {
 	// init new chat
+	now := time.Now()
 	c := Chat{
+		id:                newChatID(now),
+		createdAt:         now,
+		updatedAt:         now,
 		completedMessages: make([]llms.MessageContent, 0),
 		streamingParts:    make([]string, 0),
+		contextPolicy:     DefaultContextPolicy,
 		systemPromptTpl:   prompts.NewPromptTemplate(baseSystemPromptTpl, nil),
 		contextTpl:        prompts.NewPromptTemplate(baseContextTpl, nil),
 	}
@@ -74,6 +132,14 @@ func NewChat(opts ...ChatOption) (*Chat, error) {
 	return &c, err
 }
 
+// WithModel records the name of the model the chat is held with.
+func WithModel(name string) ChatOption {
+	return func(c *Chat) error {
+		c.model = name
+		return nil
+	}
+}
+
 func WithSystemPromptTemplateFile(path string) ChatOption {
 	return func(c *Chat) error {
 		// If the file doesn't exist, bail
@@ -113,19 +179,98 @@ func (c *Chat) pushSystemPrompt() error {
 		return err
 	}
 	c.completedMessages = append(c.completedMessages, llms.TextParts(llms.ChatMessageTypeSystem, p))
+	c.revision++
 	return nil
 }
 
+// Reset starts a new conversation; the chat gets a new ID.
 func (c *Chat) Reset() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	now := time.Now()
+	c.id = newChatID(now)
+	c.title = ""
+	c.createdAt = now
+	c.updatedAt = now
 	c.isStreaming = false
 	c.err = nil
 	c.completedMessages = make([]llms.MessageContent, 0)
+	c.contexts = nil
+	c.interrupted = nil
+	c.summary = ""
+	c.summaryThrough = 0
 	c.streamingParts = make([]string, 0)
+	c.revision++
 	c.pushSystemPrompt()
 }
 
+// ID returns the unique identifier of the conversation.
+func (c *Chat) ID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.id
+}
+
+// Title returns the title of the conversation, falling back to the start of the first user message.
+func (c *Chat) Title() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.title != "" {
+		return c.title
+	}
+	for _, m := range c.completedMessages {
+		if m.Role == llms.ChatMessageTypeHuman {
+			return summarize(messageText(m), 60)
+		}
+	}
+	return "New chat"
+}
+
+func (c *Chat) SetTitle(title string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.title = title
+}
+
+// Record returns a snapshot of the conversation for persisting.
+func (c *Chat) Record() ChatRecord {
+	title := c.Title()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return ChatRecord{
+		ID:             c.id,
+		Title:          title,
+		Model:          c.model,
+		CreatedAt:      c.createdAt,
+		UpdatedAt:      c.updatedAt,
+		Messages:       slices.Clone(c.completedMessages),
+		Contexts:       slices.Clone(c.contexts),
+		Interrupted:    slices.Clone(c.interrupted),
+		Summary:        c.summary,
+		SummaryThrough: c.summaryThrough,
+	}
+}
+
+// Restore replaces the conversation with the given snapshot.
+func (c *Chat) Restore(r ChatRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.id = r.ID
+	c.title = r.Title
+	c.model = r.Model
+	c.createdAt = r.CreatedAt
+	c.updatedAt = r.UpdatedAt
+	c.isStreaming = false
+	c.err = nil
+	c.completedMessages = slices.Clone(r.Messages)
+	c.contexts = slices.Clone(r.Contexts)
+	c.interrupted = slices.Clone(r.Interrupted)
+	c.summary = r.Summary
+	c.summaryThrough = r.SummaryThrough
+	c.streamingParts = make([]string, 0)
+	c.revision++
+}
+
 func (c *Chat) Error() error {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -170,12 +315,132 @@ func (c *Chat) EndStreaming() {
 	c.isStreaming = false
 	c.completedMessages = append(c.completedMessages, cnt)
 	c.streamingParts = make([]string, 0)
+	c.updatedAt = time.Now()
+	c.revision++
+}
+
+// AbortStreaming stops streaming after a failure, keeping any partial answer.
+func (c *Chat) AbortStreaming() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isStreaming = false
+	if len(c.streamingParts) > 0 {
+		c.completedMessages = append(c.completedMessages, llms.TextParts(llms.ChatMessageTypeAI, strings.Join(c.streamingParts, "")))
+	}
+	c.streamingParts = make([]string, 0)
+	c.updatedAt = time.Now()
+	c.revision++
+}
+
+// InterruptStreaming stops streaming after the user cancelled the answer, keeping any partial answer marked as interrupted.
+func (c *Chat) InterruptStreaming() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isStreaming = false
+	if len(c.streamingParts) > 0 {
+		c.interrupted = append(c.interrupted, len(c.completedMessages))
+		c.completedMessages = append(c.completedMessages, llms.TextParts(llms.ChatMessageTypeAI, strings.Join(c.streamingParts, "")))
+	}
+	c.streamingParts = make([]string, 0)
+	c.updatedAt = time.Now()
+	c.revision++
+}
+
+// IsInterrupted reports whether the message at index i is an answer that was cancelled before it was complete.
+func (c *Chat) IsInterrupted(i int) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Contains(c.interrupted, i)
+}
+
+// ErrNoUserMessage is returned when rewinding a chat the user hasn't said anything in yet.
+var ErrNoUserMessage = errors.New("there is no message to rewind to")
+
+// RewindToLastUserMessage drops everything after the last user message (answers, tool calls and their results) so
+// that the answer can be generated again.  Contexts retrieved for the message are kept.
+func (c *Chat) RewindToLastUserMessage() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.lastUserMessageIndex()
+	if i < 0 {
+		return ErrNoUserMessage
+	}
+	c.truncate(i + 1)
+	return nil
+}
+
+// RewindLastTurn drops the last user message, the contexts retrieved for it and everything after it, returning the
+// text of the message so that it can be edited and sent again.
+func (c *Chat) RewindLastTurn() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.lastUserMessageIndex()
+	if i < 0 {
+		return "", ErrNoUserMessage
+	}
+	text := messageText(c.completedMessages[i])
+	// Contexts are added immediately before the message they were retrieved for
+	start := i
+	for start > 0 && slices.ContainsFunc(c.contexts, func(rc RetrievedContext) bool { return rc.MessageIndex == start-1 }) {
+		start--
+	}
+	c.truncate(start)
+	return text, nil
+}
+
+func (c *Chat) lastUserMessageIndex() int {
+	for i := len(c.completedMessages) - 1; i >= 0; i-- {
+		if c.completedMessages[i].Role == llms.ChatMessageTypeHuman {
+			return i
+		}
+	}
+	return -1
+}
+
+// truncate keeps the first n messages, along with any contexts and interruptions that belong to them.
+// The caller must hold the lock.
+func (c *Chat) truncate(n int) {
+	// Clone, since in-flight requests may still hold the old slice
+	c.completedMessages = slices.Clone(c.completedMessages[:n])
+	c.contexts = slices.DeleteFunc(c.contexts, func(rc RetrievedContext) bool { return rc.MessageIndex >= n })
+	for j := range c.contexts {
+		if c.contexts[j].SupersededBy >= n {
+			// The context that replaced it is gone
+			c.contexts[j].SupersededBy = 0
+		}
+	}
+	c.interrupted = slices.DeleteFunc(c.interrupted, func(i int) bool { return i >= n })
+	if c.summaryThrough > n {
+		// The summary covers messages that are gone
+		c.summary, c.summaryThrough = "", 0
+	}
+	c.streamingParts = make([]string, 0)
+	c.updatedAt = time.Now()
+	c.revision++
+}
+
+// DiscardStreaming throws away anything streamed so far without ending the stream.
+func (c *Chat) DiscardStreaming() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.streamingParts = make([]string, 0)
+}
+
+// AppendMessage appends an arbitrary message (e.g. a tool call or tool result) to the ongoing chat.
+func (c *Chat) AppendMessage(msg llms.MessageContent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completedMessages = append(c.completedMessages, msg)
+	c.updatedAt = time.Now()
+	c.revision++
 }
 
 func (c *Chat) AppendUserMessage(msg string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.completedMessages = append(c.completedMessages, llms.TextParts(llms.ChatMessageTypeHuman, msg))
+	c.updatedAt = time.Now()
+	c.revision++
 }
 
 func (c *Chat) Log() []llms.MessageContent {
@@ -188,6 +453,14 @@ func (c *Chat) Log() []llms.MessageContent {
 	return msg
 }
 
+// Revision returns a number that changes whenever the messages, contexts or summary of the chat do, so what's worked
+// out from them (such as the Window) can be kept until then.
+func (c *Chat) Revision() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.revision
+}
+
 func (c *Chat) IsEmpty() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -197,6 +470,24 @@ func (c *Chat) IsEmpty() bool {
 func (c *Chat) AddContexts(contexts []schema.Document) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.insertContexts(len(c.completedMessages), contexts)
+}
+
+// AddContextsForLastMessage adds the documents retrieved for the last message, placing them immediately before it as
+// if they had been added first.  This lets a message be shown while its contexts are still being retrieved.
+func (c *Chat) AddContextsForLastMessage(contexts []schema.Document) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.insertContexts(max(len(c.completedMessages)-1, 0), contexts)
+}
+
+// insertContexts renders the documents into a system message at index i, shifting the messages after it, and applies
+// the context policy to earlier contexts.
+// The caller must hold the lock.
+func (c *Chat) insertContexts(i int, contexts []schema.Document) error {
+	if c.contextPolicy.Mode == ContextPolicyMerge {
+		contexts = c.mergeContexts(contexts)
+	}
 
 	// Extract slice of content from the documents
 	content := make([]string, 0, len(contexts))
@@ -209,10 +500,37 @@ func (c *Chat) AddContexts(contexts []schema.Document) error {
 	if err != nil {
 		return err
 	}
-	c.completedMessages = append(c.completedMessages, llms.TextParts(llms.ChatMessageTypeSystem, t))
+	for j := range c.contexts {
+		if c.contexts[j].MessageIndex >= i {
+			c.contexts[j].MessageIndex++
+		}
+		if c.contexts[j].SupersededBy > 0 && c.contexts[j].SupersededBy >= i {
+			c.contexts[j].SupersededBy++
+		}
+	}
+	for j := range c.interrupted {
+		if c.interrupted[j] >= i {
+			c.interrupted[j]++
+		}
+	}
+	if c.summaryThrough > i {
+		c.summaryThrough++
+	}
+	c.contexts = append(c.contexts, RetrievedContext{
+		MessageIndex: i,
+		Documents:    contexts,
+	})
+	c.completedMessages = slices.Insert(slices.Clone(c.completedMessages), i, llms.TextParts(llms.ChatMessageTypeSystem, t))
+	c.supersedeContexts(i)
+	c.revision++
 	return nil
 }
 
+// ContextTemplate returns the template used to render retrieved contexts.
+func (c *Chat) ContextTemplate() prompts.PromptTemplate {
+	return c.contextTpl
+}
+
 func (c *Chat) streamingPartsToContent() llms.MessageContent {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -226,6 +544,7 @@ func (c *Chat) AddMessage(ctx context.Context, message llms.ChatMessage) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.completedMessages = append(c.completedMessages, llms.TextParts(message.GetType(), message.GetContent()))
+	c.revision++
 	return nil
 }
 
@@ -253,34 +572,33 @@ func (c *Chat) Messages(ctx context.Context) ([]llms.ChatMessage, error) {
 	for _, m := range c.Log() {
 
 		// Extract the content as a string
-		sb := strings.Builder{}
-		for _, part := range m.Parts {
-			s, ok := part.(fmt.Stringer)
-			if !ok {
-				continue
-			}
-			sb.WriteString(s.String())
-		}
+		content := messageText(m)
 		switch m.Role {
 		case llms.ChatMessageTypeAI:
-			messages = append(messages, llms.AIChatMessage{Content: sb.String()})
+			messages = append(messages, llms.AIChatMessage{Content: content})
 		case llms.ChatMessageTypeHuman:
-			messages = append(messages, llms.HumanChatMessage{Content: sb.String()})
+			messages = append(messages, llms.HumanChatMessage{Content: content})
 		case llms.ChatMessageTypeSystem:
-			messages = append(messages, llms.SystemChatMessage{Content: sb.String()})
+			messages = append(messages, llms.SystemChatMessage{Content: content})
 		}
 	}
 	return messages, nil
 }
 
-// SetMessages replaces existing messages in the store
+// SetMessages replaces existing messages in the store.
+// Contexts, interruptions and the summary recorded for the old messages are dropped along with them.
 func (c *Chat) SetMessages(ctx context.Context, messages []llms.ChatMessage) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.isStreaming = false
 	c.err = nil
 	c.completedMessages = make([]llms.MessageContent, 0)
+	c.contexts = nil
+	c.interrupted = nil
+	c.summary = ""
+	c.summaryThrough = 0
 	c.streamingParts = make([]string, 0)
+	c.updatedAt = time.Now()
 	for _, m := range messages {
 		switch m.GetType() {
 		case llms.ChatMessageTypeAI:
@@ -291,5 +609,36 @@ func (c *Chat) SetMessages(ctx context.Context, messages []llms.ChatMessage) err
 			c.completedMessages = append(c.completedMessages, llms.TextParts(llms.ChatMessageTypeSystem, m.GetContent()))
 		}
 	}
+	c.revision++
 	return nil
 }
+
+// messageText concatenates the textual parts of a message.
+func messageText(m llms.MessageContent) string {
+	sb := strings.Builder{}
+	for _, part := range m.Parts {
+		s, ok := part.(fmt.Stringer)
+		if !ok {
+			continue
+		}
+		sb.WriteString(s.String())
+	}
+	return sb.String()
+}
+
+// summarize returns the first line of s, truncated to n runes.
+func summarize(s string, n int) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// newChatID returns a unique, chronologically sortable chat ID.
+func newChatID(t time.Time) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return t.Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
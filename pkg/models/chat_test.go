@@ -0,0 +1,253 @@
+package models
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// docs returns a fragment for each ID, with the ID as its content.
+func docs(ids ...string) []schema.Document {
+	d := make([]schema.Document, len(ids))
+	for i, id := range ids {
+		d[i] = schema.Document{PageContent: id, Metadata: map[string]any{"DocId": id}}
+	}
+	return d
+}
+
+// labels describes the messages of the chat: contexts as "ctx:" and the IDs of their fragments, the rest by their text.
+func labels(c *Chat) []string {
+	var s []string
+	for i, m := range c.Log() {
+		if rc, ok := c.Context(i); ok {
+			var ids []string
+			for _, d := range rc.Documents {
+				ids = append(ids, d.PageContent)
+			}
+			s = append(s, "ctx:"+strings.Join(ids, ","))
+			continue
+		}
+		if i == 0 && m.Role == llms.ChatMessageTypeSystem {
+			s = append(s, "sys")
+			continue
+		}
+		s = append(s, messageText(m))
+	}
+	return s
+}
+
+// placement is where a context is, and the index of the context that superseded it, if any.
+type placement struct {
+	at, supersededBy int
+}
+
+func placements(c *Chat) []placement {
+	var p []placement
+	for _, rc := range c.contexts {
+		p = append(p, placement{rc.MessageIndex, rc.SupersededBy})
+	}
+	return p
+}
+
+// newRewindTestChat returns a chat of two turns, retrieving a for the first and b for the second, whose first answer
+// was interrupted and summarized:
+//
+//	0 sys, 1 ctx:a, 2 q1, 3 a1 (interrupted), 4 ctx:b, 5 q2, 6 a2
+func newRewindTestChat(t *testing.T) *Chat {
+	t.Helper()
+	c, err := NewChat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AppendUserMessage("q1")
+	if err := c.AddContextsForLastMessage(docs("a")); err != nil {
+		t.Fatal(err)
+	}
+	c.BeginStreaming()
+	c.StreamChunk("a1")
+	c.InterruptStreaming()
+	c.SetSummary("earlier", 4)
+	c.AppendUserMessage("q2")
+	if err := c.AddContextsForLastMessage(docs("b")); err != nil {
+		t.Fatal(err)
+	}
+	c.AppendMessage(llms.TextParts(llms.ChatMessageTypeAI, "a2"))
+	return c
+}
+
+func TestChatContextIndexes(t *testing.T) {
+	tests := []struct {
+		name            string
+		op              func(c *Chat) error
+		want            []string
+		wantContexts    []placement
+		wantInterrupted []int
+		wantSummary     int
+	}{
+		{
+			name:            "as built",
+			op:              func(c *Chat) error { return nil },
+			want:            []string{"sys", "ctx:a", "q1", "a1", "ctx:b", "q2", "a2"},
+			wantContexts:    []placement{{1, 4}, {4, 0}},
+			wantInterrupted: []int{3},
+			wantSummary:     4,
+		},
+		{
+			name:            "inserting shifts the messages, contexts, interruptions and summary after it",
+			op:              func(c *Chat) error { return c.insertContexts(2, docs("c")) },
+			want:            []string{"sys", "ctx:a", "ctx:c", "q1", "a1", "ctx:b", "q2", "a2"},
+			wantContexts:    []placement{{1, 5}, {5, 0}, {2, 0}},
+			wantInterrupted: []int{4},
+			wantSummary:     5,
+		},
+		{
+			name:            "adding contexts for the next question supersedes the last ones",
+			op:              func(c *Chat) error { c.AppendUserMessage("q3"); return c.AddContextsForLastMessage(docs("c")) },
+			want:            []string{"sys", "ctx:a", "q1", "a1", "ctx:b", "q2", "a2", "ctx:c", "q3"},
+			wantContexts:    []placement{{1, 4}, {4, 7}, {7, 0}},
+			wantInterrupted: []int{3},
+			wantSummary:     4,
+		},
+		{
+			name:            "rewinding to the last question keeps its contexts",
+			op:              (*Chat).RewindToLastUserMessage,
+			want:            []string{"sys", "ctx:a", "q1", "a1", "ctx:b", "q2"},
+			wantContexts:    []placement{{1, 4}, {4, 0}},
+			wantInterrupted: []int{3},
+			wantSummary:     4,
+		},
+		{
+			name: "rewinding the last turn drops its contexts and revives those they superseded",
+			op: func(c *Chat) error {
+				text, err := c.RewindLastTurn()
+				if text != "q2" {
+					t.Errorf("rewound %q, want q2", text)
+				}
+				return err
+			},
+			want:            []string{"sys", "ctx:a", "q1", "a1"},
+			wantContexts:    []placement{{1, 0}},
+			wantInterrupted: []int{3},
+			wantSummary:     4,
+		},
+		{
+			name: "rewinding past the summary drops it",
+			op: func(c *Chat) error {
+				if _, err := c.RewindLastTurn(); err != nil {
+					return err
+				}
+				_, err := c.RewindLastTurn()
+				return err
+			},
+			want: []string{"sys"},
+		},
+		{
+			name: "a new turn after rewinding supersedes the earlier contexts again",
+			op: func(c *Chat) error {
+				if _, err := c.RewindLastTurn(); err != nil {
+					return err
+				}
+				c.AppendUserMessage("q2 again")
+				return c.AddContextsForLastMessage(docs("c"))
+			},
+			want:            []string{"sys", "ctx:a", "q1", "a1", "ctx:c", "q2 again"},
+			wantContexts:    []placement{{1, 4}, {4, 0}},
+			wantInterrupted: []int{3},
+			wantSummary:     4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRewindTestChat(t)
+			if err := tt.op(c); err != nil {
+				t.Fatal(err)
+			}
+			if got := labels(c); !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if got := placements(c); !slices.Equal(got, tt.wantContexts) {
+				t.Errorf("contexts = %v, want %v", got, tt.wantContexts)
+			}
+			if !slices.Equal(c.interrupted, tt.wantInterrupted) {
+				t.Errorf("interrupted = %v, want %v", c.interrupted, tt.wantInterrupted)
+			}
+			if _, through := c.Summary(); through != tt.wantSummary {
+				t.Errorf("summary through %d, want %d", through, tt.wantSummary)
+			}
+		})
+	}
+
+	// There's nothing to rewind to before the user has said anything
+	c, err := NewChat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RewindToLastUserMessage(); err != ErrNoUserMessage {
+		t.Errorf("err = %v, want %v", err, ErrNoUserMessage)
+	}
+}
+
+func TestChatRevision(t *testing.T) {
+	c := newRewindTestChat(t)
+	rev := c.Revision()
+	c.StreamChunk("streaming doesn't change the window")
+	c.SetTitle("nor does the title")
+	if c.Revision() != rev {
+		t.Errorf("revision changed from %d to %d", rev, c.Revision())
+	}
+	ops := []struct {
+		name string
+		op   func()
+	}{
+		{"append", func() { c.AppendUserMessage("q3") }},
+		{"context", func() { _ = c.AddContextsForLastMessage(docs("c")) }},
+		{"summary", func() { c.SetSummary("later", 5) }},
+		{"rewind", func() { _ = c.RewindToLastUserMessage() }},
+		{"reset", c.Reset},
+	}
+	for _, o := range ops {
+		o.op()
+		if c.Revision() == rev {
+			t.Errorf("%s didn't change the revision", o.name)
+		}
+		rev = c.Revision()
+	}
+}
+
+func TestSetMessages(t *testing.T) {
+	c := newRewindTestChat(t)
+	rev := c.Revision()
+	err := c.SetMessages(context.Background(), []llms.ChatMessage{
+		llms.HumanChatMessage{Content: "q"},
+		llms.AIChatMessage{Content: "a"},
+		llms.HumanChatMessage{Content: "q2"},
+		llms.AIChatMessage{Content: "a2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Contexts, interruptions and the summary belonged to the old messages; their indexes would now point at
+	// unrelated ones
+	if got, want := labels(c), []string{"q", "a", "q2", "a2"}; !slices.Equal(got, want) {
+		t.Errorf("messages = %q, want %q", got, want)
+	}
+	for i := range c.Log() {
+		if c.IsInterrupted(i) {
+			t.Errorf("message %d is still marked interrupted", i)
+		}
+	}
+	if summary, through := c.Summary(); summary != "" || through != 0 {
+		t.Errorf("Summary = %q, %d; want none", summary, through)
+	}
+	if r := c.Record(); len(r.Contexts) != 0 || len(r.Interrupted) != 0 {
+		t.Errorf("Contexts = %v, Interrupted = %v; want none", r.Contexts, r.Interrupted)
+	}
+	if c.Revision() == rev {
+		t.Error("SetMessages didn't change the revision")
+	}
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// ContextPolicyMode decides what happens to the contexts retrieved for earlier turns when new ones are added.
+type ContextPolicyMode string
+
+const (
+	// ContextPolicyAll keeps every retrieved context in the conversation
+	ContextPolicyAll ContextPolicyMode = "all"
+	// ContextPolicyLatest only keeps the contexts retrieved for the latest turn
+	ContextPolicyLatest ContextPolicyMode = "latest"
+	// ContextPolicyLast keeps the contexts retrieved for the last Keep turns
+	ContextPolicyLast ContextPolicyMode = "last"
+	// ContextPolicyMerge merges the documents of earlier contexts into the latest one, without duplicates
+	ContextPolicyMerge ContextPolicyMode = "merge"
+)
+
+// ContextPolicy describes how long retrieved contexts are sent to the model.  Contexts that are replaced stay in the
+// chat, marked as superseded, so it's still known which documents backed which answer.
+type ContextPolicy struct {
+	Mode ContextPolicyMode
+	// Keep is the number of turns whose contexts are kept (last), or whose worth of documents are merged (merge)
+	Keep int
+}
+
+// DefaultContextPolicy only sends the contexts retrieved for the latest turn.
+var DefaultContextPolicy = ContextPolicy{Mode: ContextPolicyLatest, Keep: 1}
+
+// Validate reports whether the policy can be applied.
+func (p ContextPolicy) Validate() error {
+	switch p.Mode {
+	case ContextPolicyAll, ContextPolicyLatest:
+		return nil
+	case ContextPolicyLast, ContextPolicyMerge:
+		if p.Keep < 1 {
+			return fmt.Errorf("context policy %s needs to keep at least 1 turn, not %d", p.Mode, p.Keep)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown context policy %q; use all, latest, last or merge", p.Mode)
+}
+
+// WithContextPolicy sets what happens to earlier contexts when new ones are retrieved; defaults to
+// DefaultContextPolicy.
+func WithContextPolicy(p ContextPolicy) ChatOption {
+	return func(c *Chat) error {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		c.contextPolicy = p
+		return nil
+	}
+}
+
+// Context returns the retrieved context rendered into the message at index i, if there is one.
+func (c *Chat) Context(i int) (RetrievedContext, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, rc := range c.contexts {
+		if rc.MessageIndex == i {
+			return rc, true
+		}
+	}
+	return RetrievedContext{}, false
+}
+
+// Sources returns the documents the model had at hand when it wrote the message at index i: those of the contexts
+// before it that weren't superseded yet, latest first and without duplicates.
+func (c *Chat) Sources(i int) []schema.Document {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var contexts []RetrievedContext
+	for _, rc := range c.contexts {
+		if rc.MessageIndex < i && (rc.SupersededBy == 0 || rc.SupersededBy > i) {
+			contexts = append(contexts, rc)
+		}
+	}
+	slices.SortFunc(contexts, func(a, b RetrievedContext) int { return b.MessageIndex - a.MessageIndex })
+	var docs []schema.Document
+	seen := make(map[string]bool)
+	for _, rc := range contexts {
+		for _, d := range rc.Documents {
+			if k := documentKey(d); !seen[k] {
+				seen[k] = true
+				docs = append(docs, d)
+			}
+		}
+	}
+	return docs
+}
+
+// activeContexts returns the contexts that are still sent to the model, oldest first.
+// The caller must hold the lock.
+func (c *Chat) activeContexts() []*RetrievedContext {
+	var active []*RetrievedContext
+	for j := range c.contexts {
+		if c.contexts[j].SupersededBy == 0 {
+			active = append(active, &c.contexts[j])
+		}
+	}
+	slices.SortFunc(active, func(a, b *RetrievedContext) int { return a.MessageIndex - b.MessageIndex })
+	return active
+}
+
+// mergeContexts returns the newly retrieved documents followed by those of the active contexts (latest first) that
+// aren't among them, up to Keep turns' worth of documents.
+// The caller must hold the lock.
+func (c *Chat) mergeContexts(docs []schema.Document) []schema.Document {
+	merged := slices.Clone(docs)
+	seen := make(map[string]bool)
+	for _, d := range docs {
+		seen[documentKey(d)] = true
+	}
+	active := c.activeContexts()
+	for j := len(active) - 1; j >= 0; j-- {
+		for _, d := range active[j].Documents {
+			if k := documentKey(d); !seen[k] {
+				seen[k] = true
+				merged = append(merged, d)
+			}
+		}
+	}
+	if len(docs) > 0 {
+		merged = merged[:min(len(merged), c.contextPolicy.Keep*len(docs))]
+	}
+	return merged
+}
+
+// supersedeContexts applies the context policy after a context was added at index i, marking the contexts that are
+// no longer sent to the model as superseded by it.
+// The caller must hold the lock.
+func (c *Chat) supersedeContexts(i int) {
+	var keep int
+	switch c.contextPolicy.Mode {
+	case ContextPolicyAll:
+		return
+	case ContextPolicyLast:
+		keep = c.contextPolicy.Keep
+	default:
+		keep = 1
+	}
+	active := c.activeContexts()
+	for _, rc := range active[:max(len(active)-keep, 0)] {
+		if rc.MessageIndex != i {
+			rc.SupersededBy = i
+		}
+	}
+}
+
+// documentKey identifies a document across retrievals: by the ID of its fragment, or its content if it has none.
+func documentKey(d schema.Document) string {
+	if id, ok := d.Metadata["DocId"]; ok {
+		return fmt.Sprint(id)
+	}
+	return d.PageContent
+}
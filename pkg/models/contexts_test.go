@@ -0,0 +1,128 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// sent describes the contexts of the chat that are still sent to the model, oldest first, by the IDs of their fragments.
+func sent(c *Chat) []string {
+	var s []string
+	for _, rc := range c.activeContexts() {
+		var ids []string
+		for _, d := range rc.Documents {
+			ids = append(ids, d.PageContent)
+		}
+		s = append(s, strings.Join(ids, ","))
+	}
+	return s
+}
+
+func TestContextPolicies(t *testing.T) {
+	// Three turns, each retrieving a note that was retrieved for the turn before:
+	//
+	//	0 sys, 1 ctx:a,b, 2 q1, 3 a1, 4 ctx:b,c, 5 q2, 6 a2, 7 ctx:c,d, 8 q3, 9 a3
+	retrieved := [][]string{{"a", "b"}, {"b", "c"}, {"c", "d"}}
+	tests := []struct {
+		policy       ContextPolicy
+		wantSent     []string
+		wantContexts []placement
+		// wantSources are the documents behind the last answer
+		wantSources string
+	}{
+		{
+			policy:       ContextPolicy{Mode: ContextPolicyAll},
+			wantSent:     []string{"a,b", "b,c", "c,d"},
+			wantContexts: []placement{{1, 0}, {4, 0}, {7, 0}},
+			wantSources:  "c,d,b,a",
+		},
+		{
+			policy:       DefaultContextPolicy,
+			wantSent:     []string{"c,d"},
+			wantContexts: []placement{{1, 4}, {4, 7}, {7, 0}},
+			wantSources:  "c,d",
+		},
+		{
+			policy:       ContextPolicy{Mode: ContextPolicyLast, Keep: 2},
+			wantSent:     []string{"b,c", "c,d"},
+			wantContexts: []placement{{1, 7}, {4, 0}, {7, 0}},
+			wantSources:  "c,d,b",
+		},
+		{
+			policy:       ContextPolicy{Mode: ContextPolicyLast, Keep: 5},
+			wantSent:     []string{"a,b", "b,c", "c,d"},
+			wantContexts: []placement{{1, 0}, {4, 0}, {7, 0}},
+			wantSources:  "c,d,b,a",
+		},
+		{
+			// The merged context holds the new documents first, then the earlier ones that weren't retrieved again
+			policy:       ContextPolicy{Mode: ContextPolicyMerge, Keep: 2},
+			wantSent:     []string{"c,d,b,a"},
+			wantContexts: []placement{{1, 4}, {4, 7}, {7, 0}},
+			wantSources:  "c,d,b,a",
+		},
+		{
+			// Up to Keep turns' worth of documents
+			policy:       ContextPolicy{Mode: ContextPolicyMerge, Keep: 1},
+			wantSent:     []string{"c,d"},
+			wantContexts: []placement{{1, 4}, {4, 7}, {7, 0}},
+			wantSources:  "c,d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.policy.Mode, tt.policy.Keep), func(t *testing.T) {
+			c, err := NewChat(WithContextPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ids := range retrieved {
+				c.AppendUserMessage("q")
+				if err := c.AddContextsForLastMessage(docs(ids...)); err != nil {
+					t.Fatal(err)
+				}
+				c.AppendMessage(llms.TextParts(llms.ChatMessageTypeAI, "a"))
+			}
+			if got := sent(c); !slices.Equal(got, tt.wantSent) {
+				t.Errorf("sent = %q, want %q", got, tt.wantSent)
+			}
+			if got := placements(c); !slices.Equal(got, tt.wantContexts) {
+				t.Errorf("contexts = %v, want %v", got, tt.wantContexts)
+			}
+			var sources []string
+			for _, d := range c.Sources(9) {
+				sources = append(sources, d.PageContent)
+			}
+			if got := strings.Join(sources, ","); got != tt.wantSources {
+				t.Errorf("sources of the last answer = %s, want %s", got, tt.wantSources)
+			}
+			// Superseded contexts are left out of the window
+			w := c.Window(Budget{})
+			if got, want := len(w.Messages), 10-len(retrieved)+len(tt.wantSent); got != want {
+				t.Errorf("window holds %d messages, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestContextPolicyValidate(t *testing.T) {
+	tests := []struct {
+		policy  ContextPolicy
+		wantErr bool
+	}{
+		{ContextPolicy{Mode: ContextPolicyAll}, false},
+		{DefaultContextPolicy, false},
+		{ContextPolicy{Mode: ContextPolicyLast, Keep: 3}, false},
+		{ContextPolicy{Mode: ContextPolicyLast}, true},
+		{ContextPolicy{Mode: ContextPolicyMerge, Keep: 0}, true},
+		{ContextPolicy{Mode: "newest"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: err = %v, want an error: %v", tt.policy, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package models
+
+import (
+	"slices"
+
+	"github.com/clocklear/texttrove/pkg/tokens"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// messageOverhead accounts for the role markers and separators the model sees around each message.
+const messageOverhead = 4
+
+// Budget describes the room a conversation has in the model's context window.
+type Budget struct {
+	// Limit is the size of the context window in tokens (num_ctx); zero means unlimited
+	Limit int
+	// Reserve is kept free for the answer
+	Reserve int
+	// Estimate counts the tokens in a text; see tokens.ForModel
+	Estimate tokens.Estimator
+}
+
+// Usage describes how much of the context window a conversation takes up.
+type Usage struct {
+	Tokens int
+	Limit  int
+	// DroppedContexts and DroppedTurns count what was left out to make the conversation fit
+	DroppedContexts int
+	DroppedTurns    int
+	// Summarized reports whether earlier turns are included as a summary
+	Summarized bool
+}
+
+// Window is the part of a conversation that's sent to the model.
+type Window struct {
+	Messages []llms.MessageContent
+	// Dropped holds the messages of the turns that were left out to make room, oldest first, so they can be summarized.
+	// Retrieved contexts aren't included.
+	Dropped []llms.MessageContent
+	// DroppedThrough is the index of the first message after the dropped turns
+	DroppedThrough int
+	Usage          Usage
+	// system is the number of Messages before the summary (or where it would go): the system prompt, if any
+	system int
+}
+
+// WithSummary returns the messages of the window with a summary of the dropped turns (and of the summary before it, if
+// any) in their place, as the window will have once it's recorded with SetSummary.
+func (w Window) WithSummary(summary string) []llms.MessageContent {
+	msgs := slices.Clone(w.Messages[:w.system])
+	msgs = append(msgs, summaryMessage(summary))
+	rest := w.Messages[w.system:]
+	if w.Usage.Summarized {
+		// Replace the earlier summary
+		rest = rest[1:]
+	}
+	return append(msgs, rest...)
+}
+
+// summaryMessage renders the summary of the earlier conversation.
+func summaryMessage(summary string) llms.MessageContent {
+	return llms.TextParts(llms.ChatMessageTypeSystem, "Summary of the earlier conversation:\n"+summary)
+}
+
+// SetSummary records a summary of the messages before index through, which takes their place in the context window.
+func (c *Chat) SetSummary(summary string, through int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.summary = summary
+	c.summaryThrough = through
+	c.revision++
+}
+
+// Summary returns the summary of earlier messages, if any, and the index of the first message it doesn't cover.
+func (c *Chat) Summary() (string, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.summary, c.summaryThrough
+}
+
+// Window returns the messages that fit the given budget.  The system prompt, the current turn (from the last user
+// message on) and the contexts retrieved for it are always kept.  To make room, contexts retrieved for earlier turns go
+// first, then the oldest turns, each with the answers and tool calls that followed it.  Messages covered by the summary
+// are replaced by it, and superseded contexts are left out altogether.
+func (c *Chat) Window(b Budget) Window {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	estimate := b.Estimate
+	if estimate == nil {
+		estimate = tokens.ForModel(c.model)
+	}
+	msgs := c.completedMessages
+	isContext := make(map[int]bool, len(c.contexts))
+	superseded := make(map[int]bool)
+	for _, rc := range c.contexts {
+		isContext[rc.MessageIndex] = true
+		if rc.SupersededBy > 0 {
+			superseded[rc.MessageIndex] = true
+		}
+	}
+
+	// The current turn and the contexts retrieved for it are never dropped
+	current := len(msgs)
+	if i := c.lastUserMessageIndex(); i >= 0 {
+		current = i
+		for current > 0 && isContext[current-1] {
+			current--
+		}
+	}
+
+	// Split what comes before into the system prompt, stale contexts and turns
+	start := 0
+	var prompt []llms.MessageContent
+	if len(msgs) > 0 && msgs[0].Role == llms.ChatMessageTypeSystem && !isContext[0] {
+		prompt = append(prompt, msgs[0])
+		start = 1
+	}
+	system := len(prompt)
+	summarized := c.summary != "" && c.summaryThrough > start && c.summaryThrough <= current
+	if summarized {
+		prompt = append(prompt, summaryMessage(c.summary))
+		start = c.summaryThrough
+	}
+	type turn struct {
+		start, end int
+	}
+	var staleContexts []int
+	var turns []turn
+	for i := start; i < current; i++ {
+		switch {
+		case superseded[i]:
+			continue
+		case isContext[i]:
+			staleContexts = append(staleContexts, i)
+		case msgs[i].Role == llms.ChatMessageTypeHuman || len(turns) == 0:
+			turns = append(turns, turn{start: i, end: i + 1})
+		default:
+			turns[len(turns)-1].end = i + 1
+		}
+	}
+
+	size := func(m llms.MessageContent) int {
+		return estimate(messageText(m)+toolText(m)) + messageOverhead
+	}
+	total := 0
+	for _, m := range prompt {
+		total += size(m)
+	}
+	for i := start; i < len(msgs); i++ {
+		if !superseded[i] {
+			total += size(msgs[i])
+		}
+	}
+
+	w := Window{Usage: Usage{Limit: b.Limit, Summarized: summarized}, DroppedThrough: start, system: system}
+	dropped := make(map[int]bool)
+	budget := b.Limit - b.Reserve
+	for b.Limit > 0 && total > budget && len(staleContexts) > 0 {
+		i := staleContexts[0]
+		staleContexts = staleContexts[1:]
+		dropped[i] = true
+		total -= size(msgs[i])
+		w.Usage.DroppedContexts++
+	}
+	for b.Limit > 0 && total > budget && len(turns) > 0 {
+		t := turns[0]
+		turns = turns[1:]
+		for i := t.start; i < t.end; i++ {
+			if isContext[i] {
+				continue
+			}
+			dropped[i] = true
+			total -= size(msgs[i])
+			w.Dropped = append(w.Dropped, msgs[i])
+		}
+		w.DroppedThrough = t.end
+		w.Usage.DroppedTurns++
+	}
+
+	w.Messages = slices.Clone(prompt)
+	for i := start; i < len(msgs); i++ {
+		if !dropped[i] && !superseded[i] {
+			w.Messages = append(w.Messages, msgs[i])
+		}
+	}
+	w.Usage.Tokens = total
+	return w
+}
+
+// toolText renders the tool calls and tool results of a message, which messageText leaves out.
+func toolText(m llms.MessageContent) string {
+	var s string
+	for _, part := range m.Parts {
+		switch p := part.(type) {
+		case llms.ToolCall:
+			if p.FunctionCall != nil {
+				s += p.FunctionCall.Name + p.FunctionCall.Arguments
+			}
+		case llms.ToolCallResponse:
+			s += p.Content
+		}
+	}
+	return s
+}
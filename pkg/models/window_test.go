@@ -0,0 +1,203 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// countWords makes every one-word message take up 1+messageOverhead = 5 tokens.
+func countWords(s string) int {
+	return len(strings.Fields(s))
+}
+
+// newWindowTestChat returns a chat of three turns, each with a context retrieved for it:
+//
+//	0 sys, 1 ctx1, 2 q1, 3 a1, 4 ctx2, 5 q2, 6 a2, 7 ctx3, 8 q3
+func newWindowTestChat() *Chat {
+	c := &Chat{contextPolicy: ContextPolicy{Mode: ContextPolicyAll}}
+	msgs := []struct {
+		role llms.ChatMessageType
+		text string
+	}{
+		{llms.ChatMessageTypeSystem, "sys"},
+		{llms.ChatMessageTypeSystem, "ctx1"},
+		{llms.ChatMessageTypeHuman, "q1"},
+		{llms.ChatMessageTypeAI, "a1"},
+		{llms.ChatMessageTypeSystem, "ctx2"},
+		{llms.ChatMessageTypeHuman, "q2"},
+		{llms.ChatMessageTypeAI, "a2"},
+		{llms.ChatMessageTypeSystem, "ctx3"},
+		{llms.ChatMessageTypeHuman, "q3"},
+	}
+	for _, m := range msgs {
+		c.completedMessages = append(c.completedMessages, llms.TextParts(m.role, m.text))
+	}
+	for _, i := range []int{1, 4, 7} {
+		c.contexts = append(c.contexts, RetrievedContext{MessageIndex: i})
+	}
+	return c
+}
+
+// texts returns the text of each message, with the summary shortened to "summary: " and what it says.
+func texts(msgs []llms.MessageContent) []string {
+	s := make([]string, len(msgs))
+	for i, m := range msgs {
+		s[i] = strings.Replace(messageText(m), "Summary of the earlier conversation:\n", "summary: ", 1)
+	}
+	return s
+}
+
+func TestWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *Chat)
+		limit int
+		// reserve is kept free for the answer
+		reserve     int
+		want        []string
+		wantDropped []string
+		wantThrough int
+		wantUsage   Usage
+	}{
+		{
+			name:      "no limit",
+			want:      []string{"sys", "ctx1", "q1", "a1", "ctx2", "q2", "a2", "ctx3", "q3"},
+			wantUsage: Usage{Tokens: 45},
+		},
+		{
+			name:      "fits",
+			limit:     45,
+			want:      []string{"sys", "ctx1", "q1", "a1", "ctx2", "q2", "a2", "ctx3", "q3"},
+			wantUsage: Usage{Tokens: 45, Limit: 45},
+		},
+		{
+			name:      "stale contexts go first, oldest first",
+			limit:     40,
+			want:      []string{"sys", "q1", "a1", "ctx2", "q2", "a2", "ctx3", "q3"},
+			wantUsage: Usage{Tokens: 40, Limit: 40, DroppedContexts: 1},
+		},
+		{
+			name:      "room is kept for the answer",
+			limit:     45,
+			reserve:   5,
+			want:      []string{"sys", "q1", "a1", "ctx2", "q2", "a2", "ctx3", "q3"},
+			wantUsage: Usage{Tokens: 40, Limit: 45, DroppedContexts: 1},
+		},
+		{
+			name:      "every stale context before any turn",
+			limit:     35,
+			want:      []string{"sys", "q1", "a1", "q2", "a2", "ctx3", "q3"},
+			wantUsage: Usage{Tokens: 35, Limit: 35, DroppedContexts: 2},
+		},
+		{
+			name:        "then the oldest turns",
+			limit:       30,
+			want:        []string{"sys", "q2", "a2", "ctx3", "q3"},
+			wantDropped: []string{"q1", "a1"},
+			wantThrough: 4,
+			wantUsage:   Usage{Tokens: 25, Limit: 30, DroppedContexts: 2, DroppedTurns: 1},
+		},
+		{
+			name:        "the current turn and its context are always kept",
+			limit:       10,
+			want:        []string{"sys", "ctx3", "q3"},
+			wantDropped: []string{"q1", "a1", "q2", "a2"},
+			wantThrough: 7,
+			wantUsage:   Usage{Tokens: 15, Limit: 10, DroppedContexts: 2, DroppedTurns: 2},
+		},
+		{
+			name:      "superseded contexts are left out without counting as dropped",
+			setup:     func(c *Chat) { c.contexts[0].SupersededBy = 4 },
+			limit:     40,
+			want:      []string{"sys", "q1", "a1", "ctx2", "q2", "a2", "ctx3", "q3"},
+			wantUsage: Usage{Tokens: 40, Limit: 40},
+		},
+		{
+			// The summary message is six words: "Summary of the earlier conversation: earlier"
+			name:        "the summary stands in for the turns it covers",
+			setup:       func(c *Chat) { c.summary, c.summaryThrough = "earlier", 4 },
+			want:        []string{"sys", "summary: earlier", "ctx2", "q2", "a2", "ctx3", "q3"},
+			wantThrough: 4,
+			wantUsage:   Usage{Tokens: 40, Summarized: true},
+		},
+		{
+			name:        "turns after the summary are dropped next",
+			setup:       func(c *Chat) { c.summary, c.summaryThrough = "earlier", 4 },
+			limit:       30,
+			want:        []string{"sys", "summary: earlier", "ctx3", "q3"},
+			wantDropped: []string{"q2", "a2"},
+			wantThrough: 7,
+			wantUsage:   Usage{Tokens: 25, Limit: 30, DroppedContexts: 1, DroppedTurns: 1, Summarized: true},
+		},
+		{
+			name:      "a summary reaching into the current turn is ignored",
+			setup:     func(c *Chat) { c.summary, c.summaryThrough = "earlier", 9 },
+			want:      []string{"sys", "ctx1", "q1", "a1", "ctx2", "q2", "a2", "ctx3", "q3"},
+			wantUsage: Usage{Tokens: 45},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newWindowTestChat()
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			w := c.Window(Budget{Limit: tt.limit, Reserve: tt.reserve, Estimate: countWords})
+			if got := texts(w.Messages); !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if got := texts(w.Dropped); !slices.Equal(got, tt.wantDropped) && len(got)+len(tt.wantDropped) > 0 {
+				t.Errorf("dropped = %q, want %q", got, tt.wantDropped)
+			}
+			wantThrough := tt.wantThrough
+			if wantThrough == 0 {
+				// Nothing before the system prompt was dropped
+				wantThrough = 1
+			}
+			if w.DroppedThrough != wantThrough {
+				t.Errorf("dropped through %d, want %d", w.DroppedThrough, wantThrough)
+			}
+			if w.Usage != tt.wantUsage {
+				t.Errorf("usage = %+v, want %+v", w.Usage, tt.wantUsage)
+			}
+		})
+	}
+}
+
+func TestWindowWithSummary(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *Chat)
+		want  []string
+	}{
+		{
+			name: "takes the place of the dropped turns",
+			want: []string{"sys", "summary: new", "q2", "a2", "ctx3", "q3"},
+		},
+		{
+			name:  "replaces the earlier summary",
+			setup: func(c *Chat) { c.summary, c.summaryThrough = "earlier", 4 },
+			want:  []string{"sys", "summary: new", "ctx3", "q3"},
+		},
+		{
+			name:  "goes first without a system prompt",
+			setup: func(c *Chat) { c.completedMessages[0] = llms.TextParts(llms.ChatMessageTypeHuman, "q0") },
+			want:  []string{"summary: new", "q1", "a1", "q2", "a2", "ctx3", "q3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newWindowTestChat()
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			w := c.Window(Budget{Limit: 30, Estimate: countWords})
+			if got := texts(w.WithSummary("new")); !slices.Equal(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
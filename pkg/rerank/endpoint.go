@@ -0,0 +1,84 @@
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Endpoint scores texts with a dedicated rerank endpoint, typically backed by a cross-encoder.  It speaks the
+// request/response format shared by Cohere, Jina, vLLM and llama.cpp server (run with --reranking).
+type Endpoint struct {
+	client *http.Client
+	url    string
+	model  string
+}
+
+// NewEndpoint returns a reranker that posts to the given URL, e.g. http://localhost:8080/v1/rerank.
+// Authenticate by adding an Authorization header to the client.
+func NewEndpoint(client *http.Client, url, model string) *Endpoint {
+	return &Endpoint{
+		client: client,
+		url:    url,
+		model:  model,
+	}
+}
+
+func (e *Endpoint) Rerank(ctx context.Context, query string, texts []string) ([]float64, error) {
+	b, err := json.Marshal(map[string]any{
+		"model":     e.model,
+		"query":     query,
+		"documents": texts,
+		"top_n":     len(texts),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rerank API returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var res struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rerank response: %w", err)
+	}
+	// Results come back sorted by score, not in the order of the texts
+	scores := make([]float64, len(texts))
+	seen := make([]bool, len(texts))
+	for _, r := range res.Results {
+		if r.Index < 0 || r.Index >= len(texts) {
+			continue
+		}
+		scores[r.Index] = r.RelevanceScore
+		seen[r.Index] = true
+	}
+	for i, ok := range seen {
+		if !ok {
+			return nil, fmt.Errorf("rerank response is missing a score for document %d", i)
+		}
+	}
+	return scores, nil
+}
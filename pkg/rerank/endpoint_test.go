@@ -0,0 +1,89 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEndpointRerank(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    []float64
+		wantErr string
+	}{
+		{
+			name:   "results are put back in the order of the texts",
+			status: http.StatusOK,
+			body:   `{"results":[{"index":2,"relevance_score":0.9},{"index":0,"relevance_score":0.5},{"index":1,"relevance_score":0.1}]}`,
+			want:   []float64{0.5, 0.1, 0.9},
+		},
+		{
+			name:   "out of range indexes are ignored",
+			status: http.StatusOK,
+			body:   `{"results":[{"index":0,"relevance_score":1},{"index":1,"relevance_score":2},{"index":2,"relevance_score":3},{"index":7,"relevance_score":4}]}`,
+			want:   []float64{1, 2, 3},
+		},
+		{
+			name:    "a missing score is an error",
+			status:  http.StatusOK,
+			body:    `{"results":[{"index":0,"relevance_score":1},{"index":2,"relevance_score":3}]}`,
+			wantErr: "missing a score for document 1",
+		},
+		{
+			name:    "an error status is reported with the body",
+			status:  http.StatusBadRequest,
+			body:    "model not loaded\n",
+			wantErr: "400 Bad Request: model not loaded",
+		},
+		{
+			name:    "a malformed response is an error",
+			status:  http.StatusOK,
+			body:    `{"results":`,
+			wantErr: "failed to parse rerank response",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]any
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("request = %s with Content-Type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+				}
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("failed to decode request: %v", err)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			e := NewEndpoint(srv.Client(), srv.URL, "bge-reranker")
+			scores, err := e.Rerank(context.Background(), "what is x?", []string{"a", "b", "c"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(scores, tt.want) {
+				t.Errorf("scores = %v, want %v", scores, tt.want)
+			}
+			if got["model"] != "bge-reranker" || got["query"] != "what is x?" || got["top_n"] != float64(3) {
+				t.Errorf("request = %v, want the model, query and top_n of 3", got)
+			}
+			if docs, _ := got["documents"].([]any); len(docs) != 3 {
+				t.Errorf("documents = %v, want the 3 texts", got["documents"])
+			}
+		})
+	}
+}
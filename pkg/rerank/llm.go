@@ -0,0 +1,143 @@
+package rerank
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Method decides how an LLM reranker prompts the model.
+type Method string
+
+const (
+	// Pointwise asks the model to rate each candidate on its own, one request per candidate
+	Pointwise Method = "pointwise"
+	// Listwise asks the model to order all the candidates in a single request
+	Listwise Method = "listwise"
+)
+
+// Candidates are cut to these lengths (in runes) in prompts; a listwise prompt holds all of them at once.
+const (
+	maxPointwiseLength = 2000
+	maxListwiseLength  = 600
+)
+
+const pointwisePrompt = `You are judging search results for a question about the user's notes.
+
+Question: %s
+
+Passage:
+"""
+%s
+"""
+
+How useful is the passage for answering the question, on a scale from 0 (irrelevant) to 10 (answers it directly)?
+Reply with the number only.`
+
+const listwisePrompt = `You are ranking search results for a question about the user's notes.
+
+Question: %s
+
+Passages:
+%s
+Order the passages from most to least useful for answering the question. Reply with the passage numbers only,
+separated by commas, e.g. 3, 1, 2. Leave out passages that are irrelevant.`
+
+var numberPattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// LLM scores texts by prompting a conversation model.
+type LLM struct {
+	llm         llms.Model
+	method      Method
+	concurrency int
+}
+
+// NewLLM returns a reranker that prompts the given model using the given method.  Pointwise rerankers score up to
+// concurrency candidates at once.
+func NewLLM(llm llms.Model, method Method, concurrency int) (*LLM, error) {
+	if method != Pointwise && method != Listwise {
+		return nil, fmt.Errorf("unknown rerank method %s", method)
+	}
+	return &LLM{
+		llm:         llm,
+		method:      method,
+		concurrency: max(concurrency, 1),
+	}, nil
+}
+
+func (l *LLM) Rerank(ctx context.Context, query string, texts []string) ([]float64, error) {
+	if l.method == Listwise {
+		return l.listwise(ctx, query, texts)
+	}
+	return l.pointwise(ctx, query, texts)
+}
+
+// pointwise asks the model for a 0-10 score for each text.  Replies without a number score zero.
+func (l *LLM) pointwise(ctx context.Context, query string, texts []string) ([]float64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	scores := make([]float64, len(texts))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	slots := make(chan struct{}, l.concurrency)
+	for i, t := range texts {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			reply, err := llms.GenerateFromSinglePrompt(ctx, l.llm, fmt.Sprintf(pointwisePrompt, query, truncate(t, maxPointwiseLength)), llms.WithTemperature(0))
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			if m := numberPattern.FindString(reply); m != "" {
+				s, _ := strconv.ParseFloat(m, 64)
+				scores[i] = min(s, 10)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return scores, nil
+}
+
+// listwise asks the model to order the texts.  Texts are scored by their position in the reply; those left out score
+// zero.
+func (l *LLM) listwise(ctx context.Context, query string, texts []string) ([]float64, error) {
+	var sb strings.Builder
+	for i, t := range texts {
+		fmt.Fprintf(&sb, "[%d] %s\n\n", i+1, strings.TrimSpace(truncate(t, maxListwiseLength)))
+	}
+	reply, err := llms.GenerateFromSinglePrompt(ctx, l.llm, fmt.Sprintf(listwisePrompt, query, sb.String()), llms.WithTemperature(0))
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]float64, len(texts))
+	rank := 0
+	for _, m := range numberPattern.FindAllString(reply, -1) {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 1 || n > len(texts) || scores[n-1] > 0 {
+			// Not a passage number, or one we've already seen
+			continue
+		}
+		scores[n-1] = float64(len(texts) - rank)
+		rank++
+	}
+	return scores, nil
+}
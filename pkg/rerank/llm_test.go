@@ -0,0 +1,153 @@
+package rerank
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// stubModel replies to each prompt with reply, which is given the prompt.
+type stubModel struct {
+	reply func(prompt string) (string, error)
+
+	mu      sync.Mutex
+	prompts []string
+}
+
+func (m *stubModel) GenerateContent(ctx context.Context, msgs []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	var prompt string
+	for _, p := range msgs[len(msgs)-1].Parts {
+		if t, ok := p.(llms.TextContent); ok {
+			prompt += t.Text
+		}
+	}
+	m.mu.Lock()
+	m.prompts = append(m.prompts, prompt)
+	m.mu.Unlock()
+	reply, err := m.reply(prompt)
+	if err != nil {
+		return nil, err
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: reply}}}, nil
+}
+
+func (m *stubModel) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, opts...)
+}
+
+func TestPointwise(t *testing.T) {
+	// Each passage is named after the reply the model gives for it
+	texts := []string{"7", "Score: 8.5/10", "I'd say 42", "no idea", "10"}
+	m := &stubModel{reply: func(prompt string) (string, error) {
+		for _, t := range texts {
+			if strings.Contains(prompt, "\"\"\"\n"+t+"\n\"\"\"") {
+				return t, nil
+			}
+		}
+		return "", errors.New("unexpected prompt")
+	}}
+	l, err := NewLLM(m, Pointwise, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scores, err := l.Rerank(context.Background(), "what is x?", texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Numbers are capped at 10 and replies without one score zero
+	if want := []float64{7, 8.5, 10, 0, 10}; !slices.Equal(scores, want) {
+		t.Errorf("scores = %v, want %v", scores, want)
+	}
+	if len(m.prompts) != len(texts) {
+		t.Errorf("prompted %d times, want once per text", len(m.prompts))
+	}
+	if !strings.Contains(m.prompts[0], "Question: what is x?") {
+		t.Errorf("prompt = %q, want it to contain the question", m.prompts[0])
+	}
+}
+
+func TestPointwiseError(t *testing.T) {
+	m := &stubModel{reply: func(prompt string) (string, error) {
+		if strings.Contains(prompt, "bad") {
+			return "", errors.New("model went away")
+		}
+		return "5", nil
+	}}
+	l, err := NewLLM(m, Pointwise, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Rerank(context.Background(), "q", []string{"good", "bad", "good"}); err == nil || err.Error() != "model went away" {
+		t.Errorf("err = %v, want the model's error", err)
+	}
+}
+
+func TestListwise(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  []float64
+	}{
+		{"full order", "3, 1, 2", []float64{2, 1, 3}},
+		{"left out passages score zero", "2", []float64{0, 3, 0}},
+		{"repeats and out of range numbers are ignored", "Ranking: [2] > [2] > [9] > [0] > [1]", []float64{2, 3, 0}},
+		{"nothing relevant", "None of them.", []float64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &stubModel{reply: func(string) (string, error) { return tt.reply, nil }}
+			l, err := NewLLM(m, Listwise, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scores, err := l.Rerank(context.Background(), "q", []string{"a", "b", "c"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(scores, tt.want) {
+				t.Errorf("scores = %v, want %v", scores, tt.want)
+			}
+			if len(m.prompts) != 1 || !strings.Contains(m.prompts[0], "[1] a\n\n[2] b\n\n[3] c") {
+				t.Errorf("prompts = %q, want a single prompt listing the passages", m.prompts)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"llm", Config{Type: TypeLLM, LLM: &stubModel{}, Method: Pointwise}, false},
+		{"llm without a model", Config{Type: TypeLLM, Method: Pointwise}, true},
+		{"llm with an unknown method", Config{Type: TypeLLM, LLM: &stubModel{}, Method: "pairwise"}, true},
+		{"endpoint", Config{Type: TypeEndpoint, URL: "http://localhost:8080/v1/rerank"}, false},
+		{"endpoint without a URL", Config{Type: TypeEndpoint}, true},
+		{"unknown type", Config{Type: "magic"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("héllo", 10); got != "héllo" {
+		t.Errorf("truncate = %q, want it unchanged", got)
+	}
+	if got := truncate("héllo", 2); got != "hé…" {
+		t.Errorf("truncate = %q, want %q", got, "hé…")
+	}
+}
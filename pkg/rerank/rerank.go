@@ -0,0 +1,69 @@
+// Package rerank scores fragments retrieved for a query, so that only the best of a larger set of candidates is handed
+// to the conversation model.
+package rerank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// Reranker types
+const (
+	// TypeLLM prompts the conversation model to judge the candidates
+	TypeLLM = "llm"
+	// TypeEndpoint posts the candidates to a dedicated rerank (cross-encoder) endpoint
+	TypeEndpoint = "endpoint"
+)
+
+// Reranker scores texts against a query; higher is more relevant.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, texts []string) ([]float64, error)
+}
+
+// Config describes a reranker.
+type Config struct {
+	// Type is one of TypeLLM or TypeEndpoint
+	Type string
+	// LLM and Method are used by TypeLLM rerankers
+	LLM    llms.Model
+	Method Method
+	// Concurrency is the number of candidates scored at once by pointwise LLM rerankers
+	Concurrency int
+	// URL, Model and Client are used by TypeEndpoint rerankers
+	URL    string
+	Model  string
+	Client *http.Client
+}
+
+// New creates the reranker described by cfg.
+func New(cfg Config) (Reranker, error) {
+	switch cfg.Type {
+	case TypeLLM:
+		if cfg.LLM == nil {
+			return nil, errors.New("an llm reranker needs a model")
+		}
+		return NewLLM(cfg.LLM, cfg.Method, cfg.Concurrency)
+	case TypeEndpoint:
+		if cfg.URL == "" {
+			return nil, errors.New("an endpoint reranker needs a URL")
+		}
+		if cfg.Client == nil {
+			cfg.Client = http.DefaultClient
+		}
+		return NewEndpoint(cfg.Client, cfg.URL, cfg.Model), nil
+	}
+	return nil, fmt.Errorf("unknown rerank type %s", cfg.Type)
+}
+
+// truncate shortens s to at most n runes, so that long fragments don't crowd out the rest of a prompt.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clocklear/texttrove/pkg/models"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// Ragger describes what we expect to be true of a thing that can RAG documents
+type Ragger interface {
+	Query(ctx context.Context, queryText string, nResults int, where, whereDocument map[string]any) ([]schema.Document, error)
+	// EmbedBatch embeds many texts at once, in batches as configured for indexing
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Config describes the dependencies of a Server.
+type Config struct {
+	// LLM is the model that augmented chat requests are forwarded to
+	LLM llms.Model
+	// ModelName is the name of the chat model reported to clients
+	ModelName string
+	// EmbeddingModelName is the name of the embedding model reported to clients
+	EmbeddingModelName string
+	// APIKey, when set, must be sent by clients as a bearer token (Authorization: Bearer <key>)
+	APIKey string
+	// RAG is used to find context for chat requests and to serve search and embedding requests
+	RAG Ragger
+	// NewChat creates a fresh chat, used to render the system and context templates for each request
+	NewChat func() (*models.Chat, error)
+	// MaxDocumentResults is the number of fragments added as context to each chat request
+	MaxDocumentResults int
+	// Logger receives a line for each request; may be nil
+	Logger func(string)
+}
+
+// Server exposes an OpenAI-compatible API where chat completions are augmented
+// with content from the knowledge base.
+type Server struct {
+	cfg Config
+}
+
+func New(cfg Config) *Server {
+	return &Server{cfg: cfg}
+}
+
+// Handler returns an http.Handler serving the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/models", s.handleModels)
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("POST /v1/embeddings", s.handleEmbeddings)
+	mux.HandleFunc("POST /v1/search", s.handleSearch)
+	if s.cfg.APIKey == "" {
+		return mux
+	}
+	return s.requireKey(mux)
+}
+
+// requireKey rejects requests that don't carry the configured API key as a bearer token.
+func (s *Server) requireKey(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.cfg.APIKey)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing API key"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	list := modelList{
+		Object: "list",
+		Data: []modelInfo{
+			{ID: s.cfg.ModelName, Object: "model", OwnedBy: "texttrove"},
+		},
+	}
+	if s.cfg.EmbeddingModelName != "" && s.cfg.EmbeddingModelName != s.cfg.ModelName {
+		list.Data = append(list.Data, modelInfo{ID: s.cfg.EmbeddingModelName, Object: "model", OwnedBy: "texttrove"})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req chatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+
+	ctx := r.Context()
+	msgs, err := s.augment(ctx, req.Messages)
+	if err != nil {
+		var bre badRequestError
+		if errors.As(err, &bre) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	opts := []llms.CallOption{}
+	if req.Temperature != nil {
+		opts = append(opts, llms.WithTemperature(*req.Temperature))
+	}
+	if req.MaxTokens > 0 {
+		opts = append(opts, llms.WithMaxTokens(req.MaxTokens))
+	}
+	if len(req.Stop) > 0 {
+		opts = append(opts, llms.WithStopWords(req.Stop))
+	}
+
+	id := "chatcmpl-" + randomID()
+	created := time.Now().Unix()
+	if req.Stream {
+		s.streamChatCompletion(ctx, w, id, created, msgs, opts)
+		return
+	}
+
+	res, err := s.cfg.LLM.GenerateContent(ctx, msgs, opts...)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	content := ""
+	if len(res.Choices) > 0 {
+		content = res.Choices[0].Content
+	}
+	stop := "stop"
+	writeJSON(w, http.StatusOK, chatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   s.cfg.ModelName,
+		Choices: []chatCompletionChoice{{
+			Message:      &chatMessageOutput{Role: "assistant", Content: content},
+			FinishReason: &stop,
+		}},
+	})
+}
+
+func (s *Server) streamChatCompletion(ctx context.Context, w http.ResponseWriter, id string, created int64, msgs []llms.MessageContent, opts []llms.CallOption) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(delta chatMessageOutput, finishReason *string) error {
+		b, err := json.Marshal(chatCompletionResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   s.cfg.ModelName,
+			Choices: []chatCompletionChoice{{Delta: &delta, FinishReason: finishReason}},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", b)
+		flusher.Flush()
+		return err
+	}
+
+	stop := "stop"
+	// The first chunk announces the role, as OpenAI does
+	if err := send(chatMessageOutput{Role: "assistant"}, nil); err != nil {
+		return
+	}
+	opts = append(opts, llms.WithStreamingFunc(func(ctx context.Context, chunk []byte) error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		return send(chatMessageOutput{Content: string(chunk)}, nil)
+	}))
+	_, err := s.cfg.LLM.GenerateContent(ctx, msgs, opts...)
+	if err != nil {
+		// Headers are already gone; report the error in-band, then end the stream so clients stop waiting
+		s.log(fmt.Sprintf("err: chat completion failed: %v", err))
+		b, _ := json.Marshal(errorResponse{Error: errorDetail{Message: err.Error(), Type: "server_error"}})
+		fmt.Fprintf(w, "data: %s\n\n", b)
+	} else if err := send(chatMessageOutput{}, &stop); err != nil {
+		return
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// augment converts the request messages into a chat, adding content from the
+// knowledge base relevant to the last user message as context.
+func (s *Server) augment(ctx context.Context, msgs []chatMessage) ([]llms.MessageContent, error) {
+	lastUser := -1
+	for i, m := range msgs {
+		if m.Role == "user" {
+			lastUser = i
+		}
+	}
+	if lastUser == -1 {
+		return nil, badRequestError{errors.New("messages must contain at least one user message")}
+	}
+
+	chat, err := s.cfg.NewChat()
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range msgs {
+		if i == lastUser {
+			q := string(m.Content)
+			s.log(fmt.Sprintf("API query: %s", q))
+			ctxs, err := s.cfg.RAG.Query(ctx, q, s.cfg.MaxDocumentResults, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			err = chat.AddContexts(ctxs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var msg llms.ChatMessage
+		switch m.Role {
+		case "system", "developer":
+			msg = llms.SystemChatMessage{Content: string(m.Content)}
+		case "user":
+			msg = llms.HumanChatMessage{Content: string(m.Content)}
+		case "assistant":
+			msg = llms.AIChatMessage{Content: string(m.Content)}
+		default:
+			return nil, badRequestError{fmt.Errorf("unsupported message role %q", m.Role)}
+		}
+		err = chat.AddMessage(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chat.Log(), nil
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req embeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if len(req.Input) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("input must not be empty"))
+		return
+	}
+
+	vectors, err := s.cfg.RAG.EmbedBatch(r.Context(), req.Input)
+	if err == nil && len(vectors) != len(req.Input) {
+		err = fmt.Errorf("asked for %d embeddings but got %d", len(req.Input), len(vectors))
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	res := embeddingResponse{
+		Object: "list",
+		Data:   make([]embeddingData, 0, len(vectors)),
+		Model:  s.cfg.EmbeddingModelName,
+	}
+	for i, v := range vectors {
+		res.Data = append(res.Data, embeddingData{Object: "embedding", Index: i, Embedding: v})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req searchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, errors.New("query must not be empty"))
+		return
+	}
+	n := req.N
+	if n <= 0 {
+		n = s.cfg.MaxDocumentResults
+	}
+
+	docs, err := s.cfg.RAG.Query(r.Context(), req.Query, n, req.Where, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res := searchResponse{
+		Object: "list",
+		Data:   make([]searchResult, 0, len(docs)),
+	}
+	for i, d := range docs {
+		res.Data = append(res.Data, searchResult{
+			Rank:     i + 1,
+			Score:    d.Score,
+			Source:   fmt.Sprintf("%v", d.Metadata["Source"]),
+			Content:  d.PageContent,
+			Metadata: d.Metadata,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) log(msg string) {
+	if s.cfg.Logger == nil {
+		return
+	}
+	s.cfg.Logger(msg)
+}
+
+// badRequestError marks errors caused by the client.
+type badRequestError struct {
+	error
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	t := "server_error"
+	if status == http.StatusBadRequest {
+		t = "invalid_request_error"
+	}
+	writeJSON(w, status, errorResponse{Error: errorDetail{Message: err.Error(), Type: t}})
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/clocklear/texttrove/pkg/models"
+
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// fakeLLM answers with reply, streaming it in chunks if asked to, or fails with err.
+type fakeLLM struct {
+	chunks []string
+	err    error
+
+	mu  sync.Mutex
+	got [][]llms.MessageContent
+}
+
+func (f *fakeLLM) GenerateContent(ctx context.Context, msgs []llms.MessageContent, opts ...llms.CallOption) (*llms.ContentResponse, error) {
+	f.mu.Lock()
+	f.got = append(f.got, msgs)
+	f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	var o llms.CallOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.StreamingFunc != nil {
+		for _, c := range f.chunks {
+			if err := o.StreamingFunc(ctx, []byte(c)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: strings.Join(f.chunks, "")}}}, nil
+}
+
+func (f *fakeLLM) Call(ctx context.Context, prompt string, opts ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, f, prompt, opts...)
+}
+
+// fakeRAG retrieves docs for every query and embeds texts as their lengths.
+type fakeRAG struct {
+	docs     []schema.Document
+	embedErr error
+
+	mu      sync.Mutex
+	queries []string
+	batches [][]string
+}
+
+func (f *fakeRAG) Query(ctx context.Context, queryText string, nResults int, where, whereDocument map[string]any) ([]schema.Document, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, queryText)
+	return f.docs[:min(nResults, len(f.docs))], nil
+}
+
+func (f *fakeRAG) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, texts)
+	if f.embedErr != nil {
+		return nil, f.embedErr
+	}
+	vectors := make([][]float32, len(texts))
+	for i, t := range texts {
+		vectors[i] = []float32{float32(len(t))}
+	}
+	return vectors, nil
+}
+
+func newTestServer(t *testing.T, llm *fakeLLM, rag *fakeRAG, apiKey string) *httptest.Server {
+	t.Helper()
+	s := New(Config{
+		LLM:                llm,
+		ModelName:          "chat-model",
+		EmbeddingModelName: "embed-model",
+		APIKey:             apiKey,
+		RAG:                rag,
+		NewChat: func() (*models.Chat, error) {
+			return models.NewChat()
+		},
+		MaxDocumentResults: 2,
+	})
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func post(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	res, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func decode[T any](t *testing.T, res *http.Response) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return v
+}
+
+func TestModels(t *testing.T) {
+	ts := newTestServer(t, &fakeLLM{}, &fakeRAG{}, "")
+	res, err := http.Get(ts.URL + "/v1/models")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode)
+	}
+	list := decode[modelList](t, res)
+	var ids []string
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+	}
+	if list.Object != "list" || !slices.Equal(ids, []string{"chat-model", "embed-model"}) {
+		t.Errorf("models = %+v, want chat-model and embed-model", list)
+	}
+}
+
+func TestChatCompletion(t *testing.T) {
+	llm := &fakeLLM{chunks: []string{"Hello", " there"}}
+	rag := &fakeRAG{docs: []schema.Document{{PageContent: "kubernetes runbook"}}}
+	ts := newTestServer(t, llm, rag, "")
+
+	res := post(t, ts.URL+"/v1/chat/completions", `{"model":"x","messages":[{"role":"user","content":"first"},{"role":"assistant","content":"ok"},{"role":"user","content":[{"type":"text","text":"how do I deploy?"}]}]}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode)
+	}
+	got := decode[chatCompletionResponse](t, res)
+	if got.Object != "chat.completion" || got.Model != "chat-model" || len(got.Choices) != 1 {
+		t.Fatalf("response = %+v", got)
+	}
+	if c := got.Choices[0]; c.Message == nil || c.Message.Content != "Hello there" || c.FinishReason == nil || *c.FinishReason != "stop" {
+		t.Errorf("choice = %+v, want the whole answer with finish reason stop", c)
+	}
+	// Only the last user message is searched for, and its contexts are rendered into the prompt
+	if !slices.Equal(rag.queries, []string{"how do I deploy?"}) {
+		t.Errorf("queries = %q, want the last user message", rag.queries)
+	}
+	var prompt strings.Builder
+	for _, m := range llm.got[0] {
+		for _, p := range m.Parts {
+			if tp, ok := p.(llms.TextContent); ok {
+				prompt.WriteString(tp.Text)
+			}
+		}
+	}
+	if !strings.Contains(prompt.String(), "kubernetes runbook") {
+		t.Errorf("prompt doesn't hold the retrieved context:\n%s", prompt.String())
+	}
+}
+
+// readEvents returns the data of each server-sent event in the response.
+func readEvents(t *testing.T, res *http.Response) []string {
+	t.Helper()
+	var events []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestChatCompletionStreaming(t *testing.T) {
+	ts := newTestServer(t, &fakeLLM{chunks: []string{"Hel", "lo"}}, &fakeRAG{}, "")
+	res := post(t, ts.URL+"/v1/chat/completions", `{"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q, want text/event-stream", ct)
+	}
+	events := readEvents(t, res)
+	if len(events) == 0 || events[len(events)-1] != "[DONE]" {
+		t.Fatalf("events = %q, want them to end with [DONE]", events)
+	}
+
+	var content strings.Builder
+	var role, finish string
+	for _, e := range events[:len(events)-1] {
+		var chunk chatCompletionResponse
+		if err := json.Unmarshal([]byte(e), &chunk); err != nil {
+			t.Fatalf("bad chunk %q: %v", e, err)
+		}
+		if chunk.Object != "chat.completion.chunk" || len(chunk.Choices) != 1 || chunk.Choices[0].Delta == nil {
+			t.Fatalf("bad chunk %q", e)
+		}
+		c := chunk.Choices[0]
+		if c.Delta.Role != "" {
+			role = c.Delta.Role
+		}
+		content.WriteString(c.Delta.Content)
+		if c.FinishReason != nil {
+			finish = *c.FinishReason
+		}
+	}
+	if role != "assistant" || content.String() != "Hello" || finish != "stop" {
+		t.Errorf("streamed role %q, content %q, finish reason %q; want assistant, Hello, stop", role, content.String(), finish)
+	}
+}
+
+func TestChatCompletionStreamingError(t *testing.T) {
+	ts := newTestServer(t, &fakeLLM{err: errors.New("model crashed")}, &fakeRAG{}, "")
+	res := post(t, ts.URL+"/v1/chat/completions", `{"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	events := readEvents(t, res)
+	if len(events) < 2 || events[len(events)-1] != "[DONE]" {
+		t.Fatalf("events = %q, want an error followed by [DONE]", events)
+	}
+	var er errorResponse
+	if err := json.Unmarshal([]byte(events[len(events)-2]), &er); err != nil || !strings.Contains(er.Error.Message, "model crashed") {
+		t.Errorf("event before [DONE] = %q, want the error", events[len(events)-2])
+	}
+}
+
+func TestEmbeddings(t *testing.T) {
+	rag := &fakeRAG{}
+	ts := newTestServer(t, &fakeLLM{}, rag, "")
+	res := post(t, ts.URL+"/v1/embeddings", `{"model":"x","input":["a","bcd"]}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", res.StatusCode)
+	}
+	got := decode[embeddingResponse](t, res)
+	if got.Model != "embed-model" {
+		t.Errorf("model = %q, want the embedding model", got.Model)
+	}
+	if len(got.Data) != 2 || got.Data[0].Index != 0 || got.Data[1].Index != 1 || got.Data[1].Embedding[0] != 3 {
+		t.Errorf("data = %+v, want an embedding per input, in order", got.Data)
+	}
+	if len(rag.batches) != 1 || len(rag.batches[0]) != 2 {
+		t.Errorf("embedded in batches %q, want a single batch of both inputs", rag.batches)
+	}
+
+	// A single string is a single input
+	res = post(t, ts.URL+"/v1/embeddings", `{"input":"one"}`)
+	if got := decode[embeddingResponse](t, res); len(got.Data) != 1 {
+		t.Errorf("data = %+v, want a single embedding", got.Data)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ts := newTestServer(t, &fakeLLM{}, &fakeRAG{}, "secret")
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong key", "Bearer nope", http.StatusUnauthorized},
+		{"not a bearer token", "secret", http.StatusUnauthorized},
+		{"right key", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/models", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized {
+				if er := decode[errorResponse](t, res); er.Error.Message == "" {
+					t.Error("want an error message")
+				}
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		llm      *fakeLLM
+		rag      *fakeRAG
+		path     string
+		body     string
+		status   int
+		wantType string
+	}{
+		{"malformed chat request", &fakeLLM{}, &fakeRAG{}, "/v1/chat/completions", `{"messages":`, http.StatusBadRequest, "invalid_request_error"},
+		{"no user message", &fakeLLM{}, &fakeRAG{}, "/v1/chat/completions", `{"messages":[{"role":"system","content":"x"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"unknown role", &fakeLLM{}, &fakeRAG{}, "/v1/chat/completions", `{"messages":[{"role":"robot","content":"x"},{"role":"user","content":"y"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"LLM failure", &fakeLLM{err: errors.New("down")}, &fakeRAG{}, "/v1/chat/completions", `{"messages":[{"role":"user","content":"x"}]}`, http.StatusBadGateway, "server_error"},
+		{"empty embedding input", &fakeLLM{}, &fakeRAG{}, "/v1/embeddings", `{"input":[]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"embedding failure", &fakeLLM{}, &fakeRAG{embedErr: errors.New("down")}, "/v1/embeddings", `{"input":"x"}`, http.StatusBadGateway, "server_error"},
+		{"empty search query", &fakeLLM{}, &fakeRAG{}, "/v1/search", `{"query":""}`, http.StatusBadRequest, "invalid_request_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.llm, tt.rag, "")
+			res := post(t, ts.URL+tt.path, tt.body)
+			if res.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.status)
+			}
+			if er := decode[errorResponse](t, res); er.Error.Type != tt.wantType || er.Error.Message == "" {
+				t.Errorf("error = %+v, want type %s with a message", er.Error, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	rag := &fakeRAG{docs: []schema.Document{
+		{PageContent: "a", Score: 0.9, Metadata: map[string]any{"Source": "a.md"}},
+		{PageContent: "b", Score: 0.5, Metadata: map[string]any{"Source": "b.md"}},
+	}}
+	ts := newTestServer(t, &fakeLLM{}, rag, "")
+	res := post(t, ts.URL+"/v1/search", `{"query":"x","n":1}`)
+	got := decode[searchResponse](t, res)
+	if len(got.Data) != 1 || got.Data[0].Rank != 1 || got.Data[0].Source != "a.md" || got.Data[0].Score != 0.9 {
+		t.Errorf("results = %+v, want the best one", got.Data)
+	}
+}
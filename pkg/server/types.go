@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The types in this file mirror the subset of the OpenAI API that we support.
+
+type chatCompletionRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Stream      bool          `json:"stream"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Stop        stringList    `json:"stop,omitempty"`
+}
+
+type chatMessage struct {
+	Role    string         `json:"role"`
+	Content messageContent `json:"content"`
+}
+
+// messageContent accepts either a plain string or an array of content parts; only text parts are kept.
+type messageContent string
+
+func (c *messageContent) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*c = messageContent(s)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(b, &parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of content parts")
+	}
+	sb := strings.Builder{}
+	for _, p := range parts {
+		if p.Type == "text" {
+			sb.WriteString(p.Text)
+		}
+	}
+	*c = messageContent(sb.String())
+	return nil
+}
+
+// stringList accepts either a single string or an array of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*l = []string{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return fmt.Errorf("must be a string or an array of strings")
+	}
+	*l = ss
+	return nil
+}
+
+type chatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []chatCompletionChoice `json:"choices"`
+}
+
+type chatCompletionChoice struct {
+	Index        int                `json:"index"`
+	Message      *chatMessageOutput `json:"message,omitempty"`
+	Delta        *chatMessageOutput `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type chatMessageOutput struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+type embeddingRequest struct {
+	Model string     `json:"model"`
+	Input stringList `json:"input"`
+}
+
+type embeddingResponse struct {
+	Object string          `json:"object"`
+	Data   []embeddingData `json:"data"`
+	Model  string          `json:"model"`
+}
+
+type embeddingData struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"`
+	Embedding []float32 `json:"embedding"`
+}
+
+type searchRequest struct {
+	Query string         `json:"query"`
+	N     int            `json:"n,omitempty"`
+	Where map[string]any `json:"where,omitempty"`
+}
+
+type searchResponse struct {
+	Object string         `json:"object"`
+	Data   []searchResult `json:"data"`
+}
+
+type searchResult struct {
+	Rank     int            `json:"rank"`
+	Score    float32        `json:"score"`
+	Source   string         `json:"source"`
+	Content  string         `json:"content"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type modelList struct {
+	Object string      `json:"object"`
+	Data   []modelInfo `json:"data"`
+}
+
+type modelInfo struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type errorResponse struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
@@ -0,0 +1,63 @@
+// Package tokens estimates how many tokens a text takes up in a model's context window.  Real tokenizers differ per
+// model and aren't available offline, so estimates are based on the typical number of characters per token of each
+// model family, erring on the high side.
+package tokens
+
+import (
+	"math"
+	"strings"
+)
+
+// Estimator estimates the number of tokens in a text.
+type Estimator func(text string) int
+
+// charsPerToken holds the typical number of (ASCII) characters per token of model families, by name prefix.  Models
+// with bigger vocabularies fit more text in a token.
+var charsPerToken = []struct {
+	prefix string
+	chars  float64
+}{
+	{"llama3", 4.0},
+	{"llama-3", 4.0},
+	{"gemma", 4.0},
+	{"qwen", 3.8},
+	{"deepseek", 3.8},
+	{"gpt-4o", 4.2},
+	{"gpt-4", 4.0},
+	{"gpt-3.5", 4.0},
+	{"llama2", 3.3},
+	{"mistral", 3.3},
+	{"mixtral", 3.3},
+	{"phi", 3.3},
+}
+
+// defaultCharsPerToken is used for unknown models; it's on the low side, so estimates are on the high side.
+const defaultCharsPerToken = 3.3
+
+// ForModel returns an estimator for the given model name (e.g. llama3.2:latest).
+func ForModel(model string) Estimator {
+	ratio := defaultCharsPerToken
+	name := strings.ToLower(model)
+	// Strip any namespace, e.g. hf.co/bartowski/
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	for _, c := range charsPerToken {
+		if strings.HasPrefix(name, c.prefix) {
+			ratio = c.chars
+			break
+		}
+	}
+	return func(text string) int {
+		var ascii, other int
+		for _, r := range text {
+			if r < 128 {
+				ascii++
+			} else {
+				// Non-Latin scripts take about a token per character
+				other++
+			}
+		}
+		return int(math.Ceil(float64(ascii)/ratio)) + other
+	}
+}